@@ -0,0 +1,134 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const backendHue = "hue"
+
+// LightBackend represents a bridge or controller Kelvin uses to discover
+// and control devices. Every configured bridge is handled by the backend
+// matching its type.
+type LightBackend interface {
+	// InitializeBridge connects to the bridge configured at the given index.
+	// Waiting for the user to register Kelvin is aborted once ctx is done.
+	InitializeBridge(ctx context.Context, configuration *Configuration, index int) error
+	// Lights returns all devices of the bridge as new lights.
+	Lights() ([]*Light, error)
+	// LightStates returns the current state of all devices indexed by their ID.
+	LightStates() (map[int]DeviceState, error)
+	// LightEvents returns a source reporting every change of a device.
+	// If the bridge doesn't support events nil is returned.
+	LightEvents() LightEventSource
+
+	bridgeID() string
+	deviceDirectory() (DeviceDirectory, error)
+}
+
+// Device represents a single light of a LightBackend. The scheduling
+// engine only talks to lights via this interface.
+type Device interface {
+	name() string
+	isReachable() bool
+	isOn() bool
+	supportsColorTemperature() bool
+	supportsBrightness() bool
+	supportsColor() bool
+	colorTemperatureRange() (int, int)
+	updateCurrentLightState(state DeviceState)
+	setLightState(colorTemperature int, brightness int, transitionTime time.Duration) error
+	hasChanged() bool
+	hasColorTemperatureChanged() bool
+	hasBrightnessChanged() bool
+	hasState(colorTemperature int, brightness int) bool
+	stateDescription() string
+	// observedState returns the state last reported by the device. It is
+	// saved to detect changes while Kelvin was stopped.
+	observedState() DeviceState
+}
+
+// switchableDevice is implemented by devices Kelvin is able to turn on.
+type switchableDevice interface {
+	// turnOn switches the device on and sets the given light state.
+	turnOn(colorTemperature int, brightness int, transitionTime time.Duration) error
+}
+
+// DeviceState contains the current state of a device as reported by its
+// backend. The concrete type depends on the backend.
+type DeviceState interface{}
+
+// LightEventSource reports changes of devices as soon as they happen.
+type LightEventSource interface {
+	// Start connects to the event source and keeps reconnecting whenever
+	// the connection drops. It returns when the given context is done.
+	Start(ctx context.Context)
+	// IsConnected returns true if events are received. If it returns false
+	// light states have to be polled.
+	IsConnected() bool
+	Events() <-chan LightEvent
+}
+
+// LightEvent represents a change of the current state of a light
+// reported by a backend.
+type LightEvent struct {
+	ID    int
+	State DeviceState
+}
+
+// closableBackend is implemented by backends holding connections which
+// have to be closed when Kelvin stops or reloads its configuration.
+type closableBackend interface {
+	close()
+}
+
+// sceneBackend is implemented by backends supporting Kelvin scenes.
+type sceneBackend interface {
+	updateScenes()
+}
+
+// groupBackend is implemented by backends able to update several
+// lights with a single request.
+type groupBackend interface {
+	updateGroups()
+	updateLightsViaGroups(lights []*Light, transitionTime time.Duration) map[int]bool
+}
+
+// newLightBackend returns an uninitialized backend for the given bridge type.
+func newLightBackend(bridgeType string) (LightBackend, error) {
+	switch bridgeType {
+	case "", backendHue:
+		return &HueBridge{}, nil
+	case backendZigbee2MQTT:
+		return &Zigbee2MQTTBridge{}, nil
+	case backendLIFX:
+		return &LIFXBridge{}, nil
+	case backendWLED:
+		return &WLEDBridge{}, nil
+	case backendDeconz:
+		return &DeconzBridge{}, nil
+	}
+	return nil, fmt.Errorf("Unknown bridge type %s", bridgeType)
+}
@@ -22,52 +22,113 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	hue "github.com/stefanwichmann/go.hue"
 )
 
-// HueBridge represents the Philips Hue bridge in
+// HueBridge represents a Philips Hue bridge in
 // your system.
-// It is used to communicate with all devices.
+// It is used to communicate with all devices and implements
+// the LightBackend interface.
 type HueBridge struct {
-	bridge   hue.Bridge
-	BridgeIP string
-	Username string
-	Version  int
+	bridge     hue.Bridge
+	clip       *clipV2Client
+	client     *http.Client
+	limiter    *rateLimiter
+	https      bool
+	groups     []HueGroup
+	ID         string
+	BridgeIP   string
+	Username   string
+	Version    int
+	APIVersion string
 }
 
 const hueBridgeAppName = "kelvin"
 
-// InitializeBridge creates and returns an initialized HueBridge.
+// rateLimiter spaces all requests sent to a bridge, no matter which API
+// they use.
+type rateLimiter struct {
+	interval time.Duration
+	lastCall time.Time
+	mutex    sync.Mutex
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	if *flagDisableRateLimiting {
+		interval = 0
+	}
+	return &rateLimiter{interval: interval}
+}
+
+// wait blocks until the next request may be sent. A nil limiter doesn't
+// limit requests.
+func (limiter *rateLimiter) wait() {
+	if limiter == nil {
+		return
+	}
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if wait := limiter.interval - time.Since(limiter.lastCall); wait > 0 {
+		time.Sleep(wait)
+	}
+	limiter.lastCall = time.Now()
+}
+
+var serialNumberPattern = regexp.MustCompile(`<serialNumber>([0-9a-fA-F]+)</serialNumber>`)
+
+// InitializeBridge initializes the HueBridge configured at the given index.
 // If you have a valid configuration this will be used. Otherwise a local
 // discovery will be started, followed by a user registration on your bridge.
-func (bridge *HueBridge) InitializeBridge(configuration *Configuration) error {
-	err := bridge.discover(configuration.Bridge.IP)
+func (bridge *HueBridge) InitializeBridge(ctx context.Context, configuration *Configuration, index int) error {
+	if index >= len(configuration.Bridges) {
+		return fmt.Errorf("Bridge %d is not configured", index)
+	}
+	bridgeConfiguration := &configuration.Bridges[index]
+
+	// Don't discover bridges which are already configured
+	var configured []string
+	for i, other := range configuration.Bridges {
+		if i != index && other.IP != "" {
+			configured = append(configured, other.IP)
+		}
+	}
+	err := bridge.discover(bridgeConfiguration.IP, configured)
 	if err != nil {
 		return err
 	}
-	configuration.Bridge.IP = bridge.BridgeIP
+	bridgeConfiguration.IP = bridge.BridgeIP
+	if bridgeConfiguration.ID == "" {
+		bridgeConfiguration.ID = bridge.ID
+	}
+	bridge.ID = bridgeConfiguration.ID
 
-	if configuration.Bridge.Username != "" {
-		log.Debugf("⌘ Found bridge username in configuration: %s", configuration.Bridge.Username)
-		bridge.Username = configuration.Bridge.Username
+	if bridgeConfiguration.Username != "" {
+		log.Debugf("⌘ Found bridge username in configuration: %s", bridgeConfiguration.Username)
+		bridge.Username = bridgeConfiguration.Username
 	} else {
 		log.Debugf("⌘ No username found in bridge configuration. Starting registration...")
-		err := bridge.register()
+		err := bridge.register(ctx)
 		if err != nil {
 			return err
 		}
 		log.Debugf("⌘ Saving new username in bridge configuration: %s", bridge.Username)
-		configuration.Bridge.Username = bridge.Username
+		bridgeConfiguration.Username = bridge.Username
 	}
 
 	log.Debugf("⌘ Connecting to bridge %s with username %s", bridge.BridgeIP, bridge.Username)
@@ -75,9 +136,21 @@ func (bridge *HueBridge) InitializeBridge(configuration *Configuration) error {
 	if err != nil {
 		return err
 	}
-	log.Println("⌘ Connection to bridge established")
+	log.Printf("⌘ Connection to bridge %s established", bridge.ID)
 	bridge.validateSofwareVersion()
 
+	bridge.APIVersion = hueAPIv1
+	if bridgeConfiguration.API == hueAPIv2 {
+		err = bridge.connectV2()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Schedules without a bridge refer to the lights of the first bridge
+	if index > 0 {
+		return nil
+	}
 	err = bridge.populateSchedule(configuration)
 	return err
 }
@@ -85,6 +158,10 @@ func (bridge *HueBridge) InitializeBridge(configuration *Configuration) error {
 // Lights return all known lights on your bridge.
 func (bridge *HueBridge) Lights() ([]*Light, error) {
 	var lights []*Light
+	if bridge.clip != nil {
+		return bridge.lightsV2()
+	}
+	bridge.limiter.wait()
 	hueLights, err := bridge.bridge.GetAllLights()
 	if err != nil {
 		return lights, err
@@ -96,12 +173,14 @@ func (bridge *HueBridge) Lights() ([]*Light, error) {
 		if err != nil {
 			return lights, err
 		}
+		light.BridgeID = bridge.ID
 
-		light.HueLight.HueLight = *hueLight
-		light.HueLight.initialize(hueLight.Attributes)
-		light.Name = light.HueLight.Name
-		light.Reachable = light.HueLight.Reachable
-		light.On = light.HueLight.On
+		device := &HueLight{HueLight: *hueLight, limiter: bridge.limiter}
+		device.initialize(hueLight.Attributes)
+		light.Device = device
+		light.Name = device.Name
+		light.Reachable = device.Reachable
+		light.On = device.On
 
 		lights = append(lights, &light)
 	}
@@ -111,8 +190,25 @@ func (bridge *HueBridge) Lights() ([]*Light, error) {
 }
 
 // LightStates returns the current state for lights on the bridge
-func (bridge *HueBridge) LightStates() (map[int]hue.LightAttributes, error) {
+func (bridge *HueBridge) LightStates() (map[int]DeviceState, error) {
+	var states = make(map[int]DeviceState)
+	attributes, err := bridge.lightAttributes()
+	if err != nil {
+		return states, err
+	}
+	for id, attr := range attributes {
+		states[id] = attr
+	}
+	return states, nil
+}
+
+func (bridge *HueBridge) lightAttributes() (map[int]hue.LightAttributes, error) {
 	var states = make(map[int]hue.LightAttributes)
+	if bridge.clip != nil {
+		_, states, err := bridge.clip.lightAttributes()
+		return states, err
+	}
+	bridge.limiter.wait()
 	hueLights, err := bridge.bridge.GetAllLights()
 	if err != nil {
 		return states, err
@@ -130,7 +226,58 @@ func (bridge *HueBridge) LightStates() (map[int]hue.LightAttributes, error) {
 	return states, nil
 }
 
-func (bridge *HueBridge) discover(ip string) error {
+// LightEvents returns a stream reporting every change of a light on
+// the bridge. If the bridge doesn't support events nil is returned.
+func (bridge *HueBridge) LightEvents() LightEventSource {
+	if bridge.clip == nil {
+		return nil
+	}
+	return newLightEventStream(bridge.clip)
+}
+
+func (bridge *HueBridge) lightsV2() ([]*Light, error) {
+	var lights []*Light
+	resources, states, err := bridge.clip.lightAttributes()
+	if err != nil {
+		return lights, err
+	}
+
+	for id, attr := range states {
+		var light Light
+		light.ID = id
+		light.BridgeID = bridge.ID
+		device := &HueLight{}
+		device.initializeV2(resources[id], attr, bridge.clip)
+		light.Device = device
+		light.Name = device.Name
+		light.Reachable = device.Reachable
+		light.On = device.On
+
+		lights = append(lights, &light)
+	}
+
+	sort.Slice(lights, func(i, j int) bool { return lights[i].ID < lights[j].ID })
+	return lights, nil
+}
+
+func (bridge *HueBridge) connectV2() error {
+	if bridge.Version != 2 {
+		return errors.New("The Hue API v2 is only supported by the square Hue bridge (BSB002). Please configure API v1")
+	}
+
+	client := newClipV2Client(bridge.BridgeIP, bridge.Username, bridge.limiter)
+	err := client.validate()
+	if err != nil {
+		return fmt.Errorf("Could not connect to Hue API v2: %v", err)
+	}
+
+	bridge.clip = client
+	bridge.APIVersion = hueAPIv2
+	log.Debugf("⌘ Using Hue API v2 for light control")
+	return nil
+}
+
+func (bridge *HueBridge) discover(ip string, exclude []string) error {
 	if ip != "" {
 		// we have a known IP address. Validate if it points to a reachable bridge
 		bridge.BridgeIP = ip
@@ -151,6 +298,9 @@ func (bridge *HueBridge) discover(ip string) error {
 		return errors.New("Bridge discovery failed. Please configure manually in config.json")
 	}
 	for _, candidate := range bridges {
+		if containsString(exclude, candidate.IpAddr) {
+			continue
+		}
 		bridge.BridgeIP = candidate.IpAddr
 		err := bridge.validateBridge()
 		if err == nil {
@@ -162,30 +312,42 @@ func (bridge *HueBridge) discover(ip string) error {
 	return errors.New("Bridge discovery failed. Please configure manually in config.json")
 }
 
-func (bridge *HueBridge) register() error {
+func (bridge *HueBridge) register(ctx context.Context) error {
 	if bridge.BridgeIP == "" {
 		return errors.New("Registration at bridge not possible because no IP is configured. Start discovery first or enter manually")
 	}
 
 	bridge.bridge = *hue.NewBridge(bridge.BridgeIP, "")
+	username, err := registerUser(ctx, "PLEASE PUSH THE BLUE BUTTON ON YOUR HUE BRIDGE", func() (string, error) {
+		err := bridge.bridge.CreateUser(hueBridgeAppName)
+		return bridge.bridge.Username, err
+	})
+	bridge.Username = username
+	return err
+}
+
+// registerUser asks the user to confirm the registration at a bridge and
+// keeps calling createUser until it returns the new username or ctx is done.
+func registerUser(ctx context.Context, prompt string, createUser func() (string, error)) (string, error) {
 	log.Printf("⌘ Starting user registration.")
-	log.Warningf("⌘ PLEASE PUSH THE BLUE BUTTON ON YOUR HUE BRIDGE")
+	log.Warningf("⌘ %s", prompt)
 	for {
-		time.Sleep(5 * time.Second)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
 
 		// try user creation, will fail if the button wasn't pressed.
-		err := bridge.bridge.CreateUser(hueBridgeAppName)
-		if err != nil {
+		username, err := createUser()
+		if err != nil || username == "" {
 			log.Debugf("⌘ Button wasn't pressed yet. Waiting...")
 			continue
 		}
 
-		if bridge.bridge.Username != "" {
-			// registration successful
-			bridge.Username = bridge.bridge.Username
-			log.Printf("⌘ User registration successful.")
-			return nil
-		}
+		// registration successful
+		log.Printf("⌘ User registration successful.")
+		return username, nil
 	}
 }
 
@@ -198,8 +360,11 @@ func (bridge *HueBridge) connect() error {
 		return errors.New("No username on bridge configured")
 	}
 	bridge.bridge = *hue.NewBridge(bridge.BridgeIP, bridge.Username)
+	bridge.limiter = newRateLimiter(timeBetweenHueAPICalls)
+	bridge.client = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
 
 	// Test bridge
+	bridge.limiter.wait()
 	configuration, err := bridge.bridge.Configuration()
 	if err != nil {
 		return err
@@ -213,11 +378,11 @@ func (bridge *HueBridge) connect() error {
 	}
 	if configuration.ModelId == "BSB002" && swversion >= 1802201122 && !*flagDisableHTTPS {
 		bridge.bridge.EnableHTTPS(true)
+		bridge.https = true
 		log.Debugf("⌘ Enabled HTTPS for the bridge connection")
 	}
 
 	if !*flagDisableRateLimiting {
-		bridge.bridge.EnableRateLimiting(timeBetweenHueAPICalls)
 		log.Debugf("⌘ Enabled rate limiting with %s between API calls", timeBetweenHueAPICalls)
 	}
 
@@ -232,7 +397,7 @@ func (bridge *HueBridge) populateSchedule(configuration *Configuration) error {
 
 	// Do we have associated lights?
 	for _, schedule := range configuration.Schedules {
-		if len(schedule.AssociatedDeviceIDs) > 0 {
+		if len(schedule.AssociatedDeviceIDs) > 0 || schedule.hasNamedDevices() {
 			log.Debugf("⌘ Configuration contains at least one schedule with associated lights.")
 			return nil // At least one schedule is configured
 		}
@@ -252,7 +417,102 @@ func (bridge *HueBridge) populateSchedule(configuration *Configuration) error {
 	return nil
 }
 
+// request sends a request to the v1 API of the bridge for resources
+// not covered by the hue library.
+func (bridge *HueBridge) request(method string, resource string, body interface{}, result interface{}) error {
+	bridge.limiter.wait()
+
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	protocol := "http"
+	if bridge.https {
+		protocol = "https"
+	}
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s/api/%s/%s", protocol, bridge.BridgeIP, bridge.Username, resource), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	resp, err := bridge.client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Errors are reported as a list of error objects
+	var errorResponse []struct {
+		Error *struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &errorResponse) == nil {
+		for _, e := range errorResponse {
+			if e.Error != nil {
+				return fmt.Errorf("Bridge returned error for %s %s: %s", method, resource, e.Error.Description)
+			}
+		}
+	}
+
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// deviceDirectory returns the names of all lights, rooms and zones on the bridge.
+func (bridge *HueBridge) deviceDirectory() (DeviceDirectory, error) {
+	directory := DeviceDirectory{make(map[int]string), make(map[string][]int), make(map[string][]int)}
+	states, err := bridge.lightAttributes()
+	if err != nil {
+		return directory, err
+	}
+	for id, state := range states {
+		directory.Lights[id] = state.Name
+	}
+
+	groups, err := bridge.readGroups()
+	if err != nil {
+		return directory, err
+	}
+	for _, group := range groups {
+		var ids []int
+		for _, light := range group.Lights {
+			id, err := strconv.Atoi(light)
+			if err != nil {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		switch group.Type {
+		case "Room":
+			directory.Rooms[group.Name] = ids
+		case "Zone":
+			directory.Zones[group.Name] = ids
+		}
+	}
+	return directory, nil
+}
+
+func (bridge *HueBridge) bridgeID() string {
+	return bridge.ID
+}
+
 func (bridge *HueBridge) validateSofwareVersion() {
+	bridge.limiter.wait()
 	configuration, err := bridge.bridge.Configuration()
 	if err != nil {
 		log.Warningf("⌘ Could not validate bridge software version: %v", err)
@@ -291,11 +551,17 @@ func (bridge *HueBridge) validateBridge() error {
 	}
 	if strings.Contains(string(data), "<modelNumber>929000226503</modelNumber>") {
 		bridge.Version = 1
-		return nil
-	}
-	if strings.Contains(string(data), "<modelNumber>BSB002</modelNumber>") {
+	} else if strings.Contains(string(data), "<modelNumber>BSB002</modelNumber>") {
 		bridge.Version = 2
-		return nil
+	} else {
+		return fmt.Errorf("Bridge validation failed")
 	}
-	return fmt.Errorf("Bridge validation failed")
+
+	// Identify the bridge by its serial number as its IP may change
+	bridge.ID = bridge.BridgeIP
+	matches := serialNumberPattern.FindStringSubmatch(string(data))
+	if len(matches) == 2 {
+		bridge.ID = strings.ToLower(matches[1])
+	}
+	return nil
 }
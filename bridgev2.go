@@ -0,0 +1,327 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+const hueAPIv1 = "v1"
+const hueAPIv2 = "v2"
+
+// clipV2Client talks to the Hue API v2 (CLIP v2) of a bridge.
+// All requests are sent via HTTPS and authenticated with the
+// hue-application-key header, which equals the username of the v1 API.
+type clipV2Client struct {
+	address string
+	key     string
+	client  *http.Client
+	limiter *rateLimiter
+}
+
+// clipV2Response represents the envelope of every CLIP v2 response.
+type clipV2Response struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Description string `json:"description"`
+	} `json:"errors"`
+}
+
+type clipV2Reference struct {
+	RID   string `json:"rid"`
+	RType string `json:"rtype"`
+}
+
+type clipV2XY struct {
+	X float32 `json:"x"`
+	Y float32 `json:"y"`
+}
+
+// clipV2Light represents a light resource of the CLIP v2 API.
+type clipV2Light struct {
+	ID       string          `json:"id"`
+	IDv1     string          `json:"id_v1"`
+	Owner    clipV2Reference `json:"owner"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Archetype string `json:"archetype"`
+	} `json:"metadata"`
+	On struct {
+		On bool `json:"on"`
+	} `json:"on"`
+	Dimming *struct {
+		Brightness float64 `json:"brightness"`
+	} `json:"dimming"`
+	ColorTemperature *struct {
+		Mirek       *int `json:"mirek"`
+		MirekValid  bool `json:"mirek_valid"`
+		MirekSchema struct {
+			MirekMinimum int `json:"mirek_minimum"`
+			MirekMaximum int `json:"mirek_maximum"`
+		} `json:"mirek_schema"`
+	} `json:"color_temperature"`
+	Color *struct {
+		XY    clipV2XY `json:"xy"`
+		Gamut *struct {
+			Red   clipV2XY `json:"red"`
+			Green clipV2XY `json:"green"`
+			Blue  clipV2XY `json:"blue"`
+		} `json:"gamut"`
+		GamutType string `json:"gamut_type"`
+	} `json:"color"`
+}
+
+// clipV2ZigbeeConnectivity represents the connection state of a device.
+type clipV2ZigbeeConnectivity struct {
+	Owner  clipV2Reference `json:"owner"`
+	Status string          `json:"status"`
+}
+
+func newClipV2Client(address string, key string, limiter *rateLimiter) *clipV2Client {
+	client := &clipV2Client{address: address, key: key, limiter: limiter}
+	// The bridge presents a certificate signed by the Signify root CA
+	// and issued for its bridge ID instead of its IP address.
+	client.client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+	return client
+}
+
+func (client *clipV2Client) request(method string, resource string, body interface{}, result interface{}) error {
+	client.limiter.wait()
+
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, "https://"+client.address+"/clip/v2/resource/"+resource, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("hue-application-key", client.key)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var response clipV2Response
+	err = json.Unmarshal(data, &response)
+	if err != nil {
+		return fmt.Errorf("Could not parse response to %s %s (HTTP %d): %v", method, resource, resp.StatusCode, err)
+	}
+	if len(response.Errors) > 0 {
+		var descriptions []string
+		for _, e := range response.Errors {
+			descriptions = append(descriptions, e.Description)
+		}
+		return fmt.Errorf("Bridge returned errors for %s %s: %s", method, resource, strings.Join(descriptions, ", "))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Bridge returned HTTP %d for %s %s", resp.StatusCode, method, resource)
+	}
+
+	if result != nil {
+		return json.Unmarshal(response.Data, result)
+	}
+	return nil
+}
+
+// validate checks if the bridge accepts the application key on the v2 API.
+func (client *clipV2Client) validate() error {
+	return client.request("GET", "bridge", nil, nil)
+}
+
+func (client *clipV2Client) lights() ([]clipV2Light, error) {
+	var lights []clipV2Light
+	err := client.request("GET", "light", nil, &lights)
+	return lights, err
+}
+
+// reachability returns the connection state of all devices indexed by device ID.
+func (client *clipV2Client) reachability() (map[string]bool, error) {
+	var connectivity []clipV2ZigbeeConnectivity
+	reachable := make(map[string]bool)
+	err := client.request("GET", "zigbee_connectivity", nil, &connectivity)
+	if err != nil {
+		return reachable, err
+	}
+	for _, c := range connectivity {
+		reachable[c.Owner.RID] = c.Status == "connected"
+	}
+	return reachable, nil
+}
+
+// lightAttributes returns all lights of the bridge mapped to the
+// attribute model of the v1 API indexed by their v1 ID.
+func (client *clipV2Client) lightAttributes() (map[int]clipV2Light, map[int]hue.LightAttributes, error) {
+	resources := make(map[int]clipV2Light)
+	attributes := make(map[int]hue.LightAttributes)
+	lights, err := client.lights()
+	if err != nil {
+		return resources, attributes, err
+	}
+	reachable, err := client.reachability()
+	if err != nil {
+		return resources, attributes, err
+	}
+
+	for _, light := range lights {
+		id, err := light.v1ID()
+		if err != nil {
+			return resources, attributes, err
+		}
+		deviceReachable, found := reachable[light.Owner.RID]
+		if !found {
+			// Devices without zigbee connectivity (e.g. via Matter) are considered reachable
+			deviceReachable = true
+		}
+		resources[id] = light
+		attributes[id] = light.attributes(deviceReachable)
+	}
+	return resources, attributes, nil
+}
+
+func (client *clipV2Client) setLightState(id string, state hue.SetLightState) error {
+	body := make(map[string]interface{})
+	if state.On != "" {
+		body["on"] = map[string]bool{"on": state.On != "Off"}
+	}
+	if state.Bri != "" {
+		bri, err := strconv.Atoi(state.Bri)
+		if err != nil {
+			return err
+		}
+		body["dimming"] = map[string]float64{"brightness": math.Min(100, math.Round(float64(bri)/254*10000)/100)}
+	}
+	// If both color modes are present the v1 API prefers xy colors. Do the same.
+	if len(state.Xy) == 2 {
+		body["color"] = map[string]clipV2XY{"xy": {state.Xy[0], state.Xy[1]}}
+	} else if state.Ct != "" {
+		ct, err := strconv.Atoi(state.Ct)
+		if err != nil {
+			return err
+		}
+		body["color_temperature"] = map[string]int{"mirek": ct}
+	}
+	if state.TransitionTime != "" {
+		transitionTime, err := strconv.Atoi(state.TransitionTime)
+		if err != nil {
+			return err
+		}
+		body["dynamics"] = map[string]int{"duration": transitionTime * 100}
+	}
+
+	return client.request("PUT", "light/"+id, body, nil)
+}
+
+func (light *clipV2Light) v1ID() (int, error) {
+	if !strings.HasPrefix(light.IDv1, "/lights/") {
+		return 0, fmt.Errorf("Light %s (%s) has no v1 identifier", light.Metadata.Name, light.ID)
+	}
+	return strconv.Atoi(strings.TrimPrefix(light.IDv1, "/lights/"))
+}
+
+// lightType returns the v1 light type matching the capabilities of this light.
+func (light *clipV2Light) lightType() string {
+	if light.Color != nil && light.ColorTemperature != nil {
+		return "Extended color light"
+	}
+	if light.Color != nil {
+		return "Color light"
+	}
+	if light.ColorTemperature != nil {
+		return "Color temperature light"
+	}
+	if light.Dimming != nil {
+		return "Dimmable light"
+	}
+	return "On/Off plug-in unit"
+}
+
+func (light *clipV2Light) attributes(reachable bool) hue.LightAttributes {
+	var attr hue.LightAttributes
+	attr.Name = light.Metadata.Name
+	attr.Type = light.lightType()
+	attr.ModelId = light.Metadata.Archetype
+	attr.SoftwareVersion = hueAPIv2
+	attr.State.On = light.On.On
+	attr.State.Reachable = reachable
+	if light.Dimming != nil {
+		attr.State.Bri = int(math.Round(light.Dimming.Brightness / 100 * 254))
+	}
+	if light.Color != nil {
+		attr.State.Xy = []float32{light.Color.XY.X, light.Color.XY.Y}
+		attr.State.ColorMode = "xy"
+	}
+	if light.ColorTemperature != nil && light.ColorTemperature.MirekValid && light.ColorTemperature.Mirek != nil {
+		attr.State.Ct = *light.ColorTemperature.Mirek
+		attr.State.ColorMode = "ct"
+	}
+	return attr
+}
+
+// colorTemperatureRange returns the supported color temperatures in Kelvin
+// as reported by the mirek schema of the light.
+func (light *clipV2Light) colorTemperatureRange() (int, int) {
+	if light.ColorTemperature == nil || light.ColorTemperature.MirekSchema.MirekMinimum == 0 || light.ColorTemperature.MirekSchema.MirekMaximum == 0 {
+		return 0, 0
+	}
+	return 1000000 / light.ColorTemperature.MirekSchema.MirekMaximum, 1000000 / light.ColorTemperature.MirekSchema.MirekMinimum
+}
+
+// gamut returns the red, green and blue corners of the color gamut of this light.
+func (light *clipV2Light) gamut() [][]float32 {
+	if light.Color == nil || light.Color.Gamut == nil {
+		return nil
+	}
+	g := light.Color.Gamut
+	return [][]float32{{g.Red.X, g.Red.Y}, {g.Green.X, g.Green.Y}, {g.Blue.X, g.Blue.Y}}
+}
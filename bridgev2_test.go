@@ -0,0 +1,282 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// Recorded from a Hue bridge (firmware 1.60) and shortened to the relevant fields.
+const clipV2Lights = `{"errors": [], "data": [
+  {"id": "3f8e4b6a-5c1d-4c1e-9a53-0c6f1b8d2e11", "id_v1": "/lights/1", "type": "light",
+   "owner": {"rid": "a1b2c3d4-0001-4000-8000-000000000001", "rtype": "device"},
+   "metadata": {"name": "Living room", "archetype": "sultan_bulb"},
+   "on": {"on": true},
+   "dimming": {"brightness": 49.8, "min_dim_level": 0.2},
+   "color_temperature": {"mirek": 366, "mirek_valid": true, "mirek_schema": {"mirek_minimum": 153, "mirek_maximum": 500}},
+   "color": {"xy": {"x": 0.4573, "y": 0.41}, "gamut": {"red": {"x": 0.6915, "y": 0.3083}, "green": {"x": 0.17, "y": 0.7}, "blue": {"x": 0.1532, "y": 0.0475}}, "gamut_type": "C"},
+   "mode": "normal"},
+  {"id": "9c0d2f7e-1b3a-4e5f-8a6b-7c8d9e0f1a22", "id_v1": "/lights/4", "type": "light",
+   "owner": {"rid": "a1b2c3d4-0002-4000-8000-000000000002", "rtype": "device"},
+   "metadata": {"name": "Desk", "archetype": "classic_bulb"},
+   "on": {"on": false},
+   "dimming": {"brightness": 100.0, "min_dim_level": 2.0},
+   "color_temperature": {"mirek": null, "mirek_valid": false, "mirek_schema": {"mirek_minimum": 153, "mirek_maximum": 454}},
+   "mode": "normal"},
+  {"id": "5e6f7a8b-9c0d-4e1f-a2b3-c4d5e6f7a833", "id_v1": "/lights/7", "type": "light",
+   "owner": {"rid": "a1b2c3d4-0003-4000-8000-000000000003", "rtype": "device"},
+   "metadata": {"name": "Strip", "archetype": "hue_lightstrip"},
+   "on": {"on": true},
+   "dimming": {"brightness": 12.5, "min_dim_level": 1.0},
+   "color": {"xy": {"x": 0.3227, "y": 0.329}, "gamut": {"red": {"x": 0.704, "y": 0.296}, "green": {"x": 0.2151, "y": 0.7106}, "blue": {"x": 0.138, "y": 0.08}}, "gamut_type": "A"},
+   "mode": "normal"}
+]}`
+
+const clipV2Connectivity = `{"errors": [], "data": [
+  {"id": "f0e1d2c3-0001-4000-8000-00000000000a", "type": "zigbee_connectivity", "status": "connected", "mac_address": "00:17:88:01:04:e4:55:17",
+   "owner": {"rid": "a1b2c3d4-0001-4000-8000-000000000001", "rtype": "device"}},
+  {"id": "f0e1d2c3-0002-4000-8000-00000000000b", "type": "zigbee_connectivity", "status": "connectivity_issue", "mac_address": "00:17:88:01:04:e4:55:18",
+   "owner": {"rid": "a1b2c3d4-0002-4000-8000-000000000002", "rtype": "device"}}
+]}`
+
+// fakeClipV2 emulates the resources and the eventstream of the CLIP v2 API
+// and records the bodies of all PUT requests indexed by resource path.
+// Every string sent to events is written to the eventstream as is.
+type fakeClipV2 struct {
+	server *httptest.Server
+	bodies map[string][]byte
+	events chan string
+}
+
+func newFakeClipV2(t *testing.T) (*fakeClipV2, *clipV2Client) {
+	fake := &fakeClipV2{bodies: make(map[string][]byte), events: make(chan string)}
+	fake.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("hue-application-key") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": [{"description": "unauthorized user"}], "data": []}`))
+			return
+		}
+		resource := strings.TrimPrefix(r.URL.Path, "/clip/v2/resource/")
+		switch {
+		case r.URL.Path == "/eventstream/clip/v2":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case chunk, ok := <-fake.events:
+					if !ok {
+						return
+					}
+					w.Write([]byte(chunk))
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+		case r.Method == "GET" && resource == "light":
+			w.Write([]byte(clipV2Lights))
+		case r.Method == "GET" && resource == "zigbee_connectivity":
+			w.Write([]byte(clipV2Connectivity))
+		case r.Method == "PUT" && strings.HasPrefix(resource, "light/"):
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			fake.bodies[resource] = body
+			w.Write([]byte(`{"errors": [], "data": [{"rid": "` + strings.TrimPrefix(resource, "light/") + `", "rtype": "light"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return fake, newClipV2Client(strings.TrimPrefix(fake.server.URL, "https://"), "secret", nil)
+}
+
+func TestClipV2LightID(t *testing.T) {
+	var tests = []struct {
+		idv1  string
+		id    int
+		valid bool
+	}{
+		{"/lights/1", 1, true},
+		{"/lights/42", 42, true},
+		{"", 0, false},
+		{"/groups/3", 0, false},
+		{"/lights/abc", 0, false},
+	}
+
+	for _, tc := range tests {
+		light := clipV2Light{IDv1: tc.idv1}
+		id, err := light.v1ID()
+		if (err == nil) != tc.valid {
+			t.Errorf("Parsing %q returned error %v; want valid: %t", tc.idv1, err, tc.valid)
+		}
+		if tc.valid && id != tc.id {
+			t.Errorf("Parsing %q returned ID %d; want %d", tc.idv1, id, tc.id)
+		}
+	}
+}
+
+func TestClipV2LightAttributes(t *testing.T) {
+	fake, client := newFakeClipV2(t)
+	defer fake.server.Close()
+
+	resources, attributes, err := client.lightAttributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		id         int
+		name       string
+		lightType  string
+		on         bool
+		reachable  bool
+		bri        int
+		colorMode  string
+		ct         int
+		minimum    int
+		maximum    int
+		gamutGreen []float32
+	}{
+		{1, "Living room", "Extended color light", true, true, 126, "ct", 366, 2000, 6535, []float32{0.17, 0.7}},
+		{4, "Desk", "Color temperature light", false, false, 254, "", 0, 2202, 6535, nil},
+		{7, "Strip", "Color light", true, true, 32, "xy", 0, 0, 0, []float32{0.2151, 0.7106}},
+	}
+
+	if len(attributes) != len(tests) || len(resources) != len(tests) {
+		t.Fatalf("Found %d lights; want %d", len(attributes), len(tests))
+	}
+	for _, tc := range tests {
+		attr, found := attributes[tc.id]
+		if !found {
+			t.Errorf("Light %d not found", tc.id)
+			continue
+		}
+		if attr.Name != tc.name || attr.Type != tc.lightType {
+			t.Errorf("Light %d is %q (%s); want %q (%s)", tc.id, attr.Name, attr.Type, tc.name, tc.lightType)
+		}
+		if attr.State.On != tc.on || attr.State.Reachable != tc.reachable {
+			t.Errorf("Light %d has on: %t, reachable: %t; want on: %t, reachable: %t", tc.id, attr.State.On, attr.State.Reachable, tc.on, tc.reachable)
+		}
+		if attr.State.Bri != tc.bri || attr.State.ColorMode != tc.colorMode || attr.State.Ct != tc.ct {
+			t.Errorf("Light %d has bri: %d, colormode: %q, ct: %d; want bri: %d, colormode: %q, ct: %d", tc.id, attr.State.Bri, attr.State.ColorMode, attr.State.Ct, tc.bri, tc.colorMode, tc.ct)
+		}
+
+		resource := resources[tc.id]
+		minimum, maximum := resource.colorTemperatureRange()
+		if minimum != tc.minimum || maximum != tc.maximum {
+			t.Errorf("Light %d supports %dK - %dK; want %dK - %dK", tc.id, minimum, maximum, tc.minimum, tc.maximum)
+		}
+		gamut := resource.gamut()
+		if tc.gamutGreen == nil {
+			if gamut != nil {
+				t.Errorf("Light %d has gamut %v; want none", tc.id, gamut)
+			}
+		} else if len(gamut) != 3 || !reflect.DeepEqual(gamut[1], tc.gamutGreen) {
+			t.Errorf("Light %d has gamut %v; want green corner %v", tc.id, gamut, tc.gamutGreen)
+		}
+	}
+}
+
+func TestHueLightInitializeV2(t *testing.T) {
+	fake, client := newFakeClipV2(t)
+	defer fake.server.Close()
+
+	resources, attributes, err := client.lightAttributes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		id      int
+		minimum int
+		maximum int
+	}{
+		{1, 2000, 6535}, // Extended color light limited to its mirek schema
+		{4, 2202, 6535}, // Color temperature light
+		{7, 1000, 6500}, // Color light keeps the defaults
+	}
+
+	for _, tc := range tests {
+		light := &HueLight{}
+		light.initializeV2(resources[tc.id], attributes[tc.id], client)
+		minimum, maximum := light.colorTemperatureRange()
+		if minimum != tc.minimum || maximum != tc.maximum {
+			t.Errorf("Light %d supports %dK - %dK; want %dK - %dK", tc.id, minimum, maximum, tc.minimum, tc.maximum)
+		}
+	}
+}
+
+func TestClipV2SetLightState(t *testing.T) {
+	fake, client := newFakeClipV2(t)
+	defer fake.server.Close()
+
+	var tests = []struct {
+		state hue.SetLightState
+		body  string
+	}{
+		{hue.SetLightState{On: "true", Bri: "254", Ct: "366", TransitionTime: "4"},
+			`{"on": {"on": true}, "dimming": {"brightness": 100}, "color_temperature": {"mirek": 366}, "dynamics": {"duration": 400}}`},
+		{hue.SetLightState{Bri: "127", Xy: []float32{0.4573, 0.41}, Ct: "366"},
+			`{"dimming": {"brightness": 50}, "color": {"xy": {"x": 0.4573, "y": 0.41}}}`},
+		{hue.SetLightState{On: "Off", TransitionTime: "0"},
+			`{"on": {"on": false}, "dynamics": {"duration": 0}}`},
+		{hue.SetLightState{Bri: "1"},
+			`{"dimming": {"brightness": 0.39}}`},
+	}
+
+	for _, tc := range tests {
+		err := client.setLightState("3f8e4b6a", tc.state)
+		if err != nil {
+			t.Errorf("Setting state %+v failed: %v", tc.state, err)
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(fake.bodies["light/3f8e4b6a"], &got); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tc.body), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Setting state %+v sent %s; want %s", tc.state, fake.bodies["light/3f8e4b6a"], tc.body)
+		}
+	}
+
+	if err := client.setLightState("3f8e4b6a", hue.SetLightState{Bri: "bright"}); err == nil {
+		t.Errorf("Setting an invalid brightness should fail")
+	}
+
+	client.key = "wrong"
+	if err := client.validate(); err == nil || !strings.Contains(err.Error(), "unauthorized user") {
+		t.Errorf("Validating a wrong key returned %v; want unauthorized user", err)
+	}
+}
@@ -50,6 +50,99 @@ func colorTemperatureToXYColor(t int) []float32 {
 	return []float32{roundFloat(float32(x), 3), roundFloat(float32(y), 3)}
 }
 
+// colorTemperatureToRGB converts the given color temperature to a RGB color
+// via its xy color on the Planckian locus. The brightest channel is scaled to
+// 255 as the brightness of a light is set independently.
+func colorTemperatureToRGB(t int) []int {
+	// -1 indicates values to ignore. Map these to {-1,-1,-1}
+	if t == -1 {
+		return []int{-1, -1, -1}
+	}
+
+	// http://www.brucelindbloom.com/index.html?Eqn_xyY_to_XYZ.html
+	xy := colorTemperatureToXYColor(t)
+	x, y := float64(xy[0]), float64(xy[1])
+	X := x / y
+	Z := (1 - x - y) / y
+
+	// http://www.brucelindbloom.com/index.html?Eqn_RGB_XYZ_Matrix.html (sRGB, D65)
+	linear := []float64{
+		3.2404542*X - 1.5371385 - 0.4985314*Z,
+		-0.9692660*X + 1.8760108 + 0.0415560*Z,
+		0.0556434*X - 0.2040259 + 1.0572252*Z,
+	}
+	maximum := 0.0
+	for index := range linear {
+		linear[index] = math.Max(0, linear[index])
+		maximum = math.Max(maximum, linear[index])
+	}
+
+	rgb := make([]int, 3)
+	for index, value := range linear {
+		value /= maximum
+		// Apply sRGB companding
+		if value <= 0.0031308 {
+			value *= 12.92
+		} else {
+			value = 1.055*math.Pow(value, 1/2.4) - 0.055
+		}
+		rgb[index] = int(math.Round(value * 255))
+	}
+	return rgb
+}
+
+// clampToGamut maps the given xy color to the closest color inside the
+// triangle spanned by the red, green and blue corners of a gamut.
+// Lights will do the same when receiving a color they can't display.
+func clampToGamut(xy []float32, gamut [][]float32) []float32 {
+	if len(xy) != 2 || len(gamut) != 3 || equalsFloat(xy, []float32{-1, -1}, 0) {
+		return xy
+	}
+
+	p := [2]float64{float64(xy[0]), float64(xy[1])}
+	r := [2]float64{float64(gamut[0][0]), float64(gamut[0][1])}
+	g := [2]float64{float64(gamut[1][0]), float64(gamut[1][1])}
+	b := [2]float64{float64(gamut[2][0]), float64(gamut[2][1])}
+	if insideTriangle(p, r, g, b) {
+		return xy
+	}
+
+	closest := closestPointOnLine(p, r, g)
+	distance := pointDistance(p, closest)
+	for _, candidate := range [][2]float64{closestPointOnLine(p, g, b), closestPointOnLine(p, b, r)} {
+		if d := pointDistance(p, candidate); d < distance {
+			closest = candidate
+			distance = d
+		}
+	}
+	return []float32{roundFloat(float32(closest[0]), 3), roundFloat(float32(closest[1]), 3)}
+}
+
+func insideTriangle(p, a, b, c [2]float64) bool {
+	sign := func(p1, p2, p3 [2]float64) float64 {
+		return (p1[0]-p3[0])*(p2[1]-p3[1]) - (p2[0]-p3[0])*(p1[1]-p3[1])
+	}
+	d1, d2, d3 := sign(p, a, b), sign(p, b, c), sign(p, c, a)
+	hasNegative := d1 < 0 || d2 < 0 || d3 < 0
+	hasPositive := d1 > 0 || d2 > 0 || d3 > 0
+	return !(hasNegative && hasPositive)
+}
+
+func closestPointOnLine(p, a, b [2]float64) [2]float64 {
+	ab := [2]float64{b[0] - a[0], b[1] - a[1]}
+	length := ab[0]*ab[0] + ab[1]*ab[1]
+	if length == 0 {
+		return a
+	}
+	t := ((p[0]-a[0])*ab[0] + (p[1]-a[1])*ab[1]) / length
+	t = math.Max(0, math.Min(1, t))
+	return [2]float64{a[0] + t*ab[0], a[1] + t*ab[1]}
+}
+
+func pointDistance(a, b [2]float64) float64 {
+	return math.Hypot(a[0]-b[0], a[1]-b[1])
+}
+
 var lookupTable = map[int][]float64{
 	1000: {0.652756059, 0.344456906},
 	1001: {0.652614831, 0.344582115},
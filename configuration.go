@@ -28,22 +28,43 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghodss/yaml"
 	log "github.com/sirupsen/logrus"
 )
 
-// Bridge respresents the hue bridge in your system.
+// Bridge respresents a hue bridge or another controller in your system.
+// Its type selects the backend used to control its lights.
 type Bridge struct {
+	ID       string `json:"id"`
+	Type     string `json:"type,omitempty"`
 	IP       string `json:"ip"`
 	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	Topic    string `json:"topic,omitempty"`
+	API      string `json:"api,omitempty"`
+	// Devices maps the addresses of devices to their IDs, so they
+	// stay the same when devices are removed or paired again.
+	Devices map[string]int `json:"devices,omitempty"`
 }
 
 // Location represents the geolocation for which sunrise and sunset will be calculated.
+// Twilight selects the definition of sunrise and sunset: official, civil,
+// nautical, astronomical, golden hour (default) or custom with the elevation
+// of the sun given by TwilightAngle. FallbackSunrise and FallbackSunset are
+// used on days the sun doesn't rise or set.
 type Location struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Latitude        float64 `json:"latitude"`
+	Longitude       float64 `json:"longitude"`
+	Twilight        string  `json:"twilight,omitempty"`
+	TwilightAngle   float64 `json:"twilightAngle,omitempty"`
+	FallbackSunrise string  `json:"fallbackSunrise,omitempty"`
+	FallbackSunset  string  `json:"fallbackSunset,omitempty"`
 }
 
 // WebInterface respresents the webinterface of Kelvin.
@@ -54,21 +75,72 @@ type WebInterface struct {
 
 // LightSchedule represents the schedule for any given day for the associated lights.
 type LightSchedule struct {
-	Name                    string                  `json:"name"`
-	AssociatedDeviceIDs     []int                   `json:"associatedDeviceIDs"`
-	EnableWhenLightsAppear  bool                    `json:"enableWhenLightsAppear"`
-	DefaultColorTemperature int                     `json:"defaultColorTemperature"`
-	DefaultBrightness       int                     `json:"defaultBrightness"`
-	BeforeSunrise           []TimedColorTemperature `json:"beforeSunrise"`
-	AfterSunset             []TimedColorTemperature `json:"afterSunset"`
+	Name                    string                      `json:"name"`
+	Bridge                  string                      `json:"bridge,omitempty"`
+	AssociatedDeviceIDs     []int                       `json:"associatedDeviceIDs"`
+	Rooms                   []string                    `json:"rooms,omitempty"`
+	Zones                   []string                    `json:"zones,omitempty"`
+	Lights                  []string                    `json:"lights,omitempty"`
+	EnableWhenLightsAppear  bool                        `json:"enableWhenLightsAppear"`
+	DefaultColorTemperature int                         `json:"defaultColorTemperature"`
+	DefaultBrightness       int                         `json:"defaultBrightness"`
+	BeforeSunrise           []TimedColorTemperature     `json:"beforeSunrise"`
+	AfterSunset             []TimedColorTemperature     `json:"afterSunset"`
+	Variants                []ScheduleVariant           `json:"variants,omitempty"`
+	ColorTemperatureEasing  string                      `json:"colorTemperatureEasing,omitempty"`
+	BrightnessEasing        string                      `json:"brightnessEasing,omitempty"`
+	ElevationCurve          []ElevationColorTemperature `json:"elevationCurve,omitempty"`
+	EarliestSunrise         string                      `json:"earliestSunrise,omitempty"`
+	LatestSunrise           string                      `json:"latestSunrise,omitempty"`
+	EarliestSunset          string                      `json:"earliestSunset,omitempty"`
+	LatestSunset            string                      `json:"latestSunset,omitempty"`
+	WakeUp                  *WakeUp                     `json:"wakeUp,omitempty"`
+	ResumePolicy            string                      `json:"resumePolicy,omitempty"`
+	ResumeTimeout           int                         `json:"resumeTimeout,omitempty"`
+	BrightnessOffset        string                      `json:"brightnessOffset,omitempty"`
+}
+
+// WakeUp turns on all lights of a schedule at the time configured for the
+// weekday and brightens them slowly. Times are indexed by weekdays like
+// "mon". Holidays are formatted like the dates of variants.
+type WakeUp struct {
+	Times            map[string]string `json:"times"`
+	Duration         int               `json:"duration"`
+	ColorTemperature int               `json:"colorTemperature,omitempty"`
+	Holidays         []string          `json:"holidays,omitempty"`
+}
+
+// ScheduleVariant replaces the light states of a schedule on the given
+// weekdays or dates. Dates are formatted as "2006-01-02" or "01-02" for
+// recurring dates. Values which are not set are taken from the schedule.
+type ScheduleVariant struct {
+	Name                    string                  `json:"name,omitempty"`
+	Days                    []string                `json:"days,omitempty"`
+	From                    string                  `json:"from,omitempty"`
+	To                      string                  `json:"to,omitempty"`
+	DefaultColorTemperature *int                    `json:"defaultColorTemperature,omitempty"`
+	DefaultBrightness       *int                    `json:"defaultBrightness,omitempty"`
+	BeforeSunrise           []TimedColorTemperature `json:"beforeSunrise,omitempty"`
+	AfterSunset             []TimedColorTemperature `json:"afterSunset,omitempty"`
 }
 
 // TimedColorTemperature represents a light configuration which will be
 // reached at the given time.
 type TimedColorTemperature struct {
-	Time             string `json:"time"`
-	ColorTemperature int    `json:"colorTemperature"`
-	Brightness       int    `json:"brightness"`
+	Time                   string `json:"time"`
+	ColorTemperature       int    `json:"colorTemperature"`
+	Brightness             int    `json:"brightness"`
+	ColorTemperatureEasing string `json:"colorTemperatureEasing,omitempty"`
+	BrightnessEasing       string `json:"brightnessEasing,omitempty"`
+	FadeOut                int    `json:"fadeOut,omitempty"`
+}
+
+// ElevationColorTemperature represents a light configuration which will be
+// reached at the given elevation of the sun in degrees.
+type ElevationColorTemperature struct {
+	Elevation        float64 `json:"elevation"`
+	ColorTemperature int     `json:"colorTemperature"`
+	Brightness       int     `json:"brightness"`
 }
 
 // Configuration encapsulates all relevant parameters for Kelvin to operate.
@@ -76,20 +148,46 @@ type Configuration struct {
 	ConfigurationFile string          `json:"-"`
 	Hash              string          `json:"-"`
 	Version           int             `json:"version"`
-	Bridge            Bridge          `json:"bridge"`
+	Bridge            *Bridge         `json:"bridge,omitempty"`
+	Bridges           []Bridge        `json:"bridges"`
 	Location          Location        `json:"location"`
 	WebInterface      WebInterface    `json:"webinterface"`
 	Schedules         []LightSchedule `json:"schedules"`
+	associations      [][]DeviceID
+	associationsDate  time.Time
+	directories       map[string]DeviceDirectory
+	directoryDates    map[string]time.Time
+}
+
+// DeviceID identifies a light across all configured bridges.
+type DeviceID struct {
+	Bridge string
+	Light  int
+}
+
+// DeviceDirectory maps the names of all lights, rooms and zones
+// on the bridge to the corresponding light IDs.
+type DeviceDirectory struct {
+	Lights map[int]string
+	Rooms  map[string][]int
+	Zones  map[string][]int
 }
 
 // TimeStamp represents a parsed and validated TimedColorTemperature.
+// Easing defines the transition from the previous timestamp to this one.
 type TimeStamp struct {
 	Time             time.Time
 	ColorTemperature int
 	Brightness       int
+	Easing           Easing
 }
 
-var latestConfigurationVersion = 0
+var latestConfigurationVersion = 2
+
+// associationsMutex guards the resolved associations of all schedules and
+// the directories of all bridges as they are accessed by the update loops
+// of all bridges.
+var associationsMutex sync.Mutex
 
 func (configuration *Configuration) initializeDefaults() {
 	configuration.Version = latestConfigurationVersion
@@ -114,10 +212,14 @@ func (configuration *Configuration) initializeDefaults() {
 	defaultSchedule.AssociatedDeviceIDs = []int{}
 	defaultSchedule.DefaultColorTemperature = 2750
 	defaultSchedule.DefaultBrightness = 100
+	defaultSchedule.EnableWhenLightsAppear = true
 	defaultSchedule.AfterSunset = []TimedColorTemperature{tvTime, bedTime}
 	defaultSchedule.BeforeSunrise = []TimedColorTemperature{wakeupTime}
 
 	configuration.Schedules = []LightSchedule{defaultSchedule}
+	if len(configuration.Bridges) == 0 {
+		configuration.Bridges = []Bridge{{}}
+	}
 
 	var webinterface WebInterface
 	webinterface.Enabled = false
@@ -217,6 +319,11 @@ func (configuration *Configuration) Read() error {
 		return err
 	}
 
+	configuration.Hash = configuration.HashValue()
+	log.Debugf("⚙ Updated configuration hash.")
+
+	// Migrate first as the defaults are already in the latest format
+	configuration.migrateToLatestVersion()
 	if len(configuration.Schedules) == 0 {
 		log.Warningf("⚙ Your current configuration doesn't contain any schedules! Generating default schedule...")
 		err := configuration.backup()
@@ -226,27 +333,17 @@ func (configuration *Configuration) Read() error {
 			log.Printf("⚙ Configuration backup created.")
 			configuration.initializeDefaults()
 			log.Printf("⚙ Default schedule created.")
-			configuration.Write()
 		}
 	}
-	configuration.Hash = configuration.HashValue()
-	log.Debugf("⚙ Updated configuration hash.")
-
-	configuration.migrateToLatestVersion()
 	configuration.Write()
 	return nil
 }
 
-func (configuration *Configuration) lightScheduleForDay(light int, date time.Time) (Schedule, error) {
-	// initialize schedule with end of day
-	var schedule Schedule
-	yr, mth, dy := date.Date()
-	schedule.endOfDay = time.Date(yr, mth, dy, 23, 59, 59, 59, date.Location())
-
+func (configuration *Configuration) lightScheduleForDay(bridgeID string, light int, date time.Time) (Schedule, error) {
 	var lightSchedule LightSchedule
 	found := false
-	for _, candidate := range configuration.Schedules {
-		if containsInt(candidate.AssociatedDeviceIDs, light) {
+	for index, candidate := range configuration.Schedules {
+		if containsDevice(configuration.associatedDevices(index, date), DeviceID{bridgeID, light}) {
 			lightSchedule = candidate
 			found = true
 			break
@@ -254,36 +351,662 @@ func (configuration *Configuration) lightScheduleForDay(light int, date time.Tim
 	}
 
 	if !found {
-		return schedule, fmt.Errorf("Light %d is not associated with any schedule in configuration", light)
+		return Schedule{}, fmt.Errorf("Light %d on bridge %s is not associated with any schedule in configuration", light, bridgeID)
 	}
 
-	schedule.sunrise = TimeStamp{CalculateSunrise(date, configuration.Location.Latitude, configuration.Location.Longitude), lightSchedule.DefaultColorTemperature, lightSchedule.DefaultBrightness}
-	schedule.sunset = TimeStamp{CalculateSunset(date, configuration.Location.Latitude, configuration.Location.Longitude), lightSchedule.DefaultColorTemperature, lightSchedule.DefaultBrightness}
+	// The timeline reaches from the sunset of the previous day to the sunrise
+	// of the next day, so the light state doesn't jump at midnight.
+	yesterday := configuration.daySchedule(lightSchedule, date.AddDate(0, 0, -1))
+	schedule := configuration.daySchedule(lightSchedule, date)
+	tomorrow := configuration.daySchedule(lightSchedule, date.AddDate(0, 0, 1))
+
+	// Entries outside of their night would never be reached
+	// as the daylight interval takes precedence.
+	lastNight := schedule.timestampsBetween(yesterday.afterSunset, yesterday.sunset.Time, schedule.sunrise.Time)
+	schedule.beforeSunrise = schedule.timestampsBetween(schedule.beforeSunrise, yesterday.sunset.Time, schedule.sunrise.Time)
+	schedule.afterSunset = schedule.timestampsBetween(schedule.afterSunset, schedule.sunset.Time, tomorrow.sunrise.Time)
+	nextMorning := schedule.timestampsBetween(tomorrow.beforeSunrise, schedule.sunset.Time, tomorrow.sunrise.Time)
 
-	// Before sunrise candidates
-	schedule.beforeSunrise = []TimeStamp{}
-	for _, candidate := range lightSchedule.BeforeSunrise {
-		timestamp, err := candidate.AsTimestamp(date)
+	schedule.timeline = []TimeStamp{yesterday.sunset}
+	schedule.timeline = append(schedule.timeline, lastNight...)
+	schedule.timeline = append(schedule.timeline, schedule.beforeSunrise...)
+	schedule.timeline = append(schedule.timeline, schedule.sunrise, schedule.sunset)
+	schedule.timeline = append(schedule.timeline, schedule.afterSunset...)
+	schedule.timeline = append(schedule.timeline, nextMorning...)
+	schedule.timeline = append(schedule.timeline, tomorrow.sunrise)
+	sort.SliceStable(schedule.timeline, func(i, j int) bool { return schedule.timeline[i].Time.Before(schedule.timeline[j].Time) })
+
+	// Ignored entries don't fade out either
+	var fadeOuts []Interval
+	for _, fadeOut := range append(append(yesterday.fadeOuts, schedule.fadeOuts...), tomorrow.fadeOuts...) {
+		for _, timestamp := range schedule.timeline {
+			if timestamp.Time.Equal(fadeOut.Start.Time) {
+				fadeOuts = append(fadeOuts, fadeOut)
+				break
+			}
+		}
+	}
+	schedule.fadeOuts = fadeOuts
+
+	if lightSchedule.WakeUp != nil {
+		ramp, err := lightSchedule.WakeUp.rampForDay(date, lightSchedule.forDay(date).DefaultColorTemperature)
 		if err != nil {
-			log.Warningf("⚙ Found invalid configuration entry before sunrise: %+v (Error: %v)", candidate, err)
+			log.Warningf("⚙ Schedule %s - Ignoring invalid wake-up: %v", lightSchedule.Name, err)
+		}
+		schedule.wakeUp = ramp
+	}
+
+	policy, err := lightSchedule.resumePolicy()
+	if err != nil {
+		log.Warningf("⚙ Schedule %s - %v. Lights changed manually won't resume automatic mode...", lightSchedule.Name, err)
+	}
+	schedule.resumePolicy = policy
+	schedule.resumeTimeout = time.Duration(lightSchedule.ResumeTimeout) * time.Minute
+	if schedule.resumeTimeout <= 0 {
+		schedule.resumeTimeout = defaultResumeTimeout
+	}
+
+	offsetMode, err := lightSchedule.brightnessOffsetMode()
+	if err != nil {
+		log.Warningf("⚙ Schedule %s - %v. Manual brightness changes will be kept as they are...", lightSchedule.Name, err)
+	}
+	schedule.brightnessOffset = offsetMode
+
+	yr, mth, dy := date.Date()
+	schedule.endOfDay = time.Date(yr, mth, dy, 23, 59, 59, 59, date.Location())
+	schedule.enableWhenLightsAppear = lightSchedule.EnableWhenLightsAppear
+	return schedule, nil
+}
+
+// daySchedule calculates sunrise, sunset and all entries of the given
+// schedule for a single day.
+func (configuration *Configuration) daySchedule(lightSchedule LightSchedule, date time.Time) Schedule {
+	var schedule Schedule
+	lightSchedule = lightSchedule.forDay(date)
+	schedule.name = lightSchedule.Name
+
+	sun, err := lightSchedule.sunTimesForDay(date, configuration.Location)
+	if err != nil {
+		log.Warningf("⚙ Schedule %s - Ignoring bounds of sunrise and sunset: %v", lightSchedule.Name, err)
+	}
+	if sun.Fallback {
+		log.Debugf("⚙ Schedule %s - The sun doesn't rise or set on %v. Using daylight from %v to %v", lightSchedule.Name, date.Format("Jan 2 2006"), sun.Sunrise.Format("15:04"), sun.Sunset.Format("15:04"))
+	}
+	easing := lightSchedule.easing()
+	schedule.sunrise = TimeStamp{sun.Sunrise, lightSchedule.DefaultColorTemperature, lightSchedule.DefaultBrightness, easing}
+	schedule.sunset = TimeStamp{sun.Sunset, lightSchedule.DefaultColorTemperature, lightSchedule.DefaultBrightness, easing}
+
+	// Follow the elevation of the sun during the day if configured
+	if len(lightSchedule.ElevationCurve) > 0 {
+		schedule.elevationCurve = newElevationCurve(lightSchedule.ElevationCurve, configuration.Location)
+		sunrise := schedule.elevationCurve.lightState(sun.Sunrise)
+		sunset := schedule.elevationCurve.lightState(sun.Sunset)
+		schedule.sunrise = TimeStamp{sun.Sunrise, sunrise.ColorTemperature, sunrise.Brightness, easing}
+		schedule.sunset = TimeStamp{sun.Sunset, sunset.ColorTemperature, sunset.Brightness, easing}
+	}
+
+	schedule.beforeSunrise = lightSchedule.timestampsForDay(lightSchedule.BeforeSunrise, date, sun, false)
+	schedule.afterSunset = lightSchedule.timestampsForDay(lightSchedule.AfterSunset, date, sun, true)
+	schedule.fadeOuts = lightSchedule.fadeOutsForDay(date, sun)
+	return schedule
+}
+
+// fadeOutsForDay returns an interval for every entry which fades the lights
+// to off on the given day. Invalid entries are skipped as they have already
+// been reported by timestampsForDay.
+func (schedule *LightSchedule) fadeOutsForDay(date time.Time, sun SunTimes) []Interval {
+	var fadeOuts []Interval
+	for _, afterSunset := range []bool{false, true} {
+		entries := schedule.BeforeSunrise
+		if afterSunset {
+			entries = schedule.AfterSunset
+		}
+		for _, entry := range entries {
+			if entry.FadeOut <= 0 {
+				continue
+			}
+			start, err := entry.timestampForDay(date, sun, afterSunset)
+			if err != nil {
+				continue
+			}
+			end := TimeStamp{start.Time.Add(time.Duration(entry.FadeOut) * time.Minute), start.ColorTemperature, 0, Easing{easingLinear, easingPerceptual}}
+			fadeOuts = append(fadeOuts, Interval{start, end, nil})
+		}
+	}
+	return fadeOuts
+}
+
+// timestampsBetween returns all timestamps lying strictly between start and end.
+func (schedule *Schedule) timestampsBetween(timestamps []TimeStamp, start time.Time, end time.Time) []TimeStamp {
+	result := []TimeStamp{}
+	for _, timestamp := range timestamps {
+		if !timestamp.Time.After(start) || !timestamp.Time.Before(end) {
+			log.Debugf("⚙ Schedule %s - Ignoring entry at %v as it doesn't lie between %v and %v", schedule.name, timestamp.Time.Format("Jan 2 15:04"), start.Format("Jan 2 15:04"), end.Format("Jan 2 15:04"))
 			continue
 		}
-		schedule.beforeSunrise = append(schedule.beforeSunrise, timestamp)
+		result = append(result, timestamp)
+	}
+	return result
+}
+
+// easing returns the default easing of this schedule. Unknown easings are
+// reported and replaced by linear interpolation.
+func (schedule *LightSchedule) easing() Easing {
+	easing := Easing{schedule.ColorTemperatureEasing, schedule.BrightnessEasing}
+	if !isValidEasing(easing.ColorTemperature) {
+		log.Warningf("⚙ Schedule %s - Unknown color temperature easing %s. Using linear easing...", schedule.Name, easing.ColorTemperature)
+		easing.ColorTemperature = easingLinear
+	}
+	if !isValidBrightnessEasing(easing.Brightness) {
+		log.Warningf("⚙ Schedule %s - Unsupported brightness easing %s. Using linear easing...", schedule.Name, easing.Brightness)
+		easing.Brightness = easingLinear
+	}
+	return easing
+}
+
+// sunTimesForDay calculates all events of the sun for the given day and
+// limits sunrise and sunset to the bounds configured for this schedule.
+// If the bounds are invalid the unlimited times are returned with an error.
+func (schedule *LightSchedule) sunTimesForDay(date time.Time, location Location) (SunTimes, error) {
+	sun := CalculateSunTimes(date, location)
+	sunrise, err := clampTime(sun.Sunrise, schedule.EarliestSunrise, schedule.LatestSunrise)
+	if err != nil {
+		return sun, err
+	}
+	sunset, err := clampTime(sun.Sunset, schedule.EarliestSunset, schedule.LatestSunset)
+	if err != nil {
+		return sun, err
+	}
+	if !sunrise.Before(sunset) {
+		return sun, fmt.Errorf("Sunrise (%v) would not be before sunset (%v)", sunrise.Format("15:04"), sunset.Format("15:04"))
+	}
+	sun.Sunrise = sunrise
+	sun.Sunset = sunset
+	return sun, nil
+}
+
+// clampTime limits the given time to the bounds earliest and latest on the
+// same day. Empty bounds are ignored.
+func clampTime(t time.Time, earliest string, latest string) (time.Time, error) {
+	lower, upper := t, t
+	var err error
+	if earliest != "" {
+		lower, err = timeOnDay(t, earliest)
+		if err != nil {
+			return t, err
+		}
+	}
+	if latest != "" {
+		upper, err = timeOnDay(t, latest)
+		if err != nil {
+			return t, err
+		}
+	}
+	if earliest != "" && latest != "" && upper.Before(lower) {
+		return t, fmt.Errorf("Earliest time %s lies after latest time %s", earliest, latest)
+	}
+	if t.Before(lower) {
+		return lower, nil
+	}
+	if t.After(upper) {
+		return upper, nil
 	}
+	return t, nil
+}
 
-	// After sunset candidates
-	schedule.afterSunset = []TimeStamp{}
-	for _, candidate := range lightSchedule.AfterSunset {
-		timestamp, err := candidate.AsTimestamp(date)
+// timestampsForDay converts the given entries into timestamps for the given
+// day and returns them sorted by time. Relative entries like "sunset+30m"
+// move over the year and may pass other entries. Such crossings are
+// reported as the entries will be applied in a different order than
+// configured. Entries after sunset before noon belong to the following night.
+func (schedule *LightSchedule) timestampsForDay(entries []TimedColorTemperature, date time.Time, sun SunTimes, afterSunset bool) []TimeStamp {
+	section := "before sunrise"
+	if afterSunset {
+		section = "after sunset"
+	}
+	timestamps := []TimeStamp{}
+	valid := []TimedColorTemperature{}
+	for _, candidate := range entries {
+		timestamp, err := candidate.timestampForDay(date, sun, afterSunset)
 		if err != nil {
-			log.Warningf("⚙ Found invalid configuration entry after sunset: %+v (Error: %v)", candidate, err)
+			log.Warningf("⚙ Found invalid configuration entry %s: %+v (Error: %v)", section, candidate, err)
 			continue
 		}
-		schedule.afterSunset = append(schedule.afterSunset, timestamp)
+		if timestamp.Easing.ColorTemperature == "" {
+			timestamp.Easing.ColorTemperature = schedule.ColorTemperatureEasing
+		}
+		if timestamp.Easing.Brightness == "" {
+			timestamp.Easing.Brightness = schedule.BrightnessEasing
+		}
+		timestamps = append(timestamps, timestamp)
+		valid = append(valid, candidate)
 	}
 
-	schedule.enableWhenLightsAppear = lightSchedule.EnableWhenLightsAppear
-	return schedule, nil
+	for _, crossing := range crossingEntries(valid, timestamps) {
+		log.Warningf("⚙ Schedule %s - Entries %s and %s %s cross each other on %v", schedule.Name, crossing[0], crossing[1], section, date.Format("Jan 2 2006"))
+	}
+	sort.SliceStable(timestamps, func(i, j int) bool { return timestamps[i].Time.Before(timestamps[j].Time) })
+	return timestamps
+}
+
+// crossingEntries returns the times of all pairs of entries which occur in
+// reverse order of their configuration.
+func crossingEntries(entries []TimedColorTemperature, timestamps []TimeStamp) [][2]string {
+	var crossings [][2]string
+	for i := range timestamps {
+		for j := i + 1; j < len(timestamps); j++ {
+			if timestamps[j].Time.Before(timestamps[i].Time) {
+				crossings = append(crossings, [2]string{entries[i].Time, entries[j].Time})
+			}
+		}
+	}
+	return crossings
+}
+
+// validateSchedules reports all entries of all schedules and their variants
+// which cross each other or lie on the wrong side of sunrise or sunset within
+// a year from the given date. Every problem is only reported for the first day
+// it occurs. Invalid wake-up alarms are reported as well.
+func (configuration *Configuration) validateSchedules(date time.Time) {
+	type entryList struct {
+		name    string
+		before  bool
+		entries []TimedColorTemperature
+	}
+
+	for _, schedule := range configuration.Schedules {
+		lists := []entryList{{schedule.Name, true, schedule.BeforeSunrise}, {schedule.Name, false, schedule.AfterSunset}}
+		for _, variant := range schedule.Variants {
+			name := schedule.Name + " (" + variant.Name + ")"
+			lists = append(lists, entryList{name, true, variant.BeforeSunrise}, entryList{name, false, variant.AfterSunset})
+		}
+
+		if _, err := schedule.sunTimesForDay(date, configuration.Location); err != nil {
+			log.Warningf("⚙ Schedule %s - Ignoring bounds of sunrise and sunset: %v", schedule.Name, err)
+		}
+		if schedule.WakeUp != nil {
+			if err := schedule.WakeUp.validate(); err != nil {
+				log.Warningf("⚙ Schedule %s - Ignoring invalid wake-up: %v", schedule.Name, err)
+			}
+		}
+
+		reported := make([]map[[2]string]bool, len(lists))
+		for index := range reported {
+			reported[index] = make(map[[2]string]bool)
+		}
+		previous, _ := schedule.sunTimesForDay(date.AddDate(0, 0, -1), configuration.Location)
+		sun, _ := schedule.sunTimesForDay(date, configuration.Location)
+		for day := 0; day < 366; day++ {
+			current := date.AddDate(0, 0, day)
+			next, _ := schedule.sunTimesForDay(current.AddDate(0, 0, 1), configuration.Location)
+			for index, list := range lists {
+				var valid []TimedColorTemperature
+				var timestamps []TimeStamp
+				for _, entry := range list.entries {
+					timestamp, err := entry.timestampForDay(current, sun, !list.before)
+					if err != nil {
+						continue
+					}
+					valid = append(valid, entry)
+					timestamps = append(timestamps, timestamp)
+
+					key := [2]string{entry.Time}
+					if list.before && !timestamp.Time.Before(sun.Sunrise) && !reported[index][key] {
+						reported[index][key] = true
+						log.Warningf("⚙ Schedule %s - Entry %s lies after sunrise on %v and will be ignored on such days.", list.name, entry.Time, current.Format("Jan 2 2006"))
+					}
+					if !list.before && !timestamp.Time.After(sun.Sunset) && !reported[index][key] {
+						reported[index][key] = true
+						log.Warningf("⚙ Schedule %s - Entry %s lies before sunset on %v and will be ignored on such days.", list.name, entry.Time, current.Format("Jan 2 2006"))
+					}
+					if list.before && !timestamp.Time.After(previous.Sunset) && !reported[index][key] {
+						reported[index][key] = true
+						log.Warningf("⚙ Schedule %s - Entry %s lies before the previous sunset on %v and will be ignored on such days.", list.name, entry.Time, current.Format("Jan 2 2006"))
+					}
+					if !list.before && !timestamp.Time.Before(next.Sunrise) && !reported[index][key] {
+						reported[index][key] = true
+						log.Warningf("⚙ Schedule %s - Entry %s lies after the next sunrise on %v and will be ignored on such days.", list.name, entry.Time, current.Format("Jan 2 2006"))
+					}
+				}
+				for _, crossing := range crossingEntries(valid, timestamps) {
+					if reported[index][crossing] {
+						continue
+					}
+					reported[index][crossing] = true
+					log.Warningf("⚙ Schedule %s - Entries %s and %s will cross each other on %v. They will be applied in chronological order.", list.name, crossing[0], crossing[1], current.Format("Jan 2 2006"))
+				}
+			}
+			previous, sun = sun, next
+		}
+	}
+}
+
+// associatedDevices returns all lights associated with the schedule at the
+// given index. Names of rooms, zones and lights are resolved against all
+// bridges once per day.
+func (configuration *Configuration) associatedDevices(index int, date time.Time) []DeviceID {
+	schedule := configuration.Schedules[index]
+	if !schedule.hasNamedDevices() {
+		return configuration.devicesOnBridge(configuration.scheduleBridgeID(schedule), schedule.AssociatedDeviceIDs)
+	}
+
+	if !configuration.hasAssociations(date) {
+		configuration.updateAssociations(date)
+	}
+
+	associationsMutex.Lock()
+	defer associationsMutex.Unlock()
+	if index >= len(configuration.associations) {
+		return configuration.devicesOnBridge(configuration.scheduleBridgeID(schedule), schedule.AssociatedDeviceIDs)
+	}
+	return configuration.associations[index]
+}
+
+// hasAssociations reports whether the associations of all schedules have
+// been resolved on the given day.
+func (configuration *Configuration) hasAssociations(date time.Time) bool {
+	associationsMutex.Lock()
+	defer associationsMutex.Unlock()
+	return len(configuration.associations) == len(configuration.Schedules) && sameDay(configuration.associationsDate, date)
+}
+
+// updateAssociations resolves the names of rooms, zones and lights of all
+// schedules. Every bridge is queried at most once per day and without
+// holding associationsMutex. If a bridge can't be queried, the last
+// directory read from it is used.
+func (configuration *Configuration) updateAssociations(date time.Time) {
+	backends := allBridges()
+	var outdated []LightBackend
+	associationsMutex.Lock()
+	for _, bridge := range backends {
+		if !sameDay(configuration.directoryDates[bridge.bridgeID()], date) {
+			outdated = append(outdated, bridge)
+		}
+	}
+	associationsMutex.Unlock()
+
+	directories := make(map[string]DeviceDirectory)
+	for _, bridge := range outdated {
+		directory, err := bridge.deviceDirectory()
+		if err != nil {
+			log.Warningf("⚙ Could not resolve rooms, zones and lights of schedules on bridge %s: %v - Keeping the last known ones", bridge.bridgeID(), err)
+			continue
+		}
+		directories[bridge.bridgeID()] = directory
+	}
+
+	associationsMutex.Lock()
+	defer associationsMutex.Unlock()
+	if configuration.directories == nil {
+		configuration.directories = make(map[string]DeviceDirectory)
+	}
+	if configuration.directoryDates == nil {
+		configuration.directoryDates = make(map[string]time.Time)
+	}
+	for _, bridge := range outdated {
+		configuration.directoryDates[bridge.bridgeID()] = date
+	}
+	for bridgeID, directory := range directories {
+		configuration.directories[bridgeID] = directory
+	}
+	configuration.resolveAssociations(configuration.directories, date)
+}
+
+// associatedLightIDs returns the IDs of all lights on the given bridge
+// associated with the schedule at the given index.
+func (configuration *Configuration) associatedLightIDs(index int, bridgeID string, date time.Time) []int {
+	var ids []int
+	for _, device := range configuration.associatedDevices(index, date) {
+		if device.Bridge == bridgeID {
+			ids = append(ids, device.Light)
+		}
+	}
+	return ids
+}
+
+func (configuration *Configuration) resolveAssociations(directories map[string]DeviceDirectory, date time.Time) {
+	configuration.associations = [][]DeviceID{}
+	for _, schedule := range configuration.Schedules {
+		devices := configuration.devicesOnBridge(configuration.scheduleBridgeID(schedule), schedule.AssociatedDeviceIDs)
+		for bridgeID, directory := range directories {
+			if schedule.Bridge != "" && schedule.Bridge != bridgeID {
+				continue
+			}
+			for _, device := range configuration.devicesOnBridge(bridgeID, schedule.matchDevices(directory)) {
+				if !containsDevice(devices, device) {
+					devices = append(devices, device)
+				}
+			}
+		}
+		sort.Slice(devices, func(i, j int) bool {
+			if devices[i].Bridge != devices[j].Bridge {
+				return devices[i].Bridge < devices[j].Bridge
+			}
+			return devices[i].Light < devices[j].Light
+		})
+		if schedule.hasNamedDevices() {
+			log.Printf("⚙ Schedule %s - Associated lights: %v", schedule.Name, devices)
+		}
+		configuration.associations = append(configuration.associations, devices)
+	}
+	configuration.associationsDate = date
+}
+
+// resetAssociations forces the names of rooms, zones and lights
+// to be resolved again.
+func (configuration *Configuration) resetAssociations() {
+	associationsMutex.Lock()
+	defer associationsMutex.Unlock()
+	configuration.associations = nil
+	configuration.directoryDates = nil
+}
+
+// unconfiguredBridge returns the index of the first hue bridge without IP
+// or username. If all bridges are configured -1 is returned.
+func (configuration *Configuration) unconfiguredBridge() int {
+	for index, bridge := range configuration.Bridges {
+		if bridge.Type != "" && bridge.Type != backendHue {
+			continue
+		}
+		if bridge.IP == "" || bridge.Username == "" {
+			return index
+		}
+	}
+	return -1
+}
+
+// scheduleBridgeID returns the ID of the bridge the light IDs of the given
+// schedule refer to. If no bridge is configured, the first bridge is used.
+func (configuration *Configuration) scheduleBridgeID(schedule LightSchedule) string {
+	if schedule.Bridge != "" || len(configuration.Bridges) == 0 {
+		return schedule.Bridge
+	}
+	return configuration.Bridges[0].ID
+}
+
+func (configuration *Configuration) devicesOnBridge(bridgeID string, lightIDs []int) []DeviceID {
+	var devices []DeviceID
+	for _, id := range lightIDs {
+		devices = append(devices, DeviceID{bridgeID, id})
+	}
+	return devices
+}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// forDay returns the schedule with the variant for the given day applied.
+// Variants with dates take precedence over variants with weekdays only.
+// If several variants of the same kind match, the first one is used.
+func (schedule LightSchedule) forDay(date time.Time) LightSchedule {
+	var variant *ScheduleVariant
+	for _, datesOnly := range []bool{true, false} {
+		for index := range schedule.Variants {
+			candidate := &schedule.Variants[index]
+			if (candidate.From != "") != datesOnly {
+				continue
+			}
+			matches, err := candidate.matches(date)
+			if err != nil {
+				log.Warningf("⚙ Schedule %s - Ignoring invalid variant %s: %v", schedule.Name, candidate.Name, err)
+				continue
+			}
+			if matches {
+				variant = candidate
+				break
+			}
+		}
+		if variant != nil {
+			break
+		}
+	}
+	if variant == nil {
+		return schedule
+	}
+
+	log.Debugf("⚙ Schedule %s - Using variant %s for %v", schedule.Name, variant.Name, date.Format("Jan 2 2006"))
+	if variant.DefaultColorTemperature != nil {
+		schedule.DefaultColorTemperature = *variant.DefaultColorTemperature
+	}
+	if variant.DefaultBrightness != nil {
+		schedule.DefaultBrightness = *variant.DefaultBrightness
+	}
+	if variant.BeforeSunrise != nil {
+		schedule.BeforeSunrise = variant.BeforeSunrise
+	}
+	if variant.AfterSunset != nil {
+		schedule.AfterSunset = variant.AfterSunset
+	}
+	return schedule
+}
+
+// matches returns true if the variant applies to the given day.
+func (variant *ScheduleVariant) matches(date time.Time) (bool, error) {
+	if len(variant.Days) == 0 && variant.From == "" {
+		return false, errors.New("Neither days nor dates configured")
+	}
+
+	if len(variant.Days) > 0 {
+		found := false
+		for _, day := range variant.Days {
+			weekday, err := parseWeekday(day)
+			if err != nil {
+				return false, err
+			}
+			if weekday == date.Weekday() {
+				found = true
+			}
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if variant.From == "" {
+		return true, nil
+	}
+	to := variant.To
+	if to == "" {
+		to = variant.From
+	}
+	from, fromRecurring, err := parseVariantDate(variant.From)
+	if err != nil {
+		return false, err
+	}
+	until, untilRecurring, err := parseVariantDate(to)
+	if err != nil {
+		return false, err
+	}
+	if fromRecurring != untilRecurring {
+		return false, errors.New("Dates must either all contain a year or none")
+	}
+
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	if fromRecurring {
+		// Recurring ranges like 12-24 to 01-06 span the turn of the year
+		day = time.Date(0, date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		if until.Before(from) {
+			return !day.Before(from) || !day.After(until), nil
+		}
+	}
+	return !day.Before(from) && !day.After(until), nil
+}
+
+// parseWeekday parses the name of a weekday like "sat" or "saturday".
+func parseWeekday(day string) (time.Weekday, error) {
+	name := strings.ToLower(strings.TrimSpace(day))
+	if len(name) > 3 {
+		name = name[:3] // allow full names like "saturday"
+	}
+	weekday, ok := weekdays[name]
+	if !ok {
+		return weekday, fmt.Errorf("Unknown day %s", day)
+	}
+	return weekday, nil
+}
+
+// parseVariantDate parses a date of a variant. Dates without a year are
+// returned in year zero and reported as recurring.
+func parseVariantDate(value string) (time.Time, bool, error) {
+	date, err := time.Parse("2006-01-02", value)
+	if err == nil {
+		return date, false, nil
+	}
+	date, err = time.Parse("01-02", value)
+	if err != nil {
+		return date, false, fmt.Errorf("Invalid date %s. Please use YYYY-MM-DD or MM-DD", value)
+	}
+	return date, true, nil
+}
+
+func (schedule *LightSchedule) hasNamedDevices() bool {
+	return len(schedule.Rooms) > 0 || len(schedule.Zones) > 0 || len(schedule.Lights) > 0
+}
+
+// matchDevices returns the IDs of all lights in the directory matching the
+// rooms, zones and light names of the schedule. Names may contain glob
+// patterns like "Hallway*".
+func (schedule *LightSchedule) matchDevices(directory DeviceDirectory) []int {
+	var ids []int
+	add := func(candidates []int) {
+		for _, id := range candidates {
+			if !containsInt(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	for name, lights := range directory.Rooms {
+		if matchesAnyName(schedule.Rooms, name) {
+			add(lights)
+		}
+	}
+	for name, lights := range directory.Zones {
+		if matchesAnyName(schedule.Zones, name) {
+			add(lights)
+		}
+	}
+	for id, name := range directory.Lights {
+		if matchesAnyName(schedule.Lights, name) {
+			add([]int{id})
+		}
+	}
+
+	sort.Ints(ids)
+	return ids
+}
+
+func matchesAnyName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(name))
+		if err != nil {
+			log.Warningf("⚙ Invalid name pattern \"%s\": %v", pattern, err)
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
 }
 
 // Exists return true if a configuration file is found on disk.
@@ -314,17 +1037,85 @@ func (configuration *Configuration) HashValue() string {
 }
 
 // AsTimestamp parses and validates a TimedColorTemperature and returns
-// a corresponding TimeStamp.
-func (color *TimedColorTemperature) AsTimestamp(referenceTime time.Time) (TimeStamp, error) {
+// a corresponding TimeStamp. The time is either absolute like "22:00" or
+// relative to an event of the sun like "sunset+30m" or "sunrise-1h".
+func (color *TimedColorTemperature) AsTimestamp(referenceTime time.Time, sun SunTimes) (TimeStamp, error) {
+	if !isValidEasing(color.ColorTemperatureEasing) || !isValidBrightnessEasing(color.BrightnessEasing) {
+		return TimeStamp{time.Now(), color.ColorTemperature, color.Brightness, Easing{}}, fmt.Errorf("Unsupported easing in entry at %s", color.Time)
+	}
+	targetTime, relative, err := parseRelativeTime(color.Time, sun)
+	if err != nil {
+		return TimeStamp{time.Now(), color.ColorTemperature, color.Brightness, Easing{}}, err
+	}
+	if !relative {
+		targetTime, err = timeOnDay(referenceTime, color.Time)
+		if err != nil {
+			return TimeStamp{time.Now(), color.ColorTemperature, color.Brightness, Easing{}}, err
+		}
+	}
+
+	return TimeStamp{targetTime, color.ColorTemperature, color.Brightness, Easing{color.ColorTemperatureEasing, color.BrightnessEasing}}, nil
+}
+
+// timestampForDay returns the timestamp of this entry on the given day.
+// Entries after sunset lying before noon belong to the following night
+// and are moved to the next day.
+func (color *TimedColorTemperature) timestampForDay(date time.Time, sun SunTimes, afterSunset bool) (TimeStamp, error) {
+	timestamp, err := color.AsTimestamp(date, sun)
+	if err != nil {
+		return timestamp, err
+	}
+	noon, _ := timeOnDay(date, "12:00")
+	if afterSunset && timestamp.Time.Before(noon) {
+		timestamp.Time = timestamp.Time.AddDate(0, 0, 1)
+	}
+	return timestamp, nil
+}
+
+// timeOnDay parses a time formatted as "15:04" on the day of referenceTime.
+func timeOnDay(referenceTime time.Time, value string) (time.Time, error) {
 	layout := "15:04"
-	t, err := time.Parse(layout, color.Time)
+	t, err := time.Parse(layout, value)
 	if err != nil {
-		return TimeStamp{time.Now(), color.ColorTemperature, color.Brightness}, err
+		return t, err
 	}
 	yr, mth, day := referenceTime.Date()
-	targetTime := time.Date(yr, mth, day, t.Hour(), t.Minute(), t.Second(), 0, referenceTime.Location())
+	return time.Date(yr, mth, day, t.Hour(), t.Minute(), t.Second(), 0, referenceTime.Location()), nil
+}
 
-	return TimeStamp{targetTime, color.ColorTemperature, color.Brightness}, nil
+// parseRelativeTime evaluates a time relative to an event of the sun like
+// "sunset+30m". The offset is optional and has to be a valid duration.
+// It returns false if the time doesn't refer to an event of the sun.
+func parseRelativeTime(value string, sun SunTimes) (time.Time, bool, error) {
+	expression := strings.ToLower(strings.Join(strings.Fields(value), ""))
+	events := []struct {
+		name string
+		time time.Time
+	}{
+		{"sunrise", sun.Sunrise},
+		{"sunset", sun.Sunset},
+		{"noon", sun.Noon},
+		{"dawn", sun.Dawn},
+		{"dusk", sun.Dusk},
+	}
+	for _, event := range events {
+		if !strings.HasPrefix(expression, event.name) {
+			continue
+		}
+		offset := strings.TrimPrefix(expression, event.name)
+		if offset == "" {
+			return event.time, true, nil
+		}
+		if offset[0] != '+' && offset[0] != '-' {
+			return time.Time{}, true, fmt.Errorf("Invalid offset %s in time %s", offset, value)
+		}
+		duration, err := time.ParseDuration(offset)
+		if err != nil {
+			return time.Time{}, true, fmt.Errorf("Invalid offset %s in time %s: %v", offset, value, err)
+		}
+		return event.time.Add(duration), true, nil
+	}
+	return time.Time{}, false, nil
 }
 
 func (configuration *Configuration) backup() error {
@@ -30,6 +30,9 @@ func (configuration *Configuration) migrateToLatestVersion() {
 	if configuration.Version == 0 {
 		configuration.migrateVersion0()
 	}
+	if configuration.Version == 1 {
+		configuration.migrateVersion1()
+	}
 	log.Debugf("⚙ Migration of configuration complete")
 }
 
@@ -72,6 +75,23 @@ func (configuration *Configuration) migrateVersion0() {
 	log.Debugf("⚙ Migration to version 1 complete")
 }
 
+func (configuration *Configuration) migrateVersion1() {
+	log.Debugf("⚙ Migrating configuration version 1 to version 2...")
+
+	// Migration: Move single bridge into list of bridges
+	if configuration.Bridge != nil {
+		log.Debugf("⚙ Migrating bridge %s to list of bridges...", configuration.Bridge.IP)
+		configuration.Bridges = append([]Bridge{*configuration.Bridge}, configuration.Bridges...)
+		configuration.Bridge = nil
+	}
+	if len(configuration.Bridges) == 0 {
+		configuration.Bridges = []Bridge{{}}
+	}
+
+	configuration.Version = 2
+	log.Debugf("⚙ Migration to version 2 complete")
+}
+
 func migrateTimestampFormat(timestamp string) (string, error) {
 	// Check for old format and convert
 	layout := "3:04PM"
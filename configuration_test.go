@@ -1,7 +1,10 @@
 package main
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestReadOK(t *testing.T) {
@@ -52,3 +55,297 @@ func TestWriteOK(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchDevices(t *testing.T) {
+	directory := DeviceDirectory{
+		Lights: map[int]string{1: "Hallway 1", 2: "Hallway 2", 3: "Desk", 4: "Couch", 5: "Kitchen"},
+		Rooms:  map[string][]int{"Living room": {3, 4}, "Kitchen": {5}},
+		Zones:  map[string][]int{"Downstairs": {4, 5}},
+	}
+
+	tests := []struct {
+		schedule LightSchedule
+		expected []int
+	}{
+		{LightSchedule{Rooms: []string{"living room"}}, []int{3, 4}},
+		{LightSchedule{Zones: []string{"Downstairs"}}, []int{4, 5}},
+		{LightSchedule{Lights: []string{"Hallway*"}}, []int{1, 2}},
+		{LightSchedule{Rooms: []string{"Living room"}, Zones: []string{"Downstairs"}}, []int{3, 4, 5}},
+		{LightSchedule{Rooms: []string{"Bedroom"}, Lights: []string{"Garage"}}, nil},
+	}
+	for _, test := range tests {
+		ids := test.schedule.matchDevices(directory)
+		if len(ids) != len(test.expected) {
+			t.Errorf("matchDevices(%+v) = %v; want %v", test.schedule, ids, test.expected)
+			continue
+		}
+		for index := range ids {
+			if ids[index] != test.expected[index] {
+				t.Errorf("matchDevices(%+v) = %v; want %v", test.schedule, ids, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestMigrateBridges(t *testing.T) {
+	c := Configuration{Version: 1, Bridge: &Bridge{IP: "192.168.10.37", Username: "kelvin"}}
+	c.migrateToLatestVersion()
+	if c.Version != 2 {
+		t.Errorf("Configuration was migrated to version %d; want 2", c.Version)
+	}
+	if c.Bridge != nil {
+		t.Errorf("Single bridge should be removed after migration: %+v", c.Bridge)
+	}
+	if len(c.Bridges) != 1 || c.Bridges[0].IP != "192.168.10.37" || c.Bridges[0].Username != "kelvin" {
+		t.Errorf("Bridge was not migrated correctly: %+v", c.Bridges)
+	}
+
+	c = Configuration{Version: 1}
+	c.migrateToLatestVersion()
+	if len(c.Bridges) != 1 {
+		t.Errorf("Migration should create an empty bridge to be discovered: %+v", c.Bridges)
+	}
+}
+
+func TestDefaultConfigurationVersion(t *testing.T) {
+	c := Configuration{}
+	c.initializeDefaults()
+	if c.Version != latestConfigurationVersion {
+		t.Errorf("Default configuration has version %d; want %d", c.Version, latestConfigurationVersion)
+	}
+
+	c.Schedules[0].EnableWhenLightsAppear = false
+	c.migrateToLatestVersion()
+	if c.Version != latestConfigurationVersion || len(c.Bridges) != 1 || c.Schedules[0].EnableWhenLightsAppear {
+		t.Errorf("Default configuration should not be migrated again: %+v", c)
+	}
+}
+
+func TestReadMigratesBeforeDefaults(t *testing.T) {
+	c := Configuration{ConfigurationFile: filepath.Join(t.TempDir(), "config.json")}
+	err := ioutil.WriteFile(c.ConfigurationFile, []byte(`{"version": 1, "bridge": {"ip": "192.168.10.37", "username": "kelvin"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != latestConfigurationVersion || len(c.Schedules) != 1 {
+		t.Errorf("Configuration has version %d and %d schedules; want %d and the default schedule", c.Version, len(c.Schedules), latestConfigurationVersion)
+	}
+	if len(c.Bridges) != 1 || c.Bridges[0].IP != "192.168.10.37" || c.Bridges[0].Username != "kelvin" {
+		t.Errorf("Bridge was not kept: %+v", c.Bridges)
+	}
+}
+
+func TestScheduleVariants(t *testing.T) {
+	christmasColorTemperature, christmasBrightness := 2500, 0
+	schedule := LightSchedule{
+		Name:                    "default",
+		DefaultColorTemperature: 2750,
+		DefaultBrightness:       100,
+		BeforeSunrise:           []TimedColorTemperature{{Time: "6:00", ColorTemperature: 2000, Brightness: 60}},
+		Variants: []ScheduleVariant{
+			{Name: "weekend", Days: []string{"sat", "Sunday"}, BeforeSunrise: []TimedColorTemperature{{Time: "8:00", ColorTemperature: 2000, Brightness: 60}}},
+			{Name: "christmas", From: "12-24", To: "01-06", DefaultColorTemperature: &christmasColorTemperature, DefaultBrightness: &christmasBrightness},
+			{Name: "vacation", From: "2024-08-01", To: "2024-08-14", Days: []string{"sat"}, BeforeSunrise: []TimedColorTemperature{}},
+			{Name: "invalid", Days: []string{"someday"}},
+		},
+	}
+
+	tests := []struct {
+		date          time.Time
+		ct            int
+		brightness    int
+		beforeSunrise []TimedColorTemperature
+	}{
+		{time.Date(2024, 8, 7, 12, 0, 0, 0, time.UTC), 2750, 100, schedule.BeforeSunrise},              // Wednesday
+		{time.Date(2024, 8, 18, 12, 0, 0, 0, time.UTC), 2750, 100, schedule.Variants[0].BeforeSunrise}, // Sunday
+		{time.Date(2024, 8, 10, 12, 0, 0, 0, time.UTC), 2750, 100, []TimedColorTemperature{}},          // Saturday during vacation
+		{time.Date(2024, 8, 11, 12, 0, 0, 0, time.UTC), 2750, 100, schedule.Variants[0].BeforeSunrise}, // Sunday during vacation
+		{time.Date(2024, 12, 27, 12, 0, 0, 0, time.UTC), 2500, 0, schedule.BeforeSunrise},              // Friday during christmas
+		{time.Date(2025, 1, 4, 12, 0, 0, 0, time.UTC), 2500, 0, schedule.BeforeSunrise},                // Saturday during christmas
+		{time.Date(2025, 1, 7, 12, 0, 0, 0, time.UTC), 2750, 100, schedule.BeforeSunrise},              // Tuesday after christmas
+	}
+	for _, test := range tests {
+		result := schedule.forDay(test.date)
+		if result.DefaultColorTemperature != test.ct || result.DefaultBrightness != test.brightness || len(result.BeforeSunrise) != len(test.beforeSunrise) {
+			t.Errorf("forDay(%v) = %+v; want %dK at %d%% and %v", test.date.Format("Mon Jan 2 2006"), result, test.ct, test.brightness, test.beforeSunrise)
+			continue
+		}
+		for index := range result.BeforeSunrise {
+			if result.BeforeSunrise[index] != test.beforeSunrise[index] {
+				t.Errorf("forDay(%v) = %+v; want %v before sunrise", test.date.Format("Mon Jan 2 2006"), result, test.beforeSunrise)
+			}
+		}
+	}
+}
+
+func TestRelativeTimes(t *testing.T) {
+	zone := time.FixedZone("CEST", 2*60*60)
+	date := time.Date(2024, time.June, 21, 12, 0, 0, 0, zone)
+	sun := CalculateSunTimes(date, Location{Latitude: 52.52, Longitude: 13.40})
+	if !sun.Dawn.Before(sun.Sunrise) || !sun.Sunrise.Before(sun.Noon) || !sun.Noon.Before(sun.Sunset) || !sun.Sunset.Before(sun.Dusk) {
+		t.Fatalf("CalculateSunTimes returned events out of order: %+v", sun)
+	}
+
+	tests := []struct {
+		time     string
+		expected time.Time
+		valid    bool
+	}{
+		{"22:00", time.Date(2024, time.June, 21, 22, 0, 0, 0, zone), true},
+		{"sunset", sun.Sunset, true},
+		{"sunset+30m", sun.Sunset.Add(30 * time.Minute), true},
+		{"Sunrise - 1h", sun.Sunrise.Add(-time.Hour), true},
+		{"noon+1h30m", sun.Noon.Add(90 * time.Minute), true},
+		{"dawn", sun.Dawn, true},
+		{"dusk-15m", sun.Dusk.Add(-15 * time.Minute), true},
+		{"sunset30m", time.Time{}, false},
+		{"sunset+half", time.Time{}, false},
+		{"dusk+6h", sun.Dusk.Add(6 * time.Hour), true},
+		{"25:00", time.Time{}, false},
+	}
+	for _, test := range tests {
+		entry := TimedColorTemperature{Time: test.time, ColorTemperature: 2700, Brightness: 80}
+		timestamp, err := entry.AsTimestamp(date, sun)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("AsTimestamp(%s) should return an error", test.time)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("AsTimestamp(%s) returned error: %v", test.time, err)
+			continue
+		}
+		if !timestamp.Time.Equal(test.expected) {
+			t.Errorf("AsTimestamp(%s) = %v; want %v", test.time, timestamp.Time, test.expected)
+		}
+	}
+}
+
+func TestCrossingEntries(t *testing.T) {
+	zone := time.FixedZone("CET", 60*60)
+	var schedule LightSchedule
+	schedule.Name = "test"
+	entries := []TimedColorTemperature{{Time: "20:00", ColorTemperature: 2300, Brightness: 80}, {Time: "sunset+30m", ColorTemperature: 2000, Brightness: 60}}
+
+	tests := []struct {
+		date     time.Time
+		crossing bool
+	}{
+		{time.Date(2024, time.June, 21, 0, 0, 0, 0, zone), false},
+		{time.Date(2024, time.December, 21, 0, 0, 0, 0, zone), true},
+	}
+	for _, test := range tests {
+		sun := CalculateSunTimes(test.date, Location{Latitude: 52.52, Longitude: 13.40})
+		var timestamps []TimeStamp
+		for _, entry := range entries {
+			timestamp, err := entry.AsTimestamp(test.date, sun)
+			if err != nil {
+				t.Fatalf("AsTimestamp(%s) returned error: %v", entry.Time, err)
+			}
+			timestamps = append(timestamps, timestamp)
+		}
+		crossings := crossingEntries(entries, timestamps)
+		if (len(crossings) > 0) != test.crossing {
+			t.Errorf("crossingEntries on %v = %v; want crossing %v", test.date, crossings, test.crossing)
+		}
+
+		sorted := schedule.timestampsForDay(entries, test.date, sun, true)
+		if len(sorted) != 2 || sorted[1].Time.Before(sorted[0].Time) {
+			t.Errorf("timestampsForDay on %v = %v; want two timestamps sorted by time", test.date, sorted)
+		}
+	}
+}
+
+func TestClampTime(t *testing.T) {
+	date := time.Date(2024, time.June, 21, 0, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, time.June, 21, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		time     time.Time
+		earliest string
+		latest   string
+		expected time.Time
+		valid    bool
+	}{
+		{at(3, 40), "06:00", "", at(6, 0), true},
+		{at(9, 10), "", "07:30", at(7, 30), true},
+		{at(6, 45), "06:00", "07:30", at(6, 45), true},
+		{at(6, 45), "", "", at(6, 45), true},
+		{at(6, 45), "08:00", "07:00", date, false},
+		{at(6, 45), "six", "", date, false},
+	}
+	for _, test := range tests {
+		clamped, err := clampTime(test.time, test.earliest, test.latest)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("clampTime(%v, %s, %s) should return an error", test.time, test.earliest, test.latest)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("clampTime(%v, %s, %s) returned error: %v", test.time, test.earliest, test.latest, err)
+			continue
+		}
+		if !clamped.Equal(test.expected) {
+			t.Errorf("clampTime(%v, %s, %s) = %v; want %v", test.time, test.earliest, test.latest, clamped, test.expected)
+		}
+	}
+}
+
+func TestClampedSchedule(t *testing.T) {
+	zone := time.FixedZone("CET", 60*60)
+	date := time.Date(2024, time.December, 21, 0, 0, 0, 0, zone)
+
+	var c Configuration
+	c.Bridges = []Bridge{{ID: "bridge"}}
+	c.Location = Location{Latitude: 52.52, Longitude: 13.40}
+	c.Schedules = []LightSchedule{{
+		Name:                "clamped",
+		AssociatedDeviceIDs: []int{1},
+		LatestSunrise:       "07:00",
+		LatestSunset:        "19:00",
+		EarliestSunset:      "18:00",
+		BeforeSunrise:       []TimedColorTemperature{{Time: "06:00", ColorTemperature: 2000, Brightness: 60}, {Time: "07:30", ColorTemperature: 2300, Brightness: 80}},
+		AfterSunset:         []TimedColorTemperature{{Time: "17:30", ColorTemperature: 2300, Brightness: 80}, {Time: "sunset+30m", ColorTemperature: 2000, Brightness: 60}},
+	}}
+
+	schedule, err := c.lightScheduleForDay("bridge", 1, date)
+	if err != nil {
+		t.Fatalf("lightScheduleForDay returned error: %v", err)
+	}
+	if schedule.sunrise.Time.Format("15:04") != "07:00" || schedule.sunset.Time.Format("15:04") != "18:00" {
+		t.Errorf("Schedule should be clamped to 07:00 - 18:00 but is %v - %v", schedule.sunrise.Time.Format("15:04"), schedule.sunset.Time.Format("15:04"))
+	}
+	if len(schedule.beforeSunrise) != 1 || schedule.beforeSunrise[0].Time.Format("15:04") != "06:00" {
+		t.Errorf("Entries before sunrise should only contain 06:00 but are %v", schedule.beforeSunrise)
+	}
+	if len(schedule.afterSunset) != 1 || schedule.afterSunset[0].Time.Format("15:04") != "18:30" {
+		t.Errorf("Entries after sunset should only contain 18:30 but are %v", schedule.afterSunset)
+	}
+}
+
+// newTestConfiguration returns a configuration with a single bridge and the
+// given schedule, which is used as is.
+func newTestConfiguration(location Location, schedule LightSchedule) Configuration {
+	return Configuration{Bridges: []Bridge{{ID: "bridge"}}, Location: location, Schedules: []LightSchedule{schedule}}
+}
+
+// testScheduleForDay returns the schedule of light 1 of a configuration
+// created by newTestConfiguration for the given day.
+func testScheduleForDay(t *testing.T, c *Configuration, date time.Time) Schedule {
+	t.Helper()
+	schedule, err := c.lightScheduleForDay("bridge", 1, date)
+	if err != nil {
+		t.Fatalf("lightScheduleForDay on %v returned error: %v", date.Format("Jan 2 2006"), err)
+	}
+	return schedule
+}
@@ -0,0 +1,454 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+const backendDeconz = "deconz"
+const deconzDiscoveryEndpoint = "https://phoscon.de/discover"
+
+// DeconzBridge represents a deCONZ gateway (e.g. a Phoscon ConBee or
+// RaspBee). Its REST API follows the Hue API v1, so lights are controlled
+// as HueLight. Changes are reported via its websocket.
+type DeconzBridge struct {
+	bridge        hue.Bridge
+	ID            string
+	BridgeIP      string
+	Username      string
+	WebsocketPort int
+}
+
+// deconzConfig contains the relevant fields of the gateway configuration.
+type deconzConfig struct {
+	BridgeID      string `json:"bridgeid"`
+	Name          string `json:"name"`
+	ModelID       string `json:"modelid"`
+	SoftwareVer   string `json:"swversion"`
+	WebsocketPort int    `json:"websocketport"`
+}
+
+// deconzLight contains the capabilities of a light missing in
+// hue.LightAttributes.
+type deconzLight struct {
+	CTMin        int `json:"ctmin"`
+	CTMax        int `json:"ctmax"`
+	Capabilities struct {
+		Color struct {
+			CT struct {
+				Min int `json:"min"`
+				Max int `json:"max"`
+			} `json:"ct"`
+		} `json:"color"`
+	} `json:"capabilities"`
+}
+
+// deconzGroup represents a group of lights on the gateway.
+type deconzGroup struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Lights []string `json:"lights"`
+}
+
+// InitializeBridge connects to the deCONZ gateway configured at the given
+// index. If no gateway is configured it will be discovered via Phoscon,
+// followed by a registration of Kelvin on the gateway.
+func (bridge *DeconzBridge) InitializeBridge(ctx context.Context, configuration *Configuration, index int) error {
+	if index >= len(configuration.Bridges) {
+		return fmt.Errorf("Bridge %d is not configured", index)
+	}
+	bridgeConfiguration := &configuration.Bridges[index]
+
+	err := bridge.discover(bridgeConfiguration.IP)
+	if err != nil {
+		return err
+	}
+	bridgeConfiguration.IP = bridge.BridgeIP
+	if bridgeConfiguration.ID == "" {
+		bridgeConfiguration.ID = bridge.ID
+	}
+	bridge.ID = bridgeConfiguration.ID
+
+	if bridgeConfiguration.Username != "" {
+		bridge.Username = bridgeConfiguration.Username
+	} else {
+		log.Debugf("⌘ No API key found in configuration of deCONZ gateway %s. Starting registration...", bridge.ID)
+		err = bridge.register(ctx)
+		if err != nil {
+			return err
+		}
+		bridgeConfiguration.Username = bridge.Username
+	}
+
+	err = bridge.connect()
+	if err != nil {
+		return err
+	}
+	log.Printf("⌘ Connection to deCONZ gateway %s established", bridge.ID)
+	return nil
+}
+
+// discover validates the given address or looks for a gateway in the
+// local network via the Phoscon discovery if it is empty.
+func (bridge *DeconzBridge) discover(ip string) error {
+	if ip == "" {
+		log.Debugf("⌘ Starting deCONZ gateway discovery")
+		resp, err := http.Get(deconzDiscoveryEndpoint)
+		if resp != nil {
+			defer resp.Body.Close()
+		}
+		if err != nil {
+			return err
+		}
+		var gateways []struct {
+			IP   string `json:"internalipaddress"`
+			Port int    `json:"internalport"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&gateways)
+		if err != nil {
+			return err
+		}
+		if len(gateways) == 0 {
+			return errors.New("deCONZ gateway discovery failed. Please configure manually in config.json")
+		}
+		ip = gateways[0].IP
+		if gateways[0].Port != 0 && gateways[0].Port != 80 {
+			ip = fmt.Sprintf("%s:%d", ip, gateways[0].Port)
+		}
+		log.Printf("⌘ Found deCONZ gateway at %s", ip)
+	}
+
+	// The configuration is readable without an API key
+	bridge.BridgeIP = ip
+	var config deconzConfig
+	err := bridge.request("GET", "config", &config)
+	if err != nil {
+		return fmt.Errorf("Could not read configuration of deCONZ gateway %s: %v", ip, err)
+	}
+	if config.ModelID != "deCONZ" {
+		return fmt.Errorf("Gateway at %s is not running deCONZ", ip)
+	}
+	bridge.ID = strings.ToLower(config.BridgeID)
+	if bridge.ID == "" {
+		bridge.ID = ip
+	}
+	return nil
+}
+
+func (bridge *DeconzBridge) register(ctx context.Context) error {
+	bridge.bridge = *hue.NewBridge(bridge.BridgeIP, "")
+	username, err := registerUser(ctx, "PLEASE UNLOCK YOUR DECONZ GATEWAY IN THE PHOSCON APP (Gateway > Advanced > Authenticate app)", func() (string, error) {
+		err := bridge.bridge.CreateUser(hueBridgeAppName)
+		return bridge.bridge.Username, err
+	})
+	bridge.Username = username
+	return err
+}
+
+func (bridge *DeconzBridge) connect() error {
+	if bridge.Username == "" {
+		return errors.New("No API key for deCONZ gateway configured")
+	}
+	bridge.bridge = *hue.NewBridge(bridge.BridgeIP, bridge.Username)
+
+	var config deconzConfig
+	err := bridge.request("GET", bridge.Username+"/config", &config)
+	if err != nil {
+		return err
+	}
+	if config.WebsocketPort == 0 {
+		return errors.New("deCONZ gateway rejected the API key. Please remove it from config.json to register again")
+	}
+	bridge.WebsocketPort = config.WebsocketPort
+
+	if !*flagDisableRateLimiting {
+		bridge.bridge.EnableRateLimiting(timeBetweenHueAPICalls)
+	}
+	log.Debugf("⌘ Connected to deCONZ gateway \"%s\" (Version: %s, Websocket port: %d)", config.Name, config.SoftwareVer, config.WebsocketPort)
+	return nil
+}
+
+// Lights returns all lights of the gateway.
+func (bridge *DeconzBridge) Lights() ([]*Light, error) {
+	var lights []*Light
+	hueLights, err := bridge.bridge.GetAllLights()
+	if err != nil {
+		return lights, err
+	}
+	var resources map[string]deconzLight
+	err = bridge.request("GET", bridge.Username+"/lights", &resources)
+	if err != nil {
+		return lights, err
+	}
+
+	for _, hueLight := range hueLights {
+		var light Light
+		light.ID, err = strconv.Atoi(hueLight.Id)
+		if err != nil {
+			return lights, err
+		}
+		light.BridgeID = bridge.ID
+
+		device := &HueLight{HueLight: *hueLight}
+		device.initializeDeconz(hueLight.Attributes, resources[hueLight.Id])
+		light.Device = device
+		light.Name = device.Name
+		light.Reachable = device.Reachable
+		light.On = device.On
+
+		lights = append(lights, &light)
+	}
+
+	sort.Slice(lights, func(i, j int) bool { return lights[i].ID < lights[j].ID })
+	return lights, nil
+}
+
+// LightStates returns the current state of all lights on the gateway.
+func (bridge *DeconzBridge) LightStates() (map[int]DeviceState, error) {
+	var states = make(map[int]DeviceState)
+	attributes, err := bridge.lightAttributes()
+	if err != nil {
+		return states, err
+	}
+	for id, attr := range attributes {
+		states[id] = attr
+	}
+	return states, nil
+}
+
+func (bridge *DeconzBridge) lightAttributes() (map[int]hue.LightAttributes, error) {
+	var states = make(map[int]hue.LightAttributes)
+	hueLights, err := bridge.bridge.GetAllLights()
+	if err != nil {
+		return states, err
+	}
+	for _, hueLight := range hueLights {
+		lightID, err := strconv.Atoi(hueLight.Id)
+		if err != nil {
+			return states, err
+		}
+		states[lightID] = hueLight.Attributes
+	}
+	return states, nil
+}
+
+// LightEvents returns a stream reporting every change received via the
+// websocket of the gateway.
+func (bridge *DeconzBridge) LightEvents() LightEventSource {
+	return newDeconzEventStream(bridge)
+}
+
+func (bridge *DeconzBridge) bridgeID() string {
+	return bridge.ID
+}
+
+// deviceDirectory returns the names of all lights and groups. Groups
+// are reported as rooms.
+func (bridge *DeconzBridge) deviceDirectory() (DeviceDirectory, error) {
+	directory := DeviceDirectory{make(map[int]string), make(map[string][]int), make(map[string][]int)}
+	states, err := bridge.lightAttributes()
+	if err != nil {
+		return directory, err
+	}
+	for id, state := range states {
+		directory.Lights[id] = state.Name
+	}
+
+	var groups map[string]deconzGroup
+	err = bridge.request("GET", bridge.Username+"/groups", &groups)
+	if err != nil {
+		return directory, err
+	}
+	for _, group := range groups {
+		var ids []int
+		for _, light := range group.Lights {
+			id, err := strconv.Atoi(light)
+			if err != nil {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		directory.Rooms[group.Name] = ids
+	}
+	return directory, nil
+}
+
+// request reads the given resource below /api from the gateway.
+func (bridge *DeconzBridge) request(method string, resource string, result interface{}) error {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s/api/%s", bridge.BridgeIP, resource), nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Gateway returned HTTP %d for %s %s", resp.StatusCode, method, resource)
+	}
+	return json.Unmarshal(data, result)
+}
+
+// initializeDeconz initializes a light of a deCONZ gateway. The supported
+// color temperatures are taken from ctmin and ctmax.
+func (light *HueLight) initializeDeconz(attr hue.LightAttributes, resource deconzLight) {
+	light.initialize(attr)
+
+	ctMin, ctMax := resource.CTMin, resource.CTMax
+	if ctMin == 0 || ctMax == 0 {
+		ctMin, ctMax = resource.Capabilities.Color.CT.Min, resource.Capabilities.Color.CT.Max
+	}
+	if !light.SupportsColorTemperature || ctMin == 0 || ctMax == 0 {
+		return
+	}
+	// Prefer the capabilities reported by the light over our defaults
+	if !light.SupportsXYColor {
+		light.MinimumColorTemperature = 1000000 / ctMax
+	}
+	light.MaximumColorTemperature = 1000000 / ctMin
+	log.Debugf("💡 Light %s - Initialized via deCONZ (Temperature range: %dK - %dK)", light.Name, light.MinimumColorTemperature, light.MaximumColorTemperature)
+}
+
+// DeconzEventStream subscribes to the websocket of a deCONZ gateway and
+// reports every change of a light as hue.LightAttributes.
+type DeconzEventStream struct {
+	*eventSource
+	bridge *DeconzBridge
+}
+
+// deconzEvent represents a single message of the websocket.
+type deconzEvent struct {
+	Type     string `json:"t"`
+	Event    string `json:"e"`
+	Resource string `json:"r"`
+	ID       string `json:"id"`
+	State    *struct {
+		On        *bool     `json:"on"`
+		Bri       *int      `json:"bri"`
+		Ct        *int      `json:"ct"`
+		Xy        []float32 `json:"xy"`
+		ColorMode *string   `json:"colormode"`
+		Reachable *bool     `json:"reachable"`
+	} `json:"state"`
+}
+
+func newDeconzEventStream(bridge *DeconzBridge) *DeconzEventStream {
+	stream := &DeconzEventStream{bridge: bridge}
+	stream.eventSource = newEventSource("websocket of deCONZ gateway "+bridge.ID, stream.subscribe)
+	return stream
+}
+
+func (stream *DeconzEventStream) subscribe(ctx context.Context) error {
+	ws, err := dialWebsocket(websocketURL(stream.bridge.BridgeIP, stream.bridge.WebsocketPort), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer ws.Close()
+
+	// Reading from the websocket blocks until the connection is closed
+	subscribed := make(chan struct{})
+	defer close(subscribed)
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-subscribed:
+		}
+	}()
+	go ws.ping(subscribed)
+
+	states, err := stream.bridge.lightAttributes()
+	if err != nil {
+		return err
+	}
+	stream.synchronize(ctx, states)
+
+	for {
+		message, err := ws.ReadMessage()
+		if err != nil {
+			return err
+		}
+		var event deconzEvent
+		err = json.Unmarshal(message, &event)
+		if err != nil {
+			log.Debugf("⌘ Could not parse event: %v", err)
+			continue
+		}
+		stream.process(ctx, event)
+	}
+}
+
+func (stream *DeconzEventStream) process(ctx context.Context, event deconzEvent) {
+	if event.Type != "event" || event.Event != "changed" || event.Resource != "lights" || event.State == nil {
+		return
+	}
+	id, err := strconv.Atoi(event.ID)
+	if err != nil {
+		return
+	}
+	state, found := stream.states[id]
+	if !found {
+		return // new lights are added once a day
+	}
+
+	if event.State.On != nil {
+		state.State.On = *event.State.On
+	}
+	if event.State.Bri != nil {
+		state.State.Bri = *event.State.Bri
+	}
+	if event.State.Ct != nil {
+		state.State.Ct = *event.State.Ct
+	}
+	if len(event.State.Xy) == 2 {
+		state.State.Xy = event.State.Xy
+	}
+	if event.State.ColorMode != nil {
+		state.State.ColorMode = *event.State.ColorMode
+	}
+	if event.State.Reachable != nil {
+		state.State.Reachable = *event.State.Reachable
+	}
+	stream.states[id] = state
+	stream.publish(ctx, id)
+}
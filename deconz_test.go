@@ -0,0 +1,144 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+const deconzLights = `{
+  "1": {"name": "Ceiling", "type": "Color temperature light", "modelid": "TRADFRI bulb E27 WS opal 980lm", "manufacturername": "IKEA of Sweden", "ctmin": 250, "ctmax": 454,
+        "state": {"on": true, "bri": 254, "ct": 370, "colormode": "ct", "reachable": true}},
+  "2": {"name": "Configuration tool 1", "type": "Configuration tool", "modelid": "ConBee II", "state": {"reachable": true}}
+}`
+
+// newFakeDeconz emulates the REST API and websocket of a deCONZ gateway.
+func newFakeDeconz(events chan string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		port := server.Listener.Addr().(*net.TCPAddr).Port
+		switch r.URL.Path {
+		case "/api/config":
+			fmt.Fprint(w, `{"bridgeid": "00212EFFFF012345", "modelid": "deCONZ", "name": "Phoscon-GW"}`)
+		case "/api/secret/config":
+			fmt.Fprintf(w, `{"bridgeid": "00212EFFFF012345", "modelid": "deCONZ", "name": "Phoscon-GW", "swversion": "2.25.3", "websocketport": %d}`, port)
+		case "/api/secret/lights":
+			fmt.Fprint(w, deconzLights)
+		case "/api/secret/groups":
+			fmt.Fprint(w, `{"1": {"name": "Living room", "type": "LightGroup", "lights": ["1"]}}`)
+		case "/":
+			conn, _ := acceptWebsocket(w, r)
+			if conn == nil {
+				return
+			}
+			defer conn.Close()
+			for event := range events {
+				writeServerFrame(conn, true, websocketText, event)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+func TestDeconzBridge(t *testing.T) {
+	events := make(chan string, 1)
+	server := newFakeDeconz(events)
+	defer server.Close()
+
+	configuration := &Configuration{Bridges: []Bridge{{Type: backendDeconz, IP: strings.TrimPrefix(server.URL, "http://"), Username: "secret"}}}
+	bridge := &DeconzBridge{}
+	err := bridge.InitializeBridge(context.Background(), configuration, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bridge.ID != "00212effff012345" {
+		t.Errorf("Gateway has ID %s; want 00212effff012345", bridge.ID)
+	}
+
+	lights, err := bridge.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lights) != 2 {
+		t.Fatalf("Found %d lights; want 2", len(lights))
+	}
+	ceiling := lights[0].Device.(*HueLight)
+	if !ceiling.SupportsColorTemperature || ceiling.MinimumColorTemperature != 2202 || ceiling.MaximumColorTemperature != 4000 {
+		t.Errorf("Ceiling supports %dK - %dK; want 2202K - 4000K", ceiling.MinimumColorTemperature, ceiling.MaximumColorTemperature)
+	}
+	if lights[1].Device.supportsBrightness() || lights[1].Device.supportsColorTemperature() {
+		t.Errorf("Configuration tool should not be controllable")
+	}
+
+	directory, err := bridge.deviceDirectory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if directory.Lights[1] != "Ceiling" || len(directory.Rooms["Living room"]) != 1 {
+		t.Errorf("Unexpected directory %+v", directory)
+	}
+
+	stream := bridge.LightEvents()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		stream.Start(ctx)
+	}()
+	events <- `{"t":"event","e":"changed","r":"lights","id":"1","state":{"ct":250,"colormode":"ct"}}`
+	timeout := time.After(5 * time.Second)
+	for reported := false; !reported; {
+		select {
+		case event := <-stream.Events():
+			if event.ID == 1 && event.State.(hue.LightAttributes).State.Ct == 250 {
+				if !stream.IsConnected() {
+					t.Errorf("Stream should be connected")
+				}
+				reported = true
+			}
+		case <-timeout:
+			t.Fatal("Change was not reported")
+		}
+	}
+
+	cancel()
+	select {
+	case <-stopped:
+		if stream.IsConnected() {
+			t.Errorf("Stream should be disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not stop")
+	}
+}
@@ -0,0 +1,117 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import "math"
+
+// Supported easings between two timestamps. Perceptual easing is linear in
+// CIE L* for brightness and equals mired easing for color temperatures.
+const (
+	easingLinear     = "linear"
+	easingMired      = "mired"
+	easingPerceptual = "perceptual"
+	easingIn         = "ease-in"
+	easingOut        = "ease-out"
+	easingInOut      = "ease-in-out"
+	easingStep       = "step"
+)
+
+var easings = []string{easingLinear, easingMired, easingPerceptual, easingIn, easingOut, easingInOut, easingStep}
+
+// Easing selects how color temperature and brightness change on the way to
+// a timestamp. Empty values fall back to linear interpolation.
+type Easing struct {
+	ColorTemperature string
+	Brightness       string
+}
+
+func isValidEasing(easing string) bool {
+	return easing == "" || containsString(easings, easing)
+}
+
+// isValidBrightnessEasing reports whether the given easing can be used for
+// brightness. Mired easing only applies to color temperatures.
+func isValidBrightnessEasing(easing string) bool {
+	return isValidEasing(easing) && easing != easingMired
+}
+
+// easeColorTemperature returns the color temperature at progress (0-1)
+// between start and end.
+func easeColorTemperature(start int, end int, progress float64, easing string) int {
+	switch easing {
+	case easingMired, easingPerceptual:
+		if start <= 0 || end <= 0 {
+			break
+		}
+		startMired := 1000000 / float64(start)
+		endMired := 1000000 / float64(end)
+		return int(math.Round(1000000 / (startMired + (endMired-startMired)*progress)))
+	}
+	return start + int(float64(end-start)*easeProgress(progress, easing))
+}
+
+// easeBrightness returns the brightness at progress (0-1) between start
+// and end.
+func easeBrightness(start int, end int, progress float64, easing string) int {
+	switch easing {
+	case easingPerceptual:
+		startLightness := lightness(float64(start) / 100)
+		endLightness := lightness(float64(end) / 100)
+		return int(math.Round(luminance(startLightness+(endLightness-startLightness)*progress) * 100))
+	}
+	return start + int(float64(end-start)*easeProgress(progress, easing))
+}
+
+// easeProgress maps the linear progress of an interval according to the
+// given easing. Easings which don't change the progress return it as is.
+func easeProgress(progress float64, easing string) float64 {
+	switch easing {
+	case easingIn:
+		return progress * progress
+	case easingOut:
+		return 1 - (1-progress)*(1-progress)
+	case easingInOut:
+		return progress * progress * (3 - 2*progress)
+	case easingStep:
+		if progress < 1 {
+			return 0
+		}
+		return 1
+	}
+	return progress
+}
+
+// lightness converts a relative luminance (0-1) into CIE L* (0-100).
+func lightness(luminance float64) float64 {
+	if luminance <= 216.0/24389 {
+		return luminance * 24389 / 27
+	}
+	return 116*math.Cbrt(luminance) - 16
+}
+
+// luminance converts CIE L* (0-100) into a relative luminance (0-1).
+func luminance(lightness float64) float64 {
+	if lightness <= 8 {
+		return lightness * 27 / 24389
+	}
+	return math.Pow((lightness+16)/116, 3)
+}
@@ -0,0 +1,82 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEasingEndpointsAndMonotonicity(t *testing.T) {
+	ranges := [][2]int{{2000, 6500}, {6500, 2000}}
+	for _, easing := range easings {
+		for _, r := range ranges {
+			checkEasing(t, "color temperature", easing, r[0], r[1], easeColorTemperature)
+		}
+		for _, r := range [][2]int{{0, 100}, {100, 10}} {
+			checkEasing(t, "brightness", easing, r[0], r[1], easeBrightness)
+		}
+	}
+}
+
+func checkEasing(t *testing.T, dimension string, easing string, start int, end int, ease func(int, int, float64, string) int) {
+	if value := ease(start, end, 0, easing); value != start {
+		t.Errorf("%s easing %s from %d to %d starts at %d", dimension, easing, start, end, value)
+	}
+	if value := ease(start, end, 1, easing); value != end {
+		t.Errorf("%s easing %s from %d to %d ends at %d", dimension, easing, start, end, value)
+	}
+
+	previous := start
+	for step := 1; step <= 100; step++ {
+		value := ease(start, end, float64(step)/100, easing)
+		if (end > start && value < previous) || (end < start && value > previous) {
+			t.Errorf("%s easing %s from %d to %d is not monotonic at %d%%: %d after %d", dimension, easing, start, end, step, value, previous)
+			return
+		}
+		previous = value
+	}
+}
+
+func TestEasingShape(t *testing.T) {
+	// Mired easing shifts to warm color temperatures earlier than linear easing
+	if mired, linear := easeColorTemperature(6500, 2000, 0.5, easingMired), easeColorTemperature(6500, 2000, 0.5, easingLinear); mired >= linear {
+		t.Errorf("Mired easing at half time (%dK) should be warmer than linear easing (%dK)", mired, linear)
+	}
+	// Perceptual brightness reaches half lightness at 18% luminance
+	if brightness := easeBrightness(100, 0, 0.5, easingPerceptual); brightness != 18 {
+		t.Errorf("Perceptual easing at half time = %d%%; want 18%%", brightness)
+	}
+	if brightness := easeBrightness(100, 0, 0.99, easingStep); brightness != 100 {
+		t.Errorf("Step easing should hold the start value but is %d%%", brightness)
+	}
+	if isValidBrightnessEasing(easingMired) {
+		t.Errorf("Mired easing should not be accepted for brightness")
+	}
+
+	start := time.Date(2024, time.June, 21, 20, 0, 0, 0, time.UTC)
+	interval := Interval{TimeStamp{start, 4000, 100, Easing{}}, TimeStamp{start.Add(time.Hour), 2000, 50, Easing{easingStep, easingInOut}}, nil}
+	state := interval.calculateLightStateInInterval(start.Add(15 * time.Minute))
+	if state.ColorTemperature != 4000 || state.Brightness != 93 {
+		t.Errorf("Interval should use the easing of its end: %+v", state)
+	}
+}
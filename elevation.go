@@ -0,0 +1,75 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// elevationCurve calculates the light state between sunrise and sunset from
+// the elevation of the sun at a position on earth. The light state is
+// interpolated linearly between the configured points of the curve.
+type elevationCurve struct {
+	points    []ElevationColorTemperature
+	latitude  float64
+	longitude float64
+}
+
+func newElevationCurve(points []ElevationColorTemperature, location Location) *elevationCurve {
+	curve := &elevationCurve{latitude: location.Latitude, longitude: location.Longitude}
+	curve.points = append(curve.points, points...)
+	sort.SliceStable(curve.points, func(i, j int) bool { return curve.points[i].Elevation < curve.points[j].Elevation })
+	return curve
+}
+
+// lightState returns the light state for the elevation of the sun at the
+// given time. Elevations outside of the curve use the closest point.
+func (curve *elevationCurve) lightState(timestamp time.Time) LightState {
+	return curve.lightStateAtElevation(CalculateSolarElevation(timestamp, curve.latitude, curve.longitude))
+}
+
+func (curve *elevationCurve) lightStateAtElevation(elevation float64) LightState {
+	first := curve.points[0]
+	if elevation <= first.Elevation {
+		return LightState{first.ColorTemperature, first.Brightness}
+	}
+	last := curve.points[len(curve.points)-1]
+	if elevation >= last.Elevation {
+		return LightState{last.ColorTemperature, last.Brightness}
+	}
+
+	index := sort.Search(len(curve.points), func(i int) bool { return curve.points[i].Elevation > elevation })
+	lower, upper := curve.points[index-1], curve.points[index]
+	progress := (elevation - lower.Elevation) / (upper.Elevation - lower.Elevation)
+	return LightState{interpolate(lower.ColorTemperature, upper.ColorTemperature, progress), interpolate(lower.Brightness, upper.Brightness, progress)}
+}
+
+// interpolate returns the value at progress between start and end.
+// If one of both values should be ignored (-1) the result is ignored as well.
+func interpolate(start int, end int, progress float64) int {
+	if start == -1 || end == -1 {
+		return -1
+	}
+	return start + int(math.Round(float64(end-start)*progress))
+}
@@ -0,0 +1,78 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSolarElevation(t *testing.T) {
+	// Upper and lower culminations of the sun in Berlin at solstices and equinox
+	tests := []struct {
+		time      time.Time
+		elevation float64
+	}{
+		{time.Date(2024, time.June, 21, 11, 8, 0, 0, time.UTC), 60.9},
+		{time.Date(2024, time.December, 21, 11, 4, 0, 0, time.UTC), 14.0},
+		{time.Date(2024, time.June, 21, 23, 8, 0, 0, time.UTC), -14.0},
+		{time.Date(2024, time.March, 20, 11, 14, 0, 0, time.UTC), 37.5},
+	}
+	for _, test := range tests {
+		elevation := CalculateSolarElevation(test.time, 52.52, 13.40)
+		if math.Abs(elevation-test.elevation) > 0.5 {
+			t.Errorf("CalculateSolarElevation(%v) = %.2f; want %.2f", test.time, elevation, test.elevation)
+		}
+	}
+}
+
+func TestElevationCurve(t *testing.T) {
+	points := []ElevationColorTemperature{{40, 5000, 100}, {0, 2700, 60}, {10, 3500, -1}}
+	curve := newElevationCurve(points, Location{Latitude: 52.52, Longitude: 13.40})
+
+	tests := []struct {
+		elevation float64
+		expected  LightState
+	}{
+		{-10, LightState{2700, 60}},
+		{0, LightState{2700, 60}},
+		{5, LightState{3100, -1}},
+		{25, LightState{4250, -1}},
+		{40, LightState{5000, 100}},
+		{60, LightState{5000, 100}},
+	}
+	for _, test := range tests {
+		state := curve.lightStateAtElevation(test.elevation)
+		if state != test.expected {
+			t.Errorf("lightStateAtElevation(%v) = %+v; want %+v", test.elevation, state, test.expected)
+		}
+	}
+
+	// The curve should follow the sun over the day
+	noon := curve.lightState(time.Date(2024, time.June, 21, 11, 8, 0, 0, time.UTC))
+	evening := curve.lightState(time.Date(2024, time.June, 21, 17, 0, 0, 0, time.UTC))
+	winter := curve.lightState(time.Date(2024, time.December, 21, 11, 4, 0, 0, time.UTC))
+	if noon.ColorTemperature != 5000 || evening.ColorTemperature >= noon.ColorTemperature || winter.ColorTemperature >= noon.ColorTemperature {
+		t.Errorf("Curve should peak at noon in summer: noon %+v, evening %+v, winter noon %+v", noon, evening, winter)
+	}
+}
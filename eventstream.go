@@ -0,0 +1,285 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+const eventStreamReconnectInterval = 10 * time.Second
+
+// eventSource implements a LightEventSource reporting the state of lights
+// as hue.LightAttributes. It keeps reconnecting via the subscribe function
+// of the backend, which reads events until the connection drops.
+type eventSource struct {
+	description string
+	events      chan LightEvent
+	connected   int32
+	states      map[int]hue.LightAttributes
+	subscribe   func(ctx context.Context) error
+}
+
+func newEventSource(description string, subscribe func(ctx context.Context) error) *eventSource {
+	source := &eventSource{description: description, subscribe: subscribe}
+	source.events = make(chan LightEvent, 64)
+	return source
+}
+
+// Events returns the channel all light events are sent to.
+func (source *eventSource) Events() <-chan LightEvent {
+	return source.events
+}
+
+// Start connects to the event source and keeps reconnecting whenever the
+// connection drops. It returns when the given context is done and should
+// be run in its own goroutine.
+func (source *eventSource) Start(ctx context.Context) {
+	for {
+		err := source.subscribe(ctx)
+		atomic.StoreInt32(&source.connected, 0)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Warningf("⌘ Lost connection to %s: %v - Falling back to polling and reconnecting in %v...", source.description, err, eventStreamReconnectInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventStreamReconnectInterval):
+		}
+	}
+}
+
+// IsConnected returns true if the source is receiving events.
+// If it returns false light states have to be polled.
+func (source *eventSource) IsConnected() bool {
+	return atomic.LoadInt32(&source.connected) == 1
+}
+
+// synchronize reports the given states of all lights and marks the source
+// as connected. Backends call it once they are subscribed as events may
+// have been lost while we were disconnected.
+func (source *eventSource) synchronize(ctx context.Context, states map[int]hue.LightAttributes) {
+	source.states = states
+	for id := range states {
+		source.publish(ctx, id)
+	}
+	atomic.StoreInt32(&source.connected, 1)
+	log.Printf("⌘ Subscribed to %s", source.description)
+}
+
+func (source *eventSource) publish(ctx context.Context, id int) {
+	state := source.states[id]
+	// Don't share the color slice with the receiver
+	state.State.Xy = append([]float32(nil), state.State.Xy...)
+	select {
+	case source.events <- LightEvent{id, state}:
+	case <-ctx.Done(): // nobody is listening anymore
+	}
+}
+
+// LightEventStream subscribes to the Server-Sent Events eventstream of the
+// Hue API v2 and reports every change of a light as hue.LightAttributes.
+type LightEventStream struct {
+	*eventSource
+	client   *clipV2Client
+	lightIDs map[string]int
+	devices  map[string][]int
+}
+
+// clipV2Event represents a single event of the eventstream.
+type clipV2Event struct {
+	Type string          `json:"type"`
+	Data []clipV2Changes `json:"data"`
+}
+
+// clipV2Changes contains the changed fields of a resource. Fields which are
+// not part of the event are nil.
+type clipV2Changes struct {
+	ID    string          `json:"id"`
+	Type  string          `json:"type"`
+	Owner clipV2Reference `json:"owner"`
+	On    *struct {
+		On bool `json:"on"`
+	} `json:"on"`
+	Dimming *struct {
+		Brightness float64 `json:"brightness"`
+	} `json:"dimming"`
+	ColorTemperature *struct {
+		Mirek      *int `json:"mirek"`
+		MirekValid bool `json:"mirek_valid"`
+	} `json:"color_temperature"`
+	Color *struct {
+		XY clipV2XY `json:"xy"`
+	} `json:"color"`
+	Status string `json:"status"`
+}
+
+func newLightEventStream(client *clipV2Client) *LightEventStream {
+	stream := &LightEventStream{client: client}
+	stream.eventSource = newEventSource("event stream of bridge "+client.address, stream.subscribe)
+	return stream
+}
+
+func (stream *LightEventStream) subscribe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+stream.client.address+"/eventstream/clip/v2", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("hue-application-key", stream.client.key)
+	req.Header.Set("Accept", "text/event-stream")
+
+	// The stream is open indefinitely, so we must not use the client timeout
+	client := &http.Client{Transport: stream.client.client.Transport}
+	resp, err := client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Bridge returned HTTP %d", resp.StatusCode)
+	}
+
+	err = stream.synchronize(ctx)
+	if err != nil {
+		return err
+	}
+
+	return stream.read(ctx, resp.Body)
+}
+
+// read processes the Server-Sent Events of the given body until it is
+// closed. Events spanning multiple data lines are joined as defined by
+// the specification and dispatched on the following empty line.
+func (stream *LightEventStream) read(ctx context.Context, body io.Reader) error {
+	var data []string
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 {
+				stream.dispatch(ctx, strings.Join(data, "\n"))
+				data = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// ignore ids, comments and keep alive messages
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("Connection closed by bridge")
+}
+
+func (stream *LightEventStream) dispatch(ctx context.Context, data string) {
+	var events []clipV2Event
+	err := json.Unmarshal([]byte(data), &events)
+	if err != nil {
+		log.Debugf("⌘ Could not parse event: %v", err)
+		return
+	}
+	stream.process(ctx, events)
+}
+
+// synchronize fetches the state of all lights and reports them.
+func (stream *LightEventStream) synchronize(ctx context.Context) error {
+	resources, states, err := stream.client.lightAttributes()
+	if err != nil {
+		return err
+	}
+
+	stream.lightIDs = make(map[string]int)
+	stream.devices = make(map[string][]int)
+	for id, resource := range resources {
+		stream.lightIDs[resource.ID] = id
+		stream.devices[resource.Owner.RID] = append(stream.devices[resource.Owner.RID], id)
+	}
+	stream.eventSource.synchronize(ctx, states)
+	return nil
+}
+
+func (stream *LightEventStream) process(ctx context.Context, events []clipV2Event) {
+	for _, event := range events {
+		if event.Type != "update" {
+			continue
+		}
+		for _, changes := range event.Data {
+			switch changes.Type {
+			case "light":
+				id, found := stream.lightIDs[changes.ID]
+				if !found {
+					continue
+				}
+				stream.applyLightChanges(id, changes)
+				stream.publish(ctx, id)
+			case "zigbee_connectivity":
+				for _, id := range stream.devices[changes.Owner.RID] {
+					state := stream.states[id]
+					state.State.Reachable = changes.Status == "connected"
+					stream.states[id] = state
+					stream.publish(ctx, id)
+				}
+			}
+		}
+	}
+}
+
+func (stream *LightEventStream) applyLightChanges(id int, changes clipV2Changes) {
+	state := stream.states[id]
+	if changes.On != nil {
+		state.State.On = changes.On.On
+	}
+	if changes.Dimming != nil {
+		state.State.Bri = int(math.Round(changes.Dimming.Brightness / 100 * 254))
+	}
+	if changes.Color != nil {
+		state.State.Xy = []float32{changes.Color.XY.X, changes.Color.XY.Y}
+		state.State.ColorMode = "xy"
+	}
+	if changes.ColorTemperature != nil {
+		if changes.ColorTemperature.MirekValid && changes.ColorTemperature.Mirek != nil {
+			state.State.Ct = *changes.ColorTemperature.Mirek
+			state.State.ColorMode = "ct"
+		} else {
+			state.State.ColorMode = "xy"
+		}
+	}
+	stream.states[id] = state
+}
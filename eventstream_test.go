@@ -0,0 +1,144 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// Recorded from the eventstream of a Hue bridge (firmware 1.60) and
+// shortened to the relevant fields.
+const (
+	clipV2EventLight = `[{"creationtime":"2024-03-02T19:48:11Z","id":"b0c2e9a4-6d1f-4a2b-9c3d-8e7f6a5b4c01","type":"update","data":[` +
+		`{"id":"3f8e4b6a-5c1d-4c1e-9a53-0c6f1b8d2e11","id_v1":"/lights/1","owner":{"rid":"a1b2c3d4-0001-4000-8000-000000000001","rtype":"device"},"dimming":{"brightness":100.0},"type":"light"},` +
+		`{"id":"3f8e4b6a-5c1d-4c1e-9a53-0c6f1b8d2e11","id_v1":"/lights/1","owner":{"rid":"a1b2c3d4-0001-4000-8000-000000000001","rtype":"device"},"color_temperature":{"mirek":250,"mirek_valid":true},"type":"light"}]}]`
+	clipV2EventMultiple = `[{"creationtime":"2024-03-02T19:48:15Z","id":"b0c2e9a4-6d1f-4a2b-9c3d-8e7f6a5b4c02","type":"update","data":[` +
+		`{"id":"f0e1d2c3-0002-4000-8000-00000000000b","id_v1":"/lights/4","owner":{"rid":"a1b2c3d4-0002-4000-8000-000000000002","rtype":"device"},"status":"connected","type":"zigbee_connectivity"}]},` +
+		`{"creationtime":"2024-03-02T19:48:15Z","id":"b0c2e9a4-6d1f-4a2b-9c3d-8e7f6a5b4c03","type":"update","data":[` +
+		`{"id":"9c0d2f7e-1b3a-4e5f-8a6b-7c8d9e0f1a22","id_v1":"/lights/4","owner":{"rid":"a1b2c3d4-0002-4000-8000-000000000002","rtype":"device"},"on":{"on":true},"type":"light"}]}]`
+	clipV2EventIgnored = `[{"creationtime":"2024-03-02T19:48:20Z","id":"b0c2e9a4-6d1f-4a2b-9c3d-8e7f6a5b4c04","type":"update","data":[` +
+		`{"id":"0d1e2f3a-4b5c-4d6e-8f7a-9b0c1d2e3f44","owner":{"rid":"a1b2c3d4-0004-4000-8000-000000000004","rtype":"device"},"dimming":{"brightness":10.0},"type":"light"},` +
+		`{"id":"7a8b9c0d-1e2f-4a3b-8c4d-5e6f7a8b9c55","owner":{"rid":"a1b2c3d4-0001-4000-8000-000000000001","rtype":"device"},"temperature":{"temperature":21.5},"type":"temperature"}]},` +
+		`{"creationtime":"2024-03-02T19:48:20Z","id":"b0c2e9a4-6d1f-4a2b-9c3d-8e7f6a5b4c05","type":"add","data":[` +
+		`{"id":"3f8e4b6a-5c1d-4c1e-9a53-0c6f1b8d2e11","owner":{"rid":"a1b2c3d4-0001-4000-8000-000000000001","rtype":"device"},"type":"light"}]}]`
+	clipV2EventColor = `[{"creationtime":"2024-03-02T19:48:25Z","id":"b0c2e9a4-6d1f-4a2b-9c3d-8e7f6a5b4c06","type":"update","data":[` +
+		`{"id":"3f8e4b6a-5c1d-4c1e-9a53-0c6f1b8d2e11","id_v1":"/lights/1","owner":{"rid":"a1b2c3d4-0001-4000-8000-000000000001","rtype":"device"},"color":{"xy":{"x":0.3127,"y":0.329}},"color_temperature":{"mirek":null,"mirek_valid":false},"type":"light"}]}]`
+)
+
+func receiveLightEvent(t *testing.T, stream LightEventSource) hue.LightAttributes {
+	t.Helper()
+	select {
+	case event := <-stream.Events():
+		return event.State.(hue.LightAttributes)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Change was not reported")
+	}
+	return hue.LightAttributes{}
+}
+
+func TestLightEventStream(t *testing.T) {
+	fake, client := newFakeClipV2(t)
+	defer fake.server.Close()
+
+	stream := newLightEventStream(client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		stream.Start(ctx)
+	}()
+
+	// All lights are reported once subscribed
+	reported := make(map[int]hue.LightAttributes)
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-stream.Events():
+			reported[event.ID] = event.State.(hue.LightAttributes)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Initial states were not reported")
+		}
+	}
+	if len(reported) != 3 || reported[4].State.Reachable || !stream.IsConnected() {
+		t.Fatalf("Unexpected initial states %+v", reported)
+	}
+
+	// A comment and an id before the event, which is split within a line
+	half := len(clipV2EventLight) / 2
+	fake.events <- ": hi\n\nid: 1709408891:0\ndata: " + clipV2EventLight[:half]
+	fake.events <- clipV2EventLight[half:] + "\n\n"
+	state := receiveLightEvent(t, stream)
+	if state.State.Bri != 254 || state.State.Ct != 366 {
+		t.Errorf("First change reported bri: %d, ct: %d; want 254, 366", state.State.Bri, state.State.Ct)
+	}
+	state = receiveLightEvent(t, stream)
+	if state.State.Bri != 254 || state.State.Ct != 250 || state.State.ColorMode != "ct" {
+		t.Errorf("Second change reported bri: %d, ct: %d, colormode: %s; want 254, 250, ct", state.State.Bri, state.State.Ct, state.State.ColorMode)
+	}
+
+	// Multiple events in one message with CRLF line endings
+	fake.events <- "id: 1709408895:0\r\ndata: " + clipV2EventMultiple + "\r\n\r\n"
+	state = receiveLightEvent(t, stream)
+	if !state.State.Reachable || state.State.On {
+		t.Errorf("Connectivity change reported reachable: %t, on: %t; want true, false", state.State.Reachable, state.State.On)
+	}
+	state = receiveLightEvent(t, stream)
+	if !state.State.Reachable || !state.State.On {
+		t.Errorf("Light change reported reachable: %t, on: %t; want true, true", state.State.Reachable, state.State.On)
+	}
+
+	// Unknown lights, other resources and other event types are ignored.
+	// Data spanning multiple lines is joined.
+	fake.events <- "data: " + clipV2EventIgnored + "\n\n"
+	index := strings.Index(clipV2EventColor, `"color":`)
+	fake.events <- "data: " + clipV2EventColor[:index] + "\ndata: " + clipV2EventColor[index:] + "\n\n"
+	state = receiveLightEvent(t, stream)
+	if state.Name != "Living room" || state.State.ColorMode != "xy" || !reflect.DeepEqual(state.State.Xy, []float32{0.3127, 0.329}) {
+		t.Errorf("Color change reported %s with colormode: %s, xy: %v; want Living room, xy, [0.3127 0.329]", state.Name, state.State.ColorMode, state.State.Xy)
+	}
+
+	// Invalid events don't break the stream
+	fake.events <- "data: [{\"type\":\"update\",\n\n"
+	fake.events <- "data: " + clipV2EventLight + "\n\n"
+	state = receiveLightEvent(t, stream)
+	if state.State.Bri != 254 {
+		t.Errorf("Change after invalid event reported bri: %d; want 254", state.State.Bri)
+	}
+	receiveLightEvent(t, stream)
+
+	close(fake.events)
+	cancel()
+	select {
+	case <-stopped:
+		if stream.IsConnected() {
+			t.Errorf("Stream should be disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stream did not stop")
+	}
+}
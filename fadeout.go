@@ -0,0 +1,95 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A fade-out is sent to the lights as a series of long transitions. Bridges
+// limit the duration of a single transition and manual changes can only be
+// detected once a transition has finished.
+const fadeOutStep = 2 * time.Minute
+
+// currentFadeOut returns the fade-out of the schedule running at the given
+// time. Only lights turned on before a fade-out started are faded, so a
+// light turned on again afterwards stays on.
+func (light *Light) currentFadeOut(timestamp time.Time) *Interval {
+	for index := range light.Schedule.fadeOuts {
+		fadeOut := &light.Schedule.fadeOuts[index]
+		if timestamp.Before(fadeOut.Start.Time) || !timestamp.Before(fadeOut.End.Time) {
+			continue
+		}
+		if light.FadeOutStart.Equal(fadeOut.Start.Time) {
+			if light.FadingOut {
+				return fadeOut
+			}
+			continue // already faded out or aborted
+		}
+		if light.Appearance.Before(fadeOut.Start.Time) {
+			return fadeOut
+		}
+	}
+	return nil
+}
+
+// fadeOut dims the light step by step until it is turned off at the end of
+// the given fade-out. The brightness offset of the light is kept for every
+// step. Manual changes abort the fade-out.
+func (light *Light) fadeOut(fadeOut *Interval) (bool, error) {
+	now := time.Now()
+	if light.FadingOut {
+		// Give the light some time to finish the current transition
+		if now.Before(light.FadeOutStepEnd.Add(initializationDuration)) {
+			return false, nil
+		}
+		if light.Device.hasChanged() {
+			log.Printf("💡 Light %s - Light state has been changed manually. Aborting fade-out...", light.Name)
+			light.FadingOut = false
+			light.disableAutomaticMode()
+			return false, nil
+		}
+	} else {
+		log.Printf("💡 Light %s - Fading out until %v", light.Name, fadeOut.End.Time.Format("15:04"))
+		light.FadingOut = true
+		light.FadeOutStart = fadeOut.Start.Time
+	}
+
+	stepEnd := now.Add(fadeOutStep)
+	if stepEnd.After(fadeOut.End.Time) {
+		stepEnd = fadeOut.End.Time
+	}
+	state := fadeOut.calculateLightStateInInterval(stepEnd)
+	state.Brightness = light.BrightnessOffset.apply(state.Brightness)
+	if !light.AutomaticColorTemperature {
+		state.ColorTemperature = -1
+	}
+	err := light.Device.setLightState(state.ColorTemperature, state.Brightness, stepEnd.Sub(now))
+	if err != nil {
+		return true, err
+	}
+	light.FadeOutStepEnd = stepEnd
+	log.Debugf("💡 Light %s - Fading to %vK at %v%% brightness until %v", light.Name, state.ColorTemperature, state.Brightness, stepEnd.Format("15:04:05"))
+	return true, nil
+}
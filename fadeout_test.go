@@ -0,0 +1,106 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFadeOut(t *testing.T) {
+	zone := time.FixedZone("CET", 60*60)
+	date := time.Date(2024, time.March, 10, 0, 0, 0, 0, zone)
+
+	c := newTestConfiguration(Location{Latitude: 52.52, Longitude: 13.40}, LightSchedule{
+		Name:                    "bedtime",
+		AssociatedDeviceIDs:     []int{1},
+		DefaultColorTemperature: 4000,
+		DefaultBrightness:       100,
+		AfterSunset:             []TimedColorTemperature{{Time: "20:00", ColorTemperature: 2700, Brightness: 80}, {Time: "23:00", ColorTemperature: 2000, Brightness: 40, FadeOut: 20}},
+	})
+	schedule := testScheduleForDay(t, &c, date)
+	start := time.Date(2024, time.March, 10, 23, 0, 0, 0, zone)
+	var light Light
+	light.Schedule = schedule
+	light.Appearance = start.Add(-time.Hour)
+
+	fadeOut := light.currentFadeOut(start.Add(10 * time.Minute))
+	if fadeOut == nil || !fadeOut.Start.Time.Equal(start) || fadeOut.End.Time.Sub(start) != 20*time.Minute {
+		t.Fatalf("currentFadeOut(23:10) = %+v; want fade-out from 23:00 to 23:20", fadeOut)
+	}
+	if state := fadeOut.calculateLightStateInInterval(start); state.Brightness != 40 || state.ColorTemperature != 2000 {
+		t.Errorf("Fade-out should start at 2000K and 40%% but starts at %+v", state)
+	}
+	if state := fadeOut.calculateLightStateInInterval(fadeOut.End.Time); state.Brightness != 0 {
+		t.Errorf("Fade-out should end with the light turned off but ends at %+v", state)
+	}
+	if light.currentFadeOut(start.Add(-time.Minute)) != nil || light.currentFadeOut(start.Add(20*time.Minute)) != nil {
+		t.Errorf("currentFadeOut should only return a fade-out between 23:00 and 23:20")
+	}
+
+	// A fade-out is only applied once
+	light.FadeOutStart = start
+	if light.currentFadeOut(start.Add(10*time.Minute)) != nil {
+		t.Errorf("Finished fade-out should not be repeated")
+	}
+
+	// Lights turned on during a fade-out are not faded
+	light.FadeOutStart = time.Time{}
+	light.Appearance = start.Add(5 * time.Minute)
+	if light.currentFadeOut(start.Add(10*time.Minute)) != nil {
+		t.Errorf("Light turned on after the start of the fade-out should not be faded")
+	}
+}
+
+func TestFadeOutBrightnessOffset(t *testing.T) {
+	fake, client := newFakeClipV2(t)
+	defer fake.server.Close()
+
+	now := time.Now()
+	tests := []struct {
+		offset   *BrightnessOffset
+		end      time.Time
+		expected func(brightness int) int
+	}{
+		{nil, now.Add(time.Hour), func(brightness int) int { return brightness }},
+		{&BrightnessOffset{Mode: brightnessOffsetAbsolute, Value: 20}, now.Add(time.Hour), func(brightness int) int { return brightness + 20 }},
+		{&BrightnessOffset{Mode: brightnessOffsetAbsolute, Value: 90}, now.Add(time.Hour), func(brightness int) int { return 100 }},
+		{&BrightnessOffset{Mode: brightnessOffsetRelative, Value: 0.5}, now.Add(time.Hour), func(brightness int) int { return int(math.Round(float64(brightness) * 0.5)) }},
+		{&BrightnessOffset{Mode: brightnessOffsetAbsolute, Value: 20}, now.Add(time.Minute), func(brightness int) int { return 0 }}, // last step turns the light off
+	}
+
+	for _, test := range tests {
+		fadeOut := &Interval{Start: TimeStamp{Time: now.Add(-time.Hour), ColorTemperature: 2000, Brightness: 40}, End: TimeStamp{Time: test.end, ColorTemperature: 2000, Brightness: 0}}
+		device := &HueLight{Name: "Bedroom", Dimmable: true, SupportsColorTemperature: true, MinimumColorTemperature: 2000, MaximumColorTemperature: 6500, clip: client, clipID: "3f8e4b6a"}
+		light := Light{Name: "Bedroom", Device: device, AutomaticColorTemperature: true, BrightnessOffset: test.offset}
+
+		_, err := light.fadeOut(fadeOut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		brightness := fadeOut.calculateLightStateInInterval(light.FadeOutStepEnd).Brightness
+		if device.SetBrightness != test.expected(brightness) {
+			t.Errorf("Fade-out step with offset %v was sent with %d%% brightness; want %d%%", test.offset, device.SetBrightness, test.expected(brightness))
+		}
+	}
+}
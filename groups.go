@@ -0,0 +1,291 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const kelvinGroupPrefix = "Kelvin "
+const maximumGroupNameLength = 32 // in bytes
+
+// HueGroup represents a room, zone or light group on the bridge.
+type HueGroup struct {
+	ID     string   `json:"-"`
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Lights []string `json:"lights"`
+}
+
+var controllableGroupTypes = []string{"Room", "Zone", "LightGroup"}
+
+// refreshGroups reads all rooms, zones and light groups from the bridge.
+func (bridge *HueBridge) refreshGroups() error {
+	groups, err := bridge.readGroups()
+	if err != nil {
+		return err
+	}
+	bridge.groups = groups
+	log.Debugf("⌘ Found %d groups on bridge %s", len(bridge.groups), bridge.ID)
+	return nil
+}
+
+func (bridge *HueBridge) updateGroups() {
+	err := bridge.refreshGroups()
+	if err != nil {
+		log.Warningf("⌘ Could not read groups from bridge %s: %v", bridge.ID, err)
+		return
+	}
+	bridge.removeUnusedGroups()
+}
+
+// removeUnusedGroups deletes all groups created by Kelvin for schedules
+// which don't exist anymore.
+func (bridge *HueBridge) removeUnusedGroups() {
+	used := make(map[string]bool)
+	for _, schedule := range configuration.Schedules {
+		used[managedGroupName(schedule.Name)] = true
+	}
+
+	groups := []HueGroup{}
+	for _, group := range bridge.groups {
+		if group.Type == "LightGroup" && strings.HasPrefix(group.Name, kelvinGroupPrefix) && !used[group.Name] {
+			log.Printf("⌘ Removing unused group \"%s\"", group.Name)
+			err := bridge.request("DELETE", "groups/"+group.ID, nil, nil)
+			if err == nil {
+				continue
+			}
+			log.Warningf("⌘ Could not remove group \"%s\": %v", group.Name, err)
+		}
+		groups = append(groups, group)
+	}
+	bridge.groups = groups
+}
+
+// managedGroupName returns the name of the group managed by Kelvin for the
+// given schedule. Long names are cut to the maximum length the bridge
+// accepts without splitting a character.
+func managedGroupName(schedule string) string {
+	name := kelvinGroupPrefix + schedule
+	for len(name) > maximumGroupNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// readGroups returns all rooms, zones and light groups of the bridge
+// ordered by their ID.
+func (bridge *HueBridge) readGroups() ([]HueGroup, error) {
+	var response map[string]HueGroup
+	err := bridge.request("GET", "groups", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []HueGroup{}
+	for id, group := range response {
+		if !containsString(controllableGroupTypes, group.Type) {
+			continue
+		}
+		group.ID = id
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].ID < groups[j].ID })
+	return groups, nil
+}
+
+// groupForLights returns the ID of a group containing exactly the given
+// lights. If the bridge has no such room or zone, a group managed by Kelvin
+// will be created or updated.
+func (bridge *HueBridge) groupForLights(lightIDs []int, name string) (string, error) {
+	lights := toStringArray(lightIDs)
+	sort.Strings(lights)
+
+	groupName := managedGroupName(name)
+
+	var managedGroup *HueGroup
+	for index, group := range bridge.groups {
+		if equalsStringSet(group.Lights, lights) {
+			return group.ID, nil
+		}
+		if group.Type == "LightGroup" && group.Name == groupName {
+			managedGroup = &bridge.groups[index]
+		}
+	}
+
+	if managedGroup != nil {
+		log.Printf("⌘ Updating lights of group \"%s\" to %v", managedGroup.Name, lights)
+		err := bridge.request("PUT", "groups/"+managedGroup.ID, map[string][]string{"lights": lights}, nil)
+		if err != nil {
+			return "", err
+		}
+		managedGroup.Lights = lights
+		return managedGroup.ID, nil
+	}
+
+	log.Printf("⌘ Creating group \"%s\" for lights %v", groupName, lights)
+	var response []struct {
+		Success struct {
+			ID string `json:"id"`
+		} `json:"success"`
+	}
+	group := HueGroup{Name: groupName, Type: "LightGroup", Lights: lights}
+	err := bridge.request("POST", "groups", group, &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response) == 0 || response[0].Success.ID == "" {
+		return "", fmt.Errorf("Bridge did not return an ID for group \"%s\"", groupName)
+	}
+	group.ID = response[0].Success.ID
+	bridge.groups = append(bridge.groups, group)
+	return group.ID, nil
+}
+
+// updateLightsViaGroups updates all lights of a schedule with a single group
+// action if they are automatic and share the same target light state.
+// It returns the lights that have been updated this way.
+func (bridge *HueBridge) updateLightsViaGroups(lights []*Light, transitionTime time.Duration) map[int]bool {
+	updated := make(map[int]bool)
+	if *flagDisableGroups || bridge.groups == nil {
+		return updated
+	}
+
+	schedules := make(map[string][]*Light)
+	for _, light := range lights {
+		if light.Scheduled {
+			schedules[light.Schedule.name] = append(schedules[light.Schedule.name], light)
+		}
+	}
+
+	for name, scheduleLights := range schedules {
+		colorTemperature, brightness, ok := sharedGroupLightState(scheduleLights)
+		if !ok {
+			continue
+		}
+
+		var lightIDs []int
+		for _, light := range scheduleLights {
+			lightIDs = append(lightIDs, light.ID)
+		}
+		groupID, err := bridge.groupForLights(lightIDs, name)
+		if err != nil {
+			log.Warningf("⌘ Could not find group for schedule %s: %v", name, err)
+			continue
+		}
+
+		err = bridge.setGroupLightState(groupID, scheduleLights, colorTemperature, brightness, transitionTime)
+		if err != nil {
+			log.Warningf("⌘ Setting light state of group %s failed: %v. Falling back to single lights...", groupID, err)
+			continue
+		}
+
+		log.Printf("⌘ Schedule %s - Updated %d lights to %vK at %v%% brightness via group %s", name, len(scheduleLights), colorTemperature, brightness, groupID)
+		for _, light := range scheduleLights {
+			updated[light.ID] = true
+		}
+	}
+	return updated
+}
+
+// sharedGroupLightState returns the color temperature and brightness all
+// lights should be updated to. It fails if any of the lights doesn't need an
+// update or the lights would end up in different states.
+func sharedGroupLightState(lights []*Light) (int, int, bool) {
+	if len(lights) < 2 {
+		return 0, 0, false
+	}
+
+	target := lights[0].TargetLightState
+	colorTemperature := 0
+	for index, light := range lights {
+		hueLight, ok := light.Device.(*HueLight)
+		if !ok {
+			return 0, 0, false
+		}
+		if index == 0 {
+			colorTemperature = hueLight.adjustColorTemperature(target.ColorTemperature)
+		}
+		if !light.Reachable || !light.On || !light.Tracking || !light.AutomaticColorTemperature || !light.AutomaticBrightness || light.BrightnessOffset != nil || light.Initializing || light.WakingUp || light.FadingOut || time.Now().Before(light.TransitionEnd) {
+			return 0, 0, false
+		}
+		if !light.TargetLightState.equals(target) || hueLight.adjustColorTemperature(target.ColorTemperature) != colorTemperature {
+			return 0, 0, false
+		}
+		if hueLight.hasChanged() || hueLight.hasState(target.ColorTemperature, target.Brightness) {
+			return 0, 0, false
+		}
+	}
+	return colorTemperature, target.Brightness, true
+}
+
+func (bridge *HueBridge) setGroupLightState(groupID string, lights []*Light, colorTemperature int, brightness int, transitionTime time.Duration) error {
+	action := make(map[string]interface{})
+	action["transitiontime"] = int(transitionTime / time.Millisecond / 100)
+
+	for _, light := range lights {
+		hueLight := light.Device.(*HueLight)
+		if colorTemperature != -1 {
+			// Set supported colormodes. If both are, the brigde will prefer xy colors
+			if hueLight.SupportsXYColor {
+				action["xy"] = colorTemperatureToXYColor(colorTemperature)
+			}
+			if hueLight.SupportsColorTemperature {
+				action["ct"] = mapColorTemperature(colorTemperature)
+			}
+		}
+		if brightness == 0 {
+			action["on"] = false
+		} else if brightness != -1 && hueLight.Dimmable {
+			action["bri"] = mapBrightness(brightness)
+		}
+	}
+
+	err := bridge.request("PUT", "groups/"+groupID+"/action", action, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, light := range lights {
+		light.Device.(*HueLight).setTargetLightState(colorTemperature, brightness)
+	}
+	return nil
+}
+
+func equalsStringSet(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, element := range a {
+		if !containsString(b, element) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,301 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hue "github.com/stefanwichmann/go.hue"
+)
+
+// fakeHueGroups emulates the groups resource of the Hue API v1 and records
+// all modifying requests.
+type fakeHueGroups struct {
+	server      *httptest.Server
+	mutex       sync.Mutex
+	groups      map[string]HueGroup
+	nextID      int
+	requests    []string
+	actions     map[string]map[string]interface{}
+	failActions bool
+}
+
+func newFakeHueGroups(t *testing.T, groups map[string]HueGroup) (*fakeHueGroups, *HueBridge) {
+	fake := &fakeHueGroups{groups: groups, nextID: 10, actions: make(map[string]map[string]interface{})}
+	fake.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		resource := strings.TrimPrefix(r.URL.Path, "/api/secret/")
+		if r.Method != "GET" {
+			fake.requests = append(fake.requests, r.Method+" "+resource)
+		}
+
+		path := strings.Split(resource, "/")
+		switch {
+		case r.Method == "GET" && resource == "groups":
+			json.NewEncoder(w).Encode(fake.groups)
+		case r.Method == "POST" && resource == "groups":
+			var group HueGroup
+			json.Unmarshal(body, &group)
+			id := fmt.Sprintf("%d", fake.nextID)
+			fake.nextID++
+			fake.groups[id] = group
+			fmt.Fprintf(w, `[{"success": {"id": "%s"}}]`, id)
+		case r.Method == "PUT" && len(path) == 2:
+			var group HueGroup
+			json.Unmarshal(body, &group)
+			existing := fake.groups[path[1]]
+			existing.Lights = group.Lights
+			fake.groups[path[1]] = existing
+			fmt.Fprintf(w, `[{"success": {"/groups/%s/lights": %q}}]`, path[1], group.Lights)
+		case r.Method == "PUT" && len(path) == 3 && path[2] == "action":
+			if fake.failActions {
+				fmt.Fprintf(w, `[{"error": {"type": 3, "address": "/groups/%s", "description": "resource, /groups/%s, not available"}}]`, path[1], path[1])
+				return
+			}
+			var action map[string]interface{}
+			json.Unmarshal(body, &action)
+			fake.actions[path[1]] = action
+			fmt.Fprint(w, `[{"success": {}}]`)
+		case r.Method == "DELETE" && len(path) == 2:
+			delete(fake.groups, path[1])
+			fmt.Fprintf(w, `[{"success": "/groups/%s deleted"}]`, path[1])
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	bridge := &HueBridge{ID: "bridge", BridgeIP: strings.TrimPrefix(fake.server.URL, "http://"), Username: "secret", client: &http.Client{Timeout: 5 * time.Second}}
+	err := bridge.refreshGroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, bridge
+}
+
+func (fake *fakeHueGroups) takeRequests() []string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	requests := fake.requests
+	fake.requests = nil
+	return requests
+}
+
+// newFakeGroupLight returns an automatic light of the given schedule which
+// is shown at 2700K and 100% brightness and should be updated to 4000K at
+// 80% brightness.
+func newFakeGroupLight(id int, schedule string, lightType string) *Light {
+	var attr hue.LightAttributes
+	attr.Name = fmt.Sprintf("Light %d", id)
+	attr.Type = lightType
+	attr.State.On = true
+	attr.State.Reachable = true
+	attr.State.ColorMode = "ct"
+
+	hueLight := &HueLight{}
+	hueLight.initialize(attr)
+	hueLight.setTargetLightState(2700, 100)
+	hueLight.CurrentColorTemperature = hueLight.TargetColorTemperature
+	hueLight.CurrentColor = hueLight.TargetColor
+	hueLight.CurrentBrightness = hueLight.TargetBrightness
+
+	return &Light{
+		ID:                        id,
+		Name:                      attr.Name,
+		Device:                    hueLight,
+		TargetLightState:          LightState{4000, 80},
+		Scheduled:                 true,
+		Reachable:                 true,
+		On:                        true,
+		Tracking:                  true,
+		AutomaticColorTemperature: true,
+		AutomaticBrightness:       true,
+		Schedule:                  Schedule{name: schedule},
+	}
+}
+
+func TestSharedGroupLightState(t *testing.T) {
+	var tests = []struct {
+		description string
+		modify      func(lights []*Light) []*Light
+		ok          bool
+	}{
+		{"all lights share the same target", func(lights []*Light) []*Light { return lights }, true},
+		{"single light", func(lights []*Light) []*Light { return lights[:1] }, false},
+		{"different targets", func(lights []*Light) []*Light {
+			lights[1].TargetLightState.Brightness = 70
+			return lights
+		}, false},
+		{"target adjusted differently", func(lights []*Light) []*Light {
+			lights[0].TargetLightState.ColorTemperature = 1800
+			lights[1].TargetLightState.ColorTemperature = 1800
+			lights[1].Device.(*HueLight).MinimumColorTemperature = 2200
+			return lights
+		}, false},
+		{"light not reachable", func(lights []*Light) []*Light {
+			lights[1].Reachable = false
+			return lights
+		}, false},
+		{"brightness offset", func(lights []*Light) []*Light {
+			lights[1].BrightnessOffset = &BrightnessOffset{Mode: brightnessOffsetAbsolute, Value: 10}
+			return lights
+		}, false},
+		{"manual brightness", func(lights []*Light) []*Light {
+			lights[1].AutomaticBrightness = false
+			return lights
+		}, false},
+		{"changed by the user", func(lights []*Light) []*Light {
+			lights[1].Device.(*HueLight).CurrentBrightness = 20
+			return lights
+		}, false},
+		{"already in target state", func(lights []*Light) []*Light {
+			hueLight := lights[1].Device.(*HueLight)
+			hueLight.setTargetLightState(4000, 80)
+			hueLight.CurrentColorTemperature = hueLight.TargetColorTemperature
+			hueLight.CurrentBrightness = hueLight.TargetBrightness
+			return lights
+		}, false},
+		{"in transition", func(lights []*Light) []*Light {
+			lights[1].TransitionEnd = time.Now().Add(time.Minute)
+			return lights
+		}, false},
+		{"other device", func(lights []*Light) []*Light {
+			lights[1].Device = &WLEDLight{}
+			return lights
+		}, false},
+	}
+
+	for _, tc := range tests {
+		lights := tc.modify([]*Light{newFakeGroupLight(1, "Living", "Extended color light"), newFakeGroupLight(2, "Living", "Color temperature light")})
+		colorTemperature, brightness, ok := sharedGroupLightState(lights)
+		if ok != tc.ok {
+			t.Errorf("%s: got %t; want %t", tc.description, ok, tc.ok)
+		}
+		if ok && (colorTemperature != 4000 || brightness != 80) {
+			t.Errorf("%s: got %dK at %d%%; want 4000K at 80%%", tc.description, colorTemperature, brightness)
+		}
+	}
+}
+
+func TestGroupForLights(t *testing.T) {
+	fake, bridge := newFakeHueGroups(t, map[string]HueGroup{
+		"1": {Name: "Living room", Type: "Room", Lights: []string{"1", "2"}},
+		"2": {Name: "TV", Type: "Entertainment", Lights: []string{"5", "6"}},
+		"3": {Name: "Kelvin Office", Type: "LightGroup", Lights: []string{"3"}},
+		"4": {Name: "Kelvin Old schedule", Type: "LightGroup", Lights: []string{"7"}},
+	})
+	defer fake.server.Close()
+
+	var tests = []struct {
+		lights   []int
+		schedule string
+		group    string
+		requests []string
+	}{
+		{[]int{2, 1}, "Living", "1", nil},                      // existing room
+		{[]int{3, 4}, "Office", "3", []string{"PUT groups/3"}}, // managed group is updated
+		{[]int{3, 4}, "Office", "3", nil},                      // and reused
+		{[]int{5, 6}, "Cinema", "10", []string{"POST groups"}}, // entertainment areas are ignored
+		{[]int{6, 5}, "Cinema", "10", nil},                     // created group is reused
+	}
+
+	for _, tc := range tests {
+		group, err := bridge.groupForLights(tc.lights, tc.schedule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests := fake.takeRequests()
+		if group != tc.group || strings.Join(requests, ", ") != strings.Join(tc.requests, ", ") {
+			t.Errorf("Lights %v of schedule %s got group %s with requests %v; want %s with %v", tc.lights, tc.schedule, group, requests, tc.group, tc.requests)
+		}
+	}
+	if created := fake.groups["10"]; created.Name != "Kelvin Cinema" || created.Type != "LightGroup" || strings.Join(created.Lights, ",") != "5,6" {
+		t.Errorf("Created group %+v; want Kelvin Cinema with lights 5,6", created)
+	}
+
+	previous := configuration
+	defer func() { configuration = previous }()
+	configuration = &Configuration{Schedules: []LightSchedule{{Name: "Office"}, {Name: "Cinema"}}}
+	bridge.removeUnusedGroups()
+	if requests := fake.takeRequests(); len(requests) != 1 || requests[0] != "DELETE groups/4" {
+		t.Errorf("Removing unused groups sent %v; want [DELETE groups/4]", requests)
+	}
+	if len(bridge.groups) != 3 {
+		t.Errorf("Bridge has %d groups after cleanup; want 3", len(bridge.groups))
+	}
+}
+
+func TestUpdateLightsViaGroups(t *testing.T) {
+	fake, bridge := newFakeHueGroups(t, map[string]HueGroup{
+		"1": {Name: "Living room", Type: "Room", Lights: []string{"1", "2"}},
+	})
+	defer fake.server.Close()
+
+	lights := []*Light{
+		newFakeGroupLight(1, "Living", "Extended color light"),
+		newFakeGroupLight(2, "Living", "Color temperature light"),
+		newFakeGroupLight(3, "Bedroom", "Extended color light"),
+		newFakeGroupLight(4, "Bedroom", "Color temperature light"),
+		newFakeGroupLight(5, "Office", "Extended color light"),
+	}
+	lights[3].TargetLightState.Brightness = 50
+
+	updated := bridge.updateLightsViaGroups(lights, 4*time.Second)
+	if len(updated) != 2 || !updated[1] || !updated[2] {
+		t.Fatalf("Updated lights %v via groups; want 1 and 2", updated)
+	}
+	if requests := fake.takeRequests(); len(requests) != 1 || requests[0] != "PUT groups/1/action" {
+		t.Errorf("Sent requests %v; want [PUT groups/1/action]", requests)
+	}
+	action := fake.actions["1"]
+	if action["ct"] != float64(250) || action["bri"] != float64(mapBrightness(80)) || action["transitiontime"] != float64(40) || action["xy"] == nil {
+		t.Errorf("Sent group action %v; want ct 250, bri %d, xy and transitiontime 40", action, mapBrightness(80))
+	}
+	for _, light := range lights[:2] {
+		hueLight := light.Device.(*HueLight)
+		if hueLight.TargetColorTemperature != 250 || hueLight.TargetBrightness != mapBrightness(80) {
+			t.Errorf("Light %d has target %d mirek at %d; want 250 at %d", light.ID, hueLight.TargetColorTemperature, hueLight.TargetBrightness, mapBrightness(80))
+		}
+	}
+
+	// Lights are updated one by one if the group action fails
+	lights = []*Light{newFakeGroupLight(1, "Living", "Extended color light"), newFakeGroupLight(2, "Living", "Color temperature light")}
+	fake.mutex.Lock()
+	fake.failActions = true
+	fake.mutex.Unlock()
+	if updated := bridge.updateLightsViaGroups(lights, 0); len(updated) != 0 {
+		t.Errorf("Updated lights %v although the group action failed", updated)
+	}
+}
@@ -5,6 +5,14 @@ $(document).ready(function(){
     console.log("Uploading configuration "+conf);
     uploadConfiguration(conf);
   });
+  $("#addBridge").click(function(){
+    console.log("Add bridge button clicked");
+    addBridge($("#bridges"));
+  });
+  $('#bridges').on('click', '.deleteBridgeButton', function(){
+    console.log("Delete bridge button clicked");
+    $(this).parents("div.bridge").remove();
+  });
   $('#getlocation').click(function(){
     console.log("Get location button clicked");
     getGeolocation($(this).parents(".location"));
@@ -40,21 +48,54 @@ function uploadConfiguration(configuration) {
   });
 }
 
-function readConfiguration(target){
+function addBridge(target) {
+  var bridge = $('<div class="row well bridge">');
+  bridge.append('<h1>Bridge</h1>');
+  var form = $('<form class="form-horizontal">');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">Type</label><div class="col-md-10"><select class="type form-control" autocomplete="off"><option value="hue" selected>Philips Hue</option><option value="zigbee2mqtt">Zigbee2MQTT</option><option value="lifx">LIFX</option><option value="wled">WLED</option><option value="deconz">deCONZ</option></select></div></div>');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">ID</label><div class="col-md-10"><input type="text" class="id form-control" placeholder="Detected automatically" autocomplete="off"></div></div>');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">IP</label><div class="col-md-10"><input type="text" class="ip form-control" placeholder="Discovered automatically" autocomplete="off"></div></div>');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">Username</label><div class="col-md-10"><input type="text" class="username form-control" placeholder="Registered automatically" autocomplete="off"></div></div>');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">Password</label><div class="col-md-10"><input type="password" class="password form-control" placeholder="MQTT only" autocomplete="off"></div></div>');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">Topic</label><div class="col-md-10"><input type="text" class="topic form-control" placeholder="zigbee2mqtt" autocomplete="off"></div></div>');
+  form.append('<div class="form-group"><label class="col-md-2 control-label">API</label><div class="col-md-10"><select class="api form-control" autocomplete="off"><option value="v1" selected>v1 (REST)</option><option value="v2">v2 (CLIP v2)</option></select></div></div>');
+  bridge.append(form);
+  bridge.append('<div class="text-right"><button type="button" class="deleteBridgeButton btn btn-danger">Remove bridge</button></div>');
+  target.append(bridge);
+}
+
+function readBridge(target) {
   var bridge = Object();
-  bridge.IP = $(target).find("#ip").val().trim();
-  bridge.Username = $(target).find("#username").val().trim();
+  bridge.Type = $(target).find(".type").val();
+  bridge.ID = $(target).find(".id").val().trim();
+  bridge.IP = $(target).find(".ip").val().trim();
+  bridge.Username = $(target).find(".username").val().trim();
+  bridge.Password = $(target).find(".password").val();
+  bridge.Topic = $(target).find(".topic").val().trim();
+  bridge.API = $(target).find(".api").val();
+  return bridge;
+}
+
+function readConfiguration(target){
+  var bridges = new Array();
+  $(target).find(".bridge").each(function(index) {
+    bridges.push(readBridge($(this)));
+  });
 
   var location = Object();
   location.Latitude = parseFloat($(target).find("#latitude").val().trim());
   location.Longitude = parseFloat($(target).find("#longitude").val().trim());
+  location.twilight = $(target).find("#twilight").val();
+  location.twilightAngle = parseFloat($(target).find("#twilightangle").val().trim());
+  location.fallbackSunrise = $(target).find("#fallbacksunrise").val().trim();
+  location.fallbackSunset = $(target).find("#fallbacksunset").val().trim();
 
   var webinterface = Object();
   webinterface.enabled = $(target).find("#webinterfaceenabled").is(":checked");
   webinterface.port = parseInt($(target).find("#port").val().trim());
 
   var configuration = Object();
-  configuration.Bridge = bridge
+  configuration.Bridges = bridges
   configuration.Location = location
   configuration.WebInterface = webinterface
 
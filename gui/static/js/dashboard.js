@@ -2,6 +2,9 @@ $(document).ready(function(){
   $('#dashboard').on('click', '.enableKelvinButton', function(){
     activateKelvin($(this).parents(".light"));
   });
+  $('#dashboard').on('click', '.cancelWakeUpButton', function(){
+    cancelWakeUp($(this).parents(".light"));
+  });
   $('#dashboard').on('click', '#restartKelvinButton', function(){
     console.log("Restart kelvin button clicked");
     restartKelvin();
@@ -9,15 +12,25 @@ $(document).ready(function(){
 });
 
 function activateKelvin(entry) {
-  console.log("Activating kelvin for light " + $(entry).attr("id"));
+  console.log("Activating kelvin for light " + $(entry).data("light") + " on bridge " + $(entry).data("bridge"));
   $.ajax({
-    url: "/lights/"+ $(entry).attr("id") +"/automatic",
+    url: "/bridges/" + $(entry).data("bridge") + "/lights/" + $(entry).data("light") + "/automatic",
     type: 'PUT'
   });
   $(entry).find(".enableKelvinButton").prop("disabled",true);
   window.setTimeout(function(){location.reload(true);}, 5000);
 }
 
+function cancelWakeUp(entry) {
+  console.log("Cancelling wake-up for light " + $(entry).data("light") + " on bridge " + $(entry).data("bridge"));
+  $.ajax({
+    url: "/bridges/" + $(entry).data("bridge") + "/lights/" + $(entry).data("light") + "/wakeup/cancel",
+    type: 'PUT'
+  });
+  $(entry).find(".cancelWakeUpButton").prop("disabled",true);
+  window.setTimeout(function(){location.reload(true);}, 5000);
+}
+
 function restartKelvin() {
   $.ajax({
     url: "/restart",
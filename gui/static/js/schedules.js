@@ -24,6 +24,22 @@ $(document).ready(function(){
     console.log("Test entry button clicked");
     activateEntry($(this).parents("tr.entry"));
   });
+  $('#schedules').on('click', '.addPointButton', function(){
+    console.log("Add point button clicked");
+    addElevationPoint($(this).parents("div.subschedule").find(".table"));
+  });
+  $('#schedules').on('click', '.deletePointButton', function(){
+    console.log("Delete point button clicked");
+    $(this).parents("tr.point").remove();
+  });
+  $('#schedules').on('click', '.addVariantButton', function(){
+    console.log("Add variant button clicked");
+    addVariant($(this).parents("div.variants"));
+  });
+  $('#schedules').on('click', '.deleteVariantButton', function(){
+    console.log("Delete variant button clicked");
+    $(this).parents("div.variant").remove();
+  });
   $('#schedules').on('click', '.deleteScheduleButton', function(){
     console.log("Delete schedule button clicked");
     $(this).parents("div.schedule").remove();
@@ -46,18 +62,92 @@ function uploadSchedules(schedules) {
 
 function readSchedule(target){
   var schedule = Object();
-  schedule.beforeSunrise = readScheduleEntry($(target).find(".beforeSunrise"));
-  schedule.afterSunset = readScheduleEntry($(target).find(".afterSunset"));
-  schedule.defaultColorTemperature = parseInt($(target).find(".default .entry .colorTemperature").val().trim());
-  schedule.defaultBrightness = parseInt($(target).find(".default .entry .brightness").val().trim());
+  schedule.beforeSunrise = readScheduleEntry(baseElements(target, ".beforeSunrise"));
+  schedule.afterSunset = readScheduleEntry(baseElements(target, ".afterSunset"));
+  schedule.defaultColorTemperature = parseInt(baseElements(target, ".default .entry .colorTemperature").val().trim());
+  schedule.defaultBrightness = parseInt(baseElements(target, ".default .entry .brightness").val().trim());
   schedule.name = $(target).find(".name").val().trim();
+  schedule.bridge = $(target).find(".bridge").val().trim();
   console.log($(target).find(".lights").val())
   schedule.associatedDeviceIDs = parseIDs($(target).find(".lights").val().trim());
+  schedule.rooms = parseNames($(target).find(".rooms").val());
+  schedule.zones = parseNames($(target).find(".zones").val());
+  schedule.lights = parseNames($(target).find(".lightNames").val());
   schedule.enableWhenLightsAppear = $(target).find(".appearBehavior").is(":checked");
+  schedule.colorTemperatureEasing = $(target).find(".colorTemperatureEasing").val();
+  schedule.brightnessEasing = $(target).find(".brightnessEasing").val();
+  schedule.earliestSunrise = $(target).find(".earliestSunrise").val().trim();
+  schedule.latestSunrise = $(target).find(".latestSunrise").val().trim();
+  schedule.earliestSunset = $(target).find(".earliestSunset").val().trim();
+  schedule.latestSunset = $(target).find(".latestSunset").val().trim();
+  schedule.elevationCurve = readElevationCurve($(target).find(".elevationCurve"));
+  schedule.resumePolicy = $(target).find(".resumePolicy").val();
+  schedule.resumeTimeout = parseInt($(target).find(".resumeTimeout").val().trim()) || 0;
+  schedule.brightnessOffset = $(target).find(".brightnessOffset").val();
+  schedule.wakeUp = readWakeUp(target);
+  schedule.variants = new Array();
+  $(target).find(".variant").each(function(index) {
+    schedule.variants.push(readVariant($(this)));
+  });
   console.log(schedule);
   return schedule;
 }
 
+// readWakeUp returns the wake-up alarm of a schedule or null if no time is set.
+function readWakeUp(target) {
+  var wakeUp = Object();
+  wakeUp.times = Object();
+  var found = false;
+  $(target).find(".wakeUpTime").each(function(index) {
+    var time = $(this).val().trim();
+    if (time != "") {
+      wakeUp.times[$(this).data("day")] = time;
+      found = true;
+    }
+  });
+  if (!found) {
+    return null;
+  }
+  wakeUp.duration = parseInt($(target).find(".wakeUpDuration").val().trim()) || 0;
+  wakeUp.colorTemperature = parseInt($(target).find(".wakeUpColorTemperature").val().trim()) || 0;
+  wakeUp.holidays = parseNames($(target).find(".wakeUpHolidays").val());
+  return wakeUp;
+}
+
+// baseElements returns all matching elements of a schedule which are not part of a variant.
+function baseElements(target, selector) {
+  return $(target).find(selector).filter(function() {
+    return $(this).parents("div.variant").length == 0;
+  });
+}
+
+function readVariant(target) {
+  var variant = Object();
+  variant.name = $(target).find(".variantName").val().trim();
+  variant.days = parseNames($(target).find(".days").val());
+  variant.from = $(target).find(".from").val().trim();
+  variant.to = $(target).find(".to").val().trim();
+  var colorTemperature = parseInt($(target).find(".default .entry .colorTemperature").val().trim());
+  if (!isNaN(colorTemperature)) {
+    variant.defaultColorTemperature = colorTemperature;
+  }
+  var brightness = parseInt($(target).find(".default .entry .brightness").val().trim());
+  if (!isNaN(brightness)) {
+    variant.defaultBrightness = brightness;
+  }
+  // Variants without entries keep the entries of the schedule
+  var beforeSunrise = readScheduleEntry($(target).find(".beforeSunrise"));
+  if (beforeSunrise.length > 0) {
+    variant.beforeSunrise = beforeSunrise;
+  }
+  var afterSunset = readScheduleEntry($(target).find(".afterSunset"));
+  if (afterSunset.length > 0) {
+    variant.afterSunset = afterSunset;
+  }
+  console.log(variant);
+  return variant;
+}
+
 function readScheduleEntry(target) {
   var list = new Array();
   $(target).find(".entry").each(function(index) {
@@ -65,15 +155,46 @@ function readScheduleEntry(target) {
     schedule.time = $(this).find(".time").val().trim();
     schedule.colorTemperature = parseInt($(this).find(".colorTemperature").val().trim());
     schedule.brightness = parseInt($(this).find(".brightness").val().trim());
+    // Easings and fade-outs of single entries can only be configured in the configuration file
+    if ($(this).data("color-temperature-easing")) {
+      schedule.colorTemperatureEasing = $(this).data("color-temperature-easing");
+    }
+    if ($(this).data("brightness-easing")) {
+      schedule.brightnessEasing = $(this).data("brightness-easing");
+    }
+    if ($(this).data("fade-out")) {
+      schedule.fadeOut = parseInt($(this).data("fade-out"));
+    }
     console.log(schedule);
     list.push(schedule);
   });
   return list
 }
 
+function readElevationCurve(target) {
+  var list = new Array();
+  $(target).find(".point").each(function(index) {
+    var point = Object();
+    point.elevation = parseFloat($(this).find(".elevation").val().trim());
+    point.colorTemperature = parseInt($(this).find(".colorTemperature").val().trim());
+    point.brightness = parseInt($(this).find(".brightness").val().trim());
+    list.push(point);
+  });
+  return list
+}
+
+function addElevationPoint(target) {
+  var point = $('<tr class="point">');
+  point.append('<td><input type="number" name="elevation" class="elevation form-control" value="0" min="-90" max="90" step="any" autocomplete="off"></td>');
+  point.append('<td><input type="number" name="colorTemperature" class="colorTemperature form-control" value="2750" min="-1" max="6500" autocomplete="off"></td>');
+  point.append('<td><input type="range" name="brightness" class="brightness form-control" value="100" min="0" max="100" autocomplete="off"></td>');
+  point.append('<td><button type="button" class="deletePointButton btn btn-primary">Delete</button></td>');
+  $(target).append(point);
+}
+
 function addScheduleEntry(target) {
   var entry = $('<tr class="entry">');
-  entry.append('<td><input type="time" name="time" class="time form-control" value="10:00" autocomplete="off"></td>');
+  entry.append('<td><input type="text" name="time" class="time form-control" value="10:00" placeholder="22:00 or sunset+30m" autocomplete="off"></td>');
   entry.append('<td><input type="number" name="colorTemperature" class="colorTemperature form-control" value="2750" min="0" max="6500" autocomplete="off"></td>');
   entry.append('<td><input type="range" name="brightness" class="brightness form-control" value="100" min="0" max="100" autocomplete="off"></td>');
   entry.append('<td><div class="btn-group"><button type="button" class="deleteEntryButton btn btn-primary">Delete</button><button type="button" class="testEntryButton btn btn-primary">Test</button></div></td>');
@@ -85,7 +206,25 @@ function addSchedule(target) {
   var collumn = $('<div class="col-md-12">')
   var basic = $('<form class="form-horizontal">');
   basic.append('<div class="form-group"><label>Name:</label><input type="text" class="name form-control" placeholder="Livingroom" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Bridge:</label><input type="text" class="bridge form-control" placeholder="First bridge" autocomplete="off"></div>');
   basic.append('<div class="form-group"><label>Lights:</label><input type="text" class="lights form-control" placeholder="1,2,3" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Rooms:</label><input type="text" class="rooms form-control" placeholder="Living room" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Zones:</label><input type="text" class="zones form-control" placeholder="Downstairs" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Light names:</label><input type="text" class="lightNames form-control" placeholder="Hallway*" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Sunrise between:</label><input type="text" class="earliestSunrise form-control" placeholder="Earliest (e.g. 06:00)" autocomplete="off"><input type="text" class="latestSunrise form-control" placeholder="Latest (e.g. 08:00)" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Sunset between:</label><input type="text" class="earliestSunset form-control" placeholder="Earliest (e.g. 17:00)" autocomplete="off"><input type="text" class="latestSunset form-control" placeholder="Latest (e.g. 20:00)" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Color temperature easing:</label><select class="colorTemperatureEasing form-control"><option value="">Linear (default)</option><option value="mired">Linear in mired</option><option value="perceptual">Perceptual</option><option value="ease-in">Ease in</option><option value="ease-out">Ease out</option><option value="ease-in-out">Ease in and out</option><option value="step">Step</option></select></div>');
+  basic.append('<div class="form-group"><label>Brightness easing:</label><select class="brightnessEasing form-control"><option value="">Linear (default)</option><option value="perceptual">Perceptual</option><option value="ease-in">Ease in</option><option value="ease-out">Ease out</option><option value="ease-in-out">Ease in and out</option><option value="step">Step</option></select></div>');
+  basic.append('<div class="form-group"><label>Resume after manual changes:</label><select class="resumePolicy form-control"><option value="">Never (default)</option><option value="timeout">After timeout</option><option value="next entry">At the next entry</option><option value="sunrise or sunset">At the next sunrise or sunset</option></select><input type="number" class="resumeTimeout form-control" placeholder="Timeout in minutes (default 60)" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Manual brightness changes:</label><select class="brightnessOffset form-control"><option value="">Keep brightness (default)</option><option value="absolute">Follow schedule with offset in points</option><option value="relative">Follow schedule with factor</option></select></div>');
+  var wakeUpTimes = $('<div class="form-group"><label>Wake-up times:</label></div>');
+  ["Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"].forEach(function(day) {
+    wakeUpTimes.append('<input type="text" class="wakeUpTime form-control" data-day="' + day.substring(0, 3).toLowerCase() + '" placeholder="' + day + ' (e.g. 06:30)" autocomplete="off">');
+  });
+  basic.append(wakeUpTimes);
+  basic.append('<div class="form-group"><label>Wake-up duration:</label><input type="number" class="wakeUpDuration form-control" placeholder="Minutes (default 30)" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Wake-up color temperature:</label><input type="number" class="wakeUpColorTemperature form-control" placeholder="Default color temperature" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Holidays without wake-up:</label><input type="text" class="wakeUpHolidays form-control" placeholder="2024-05-01, 12-25" autocomplete="off"></div>');
   basic.append('<div class="form-group"><label class="form-check-label">Enable when lights appear?</label><input type="checkbox" class="appearBehavior form-check-input" autocomplete="off"></div>');
   collumn.append(basic)
 
@@ -117,6 +256,17 @@ function addSchedule(target) {
   subschedule.append(tableDefault);
   collumn.append(subschedule)
 
+  <!-- Elevation curve -->
+  var subschedule = $('<div class="subschedule">');
+  subschedule.append('<h1>Sun elevation <small>(optional, replaces daylight values)</small></h1>');
+  var tableElevation = $('<table class="elevationCurve table">');
+  var tbody = $('<tbody>')
+  tbody.append('<tr><th scope="col">Elevation</th><th scope="col">Color Temperature</th><th scope="col">Brightness</th><th scope="col">Control</th></tr>');
+  tableElevation.append(tbody);
+  subschedule.append(tableElevation);
+  subschedule.append('<div class="text-center"><button type="button" class="addPointButton btn btn-primary">Add point</button></div>');
+  collumn.append(subschedule)
+
   <!-- Schedule after sunset -->
   var subschedule = $('<div class="subschedule">');
   subschedule.append('<h1>Evening <small>(sunset - 23:59)</small></h1>');
@@ -129,20 +279,64 @@ function addSchedule(target) {
   subschedule.append('<div class="text-center"><button type="button" class="addEntryButton btn btn-primary">Add entry</button></div>');
   collumn.append(subschedule);
 
+  <!-- Variants -->
+  var variants = $('<div class="variants">');
+  variants.append('<h1>Variants <small>(replace light states on certain days)</small></h1>');
+  variants.append('<div class="text-center"><button type="button" class="addVariantButton btn btn-primary">Add variant</button></div>');
+  collumn.append(variants);
+
   collumn.append('<div class="text-right"><button type="button" class="deleteScheduleButton btn btn-danger">Delete schedule</button></div>');
   schedule.append(collumn)
   target.append(schedule);
 }
 
+function addVariant(target) {
+  var variant = $('<div class="variant well">');
+  var basic = $('<form class="form-horizontal">');
+  basic.append('<div class="form-group"><label>Name:</label><input type="text" class="variantName form-control" placeholder="Weekend" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>Days:</label><input type="text" class="days form-control" placeholder="sat, sun" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>From:</label><input type="text" class="from form-control" placeholder="12-24 or 2024-12-24" autocomplete="off"></div>');
+  basic.append('<div class="form-group"><label>To:</label><input type="text" class="to form-control" placeholder="01-06 or 2025-01-06" autocomplete="off"></div>');
+  variant.append(basic);
+
+  var header = '<tr><th scope="col">Time</th><th scope="col">Color Temperature</th><th scope="col">Brightness</th><th scope="col">Control</th></tr>';
+  var subschedule = $('<div class="subschedule">');
+  subschedule.append('<h2>Morning <small>(empty to keep the schedule\'s entries)</small></h2>');
+  subschedule.append($('<table class="beforeSunrise table">').append($('<tbody>').append(header)));
+  subschedule.append('<div class="text-center"><button type="button" class="addEntryButton btn btn-primary">Add entry</button></div>');
+  variant.append(subschedule);
+
+  var subschedule = $('<div class="subschedule">');
+  subschedule.append('<h2>Daylight <small>(empty to keep the schedule\'s values)</small></h2>');
+  var form = $('<tr class="entry">');
+  form.append('<td><input type="text" name="time" class="text form-control" value="sunrise - sunset" disabled></td>');
+  form.append('<td><input type="number" name="colorTemperature" class="colorTemperature form-control" min="-1" max="6500" autocomplete="off"></td>');
+  form.append('<td><input type="number" name="brightness" class="brightness form-control" min="-1" max="100" autocomplete="off"></td>');
+  form.append('<td></td>');
+  subschedule.append($('<table class="default table">').append($('<tbody>').append(header).append(form)));
+  variant.append(subschedule);
+
+  var subschedule = $('<div class="subschedule">');
+  subschedule.append('<h2>Evening <small>(empty to keep the schedule\'s entries)</small></h2>');
+  subschedule.append($('<table class="afterSunset table">').append($('<tbody>').append(header)));
+  subschedule.append('<div class="text-center"><button type="button" class="addEntryButton btn btn-primary">Add entry</button></div>');
+  variant.append(subschedule);
+
+  variant.append('<div class="text-right"><button type="button" class="deleteVariantButton btn btn-danger">Delete variant</button></div>');
+  $(target).find(".addVariantButton").parent().before(variant);
+}
+
 function activateEntry(target) {
   var entry = Object();
   entry.colorTemperature = parseInt(target.find(".colorTemperature").val());
   entry.brightness = parseInt(target.find(".brightness").val());
   console.log(JSON.stringify(entry));
   var associatedDeviceIDs =  parseIDs(target.parents("div.schedule").find(".lights").val());
+  var bridge = target.parents("div.schedule").find(".bridge").val().trim();
+  var prefix = bridge == "" ? "" : "/bridges/" + bridge;
   for (i = 0; i < associatedDeviceIDs.length; i++) {
     $.ajax({
-      url: "/lights/"+associatedDeviceIDs[i]+"/activate",
+      url: prefix + "/lights/"+associatedDeviceIDs[i]+"/activate",
       type: 'PUT',
       data: JSON.stringify(entry),
       contentType: 'application/json'
@@ -160,3 +354,13 @@ function parseIDs(text) {
   }
   return ids;
 }
+
+function parseNames(text) {
+  var names = Array();
+  text.split(",").forEach(function(name) {
+    if (name.trim() != "") {
+      names.push(name.trim());
+    }
+  });
+  return names;
+}
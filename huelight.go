@@ -23,6 +23,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"time"
 
@@ -34,7 +35,8 @@ var lightsSupportingDimming = []string{"Dimmable light", "Color temperature ligh
 var lightsSupportingColorTemperature = []string{"Color temperature light", "Extended color light"}
 var lightsSupportingXYColor = []string{"Color light", "Extended color light"}
 
-// HueLight represents a physical hue light.
+// HueLight represents a physical hue light. It implements the
+// Device interface for lights of a HueBridge.
 type HueLight struct {
 	Name                     string
 	HueLight                 hue.Light
@@ -53,6 +55,11 @@ type HueLight struct {
 	Reachable                bool
 	On                       bool
 	MinimumColorTemperature  int
+	MaximumColorTemperature  int
+	ColorGamut               [][]float32
+	clip                     *clipV2Client
+	clipID                   string
+	limiter                  *rateLimiter
 }
 
 func (light *HueLight) initialize(attr hue.LightAttributes) {
@@ -72,12 +79,30 @@ func (light *HueLight) initialize(attr hue.LightAttributes) {
 	} else {
 		light.MinimumColorTemperature = 0
 	}
+	if light.supportsColorTemperature() {
+		light.MaximumColorTemperature = 6500
+	}
 
 	log.Debugf("💡 Light %s - Initialization complete. Identified as %s (ModelID: %s, Version: %s)", light.Name, attr.Type, attr.ModelId, attr.SoftwareVersion)
 
 	light.updateCurrentLightState(attr)
 }
 
+func (light *HueLight) initializeV2(resource clipV2Light, attr hue.LightAttributes, client *clipV2Client) {
+	light.initialize(attr)
+	light.clip = client
+	light.clipID = resource.ID
+
+	// Prefer the capabilities reported by the light over our defaults
+	minimum, maximum := resource.colorTemperatureRange()
+	if light.SupportsColorTemperature && minimum != 0 && maximum != 0 {
+		light.MinimumColorTemperature = minimum
+		light.MaximumColorTemperature = maximum
+	}
+	light.ColorGamut = resource.gamut()
+	log.Debugf("💡 Light %s - Initialized via Hue API v2 (ID: %s, Temperature range: %dK - %dK, Gamut: %v)", light.Name, light.clipID, light.MinimumColorTemperature, light.MaximumColorTemperature, light.ColorGamut)
+}
+
 func (light *HueLight) supportsColorTemperature() bool {
 	if light.SupportsXYColor || light.SupportsColorTemperature {
 		return true
@@ -92,7 +117,51 @@ func (light *HueLight) supportsBrightness() bool {
 	return false
 }
 
-func (light *HueLight) updateCurrentLightState(attr hue.LightAttributes) {
+func (light *HueLight) supportsColor() bool {
+	return light.SupportsXYColor
+}
+
+func (light *HueLight) name() string {
+	return light.Name
+}
+
+func (light *HueLight) isReachable() bool {
+	return light.Reachable
+}
+
+func (light *HueLight) isOn() bool {
+	return light.On
+}
+
+func (light *HueLight) colorTemperatureRange() (int, int) {
+	return light.MinimumColorTemperature, light.MaximumColorTemperature
+}
+
+func (light *HueLight) stateDescription() string {
+	return fmt.Sprintf("TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d", light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness)
+}
+
+// hueObservedState contains the parts of hue.LightAttributes a user is
+// able to change.
+type hueObservedState struct {
+	On               bool
+	ColorMode        string
+	ColorTemperature int
+	Color            []float32
+	Brightness       int
+}
+
+func (light *HueLight) observedState() DeviceState {
+	return hueObservedState{light.On, light.CurrentColorMode, light.CurrentColorTemperature, light.CurrentColor, light.CurrentBrightness}
+}
+
+func (light *HueLight) updateCurrentLightState(state DeviceState) {
+	attr, ok := state.(hue.LightAttributes)
+	if !ok {
+		log.Warningf("💡 HueLight %s - Ignoring unknown light state %+v", light.Name, state)
+		return
+	}
+
 	light.CurrentColorTemperature = attr.State.Ct
 
 	var color []float32
@@ -113,29 +182,23 @@ func (light *HueLight) updateCurrentLightState(attr hue.LightAttributes) {
 }
 
 func (light *HueLight) setLightState(colorTemperature int, brightness int, transitionTime time.Duration) error {
-	if colorTemperature != -1 && (colorTemperature < 1000 || colorTemperature > 6500) {
-		log.Warningf("💡 Light %s - Invalid color temperature %d", light.Name, colorTemperature)
-	}
-	if brightness < -1 || brightness > 100 {
-		log.Warningf("💡 Light %s - Invalid brightness %d", light.Name, brightness)
-	}
-
-	if colorTemperature != -1 && colorTemperature < light.MinimumColorTemperature {
-		colorTemperature = light.MinimumColorTemperature
-		log.Debugf("💡 Light %s - Adjusted color temperature to light capability of %dK", light.Name, colorTemperature)
-	}
+	return light.sendLightState(colorTemperature, brightness, transitionTime, false)
+}
 
-	light.SetColorTemperature = colorTemperature
-	light.SetBrightness = brightness
+// turnOn switches the light on and sets the given light state at once.
+func (light *HueLight) turnOn(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.sendLightState(colorTemperature, brightness, transitionTime, true)
+}
 
-	// map parameters to target values
-	light.TargetColorTemperature = mapColorTemperature(colorTemperature)
-	light.TargetColor = colorTemperatureToXYColor(colorTemperature)
-	light.TargetBrightness = mapBrightness(brightness)
+func (light *HueLight) sendLightState(colorTemperature int, brightness int, transitionTime time.Duration, turnOn bool) error {
+	colorTemperature = light.setTargetLightState(colorTemperature, brightness)
 
 	// Send new state to light bulb
 	var hueLightState hue.SetLightState
 	hueLightState.TransitionTime = strconv.Itoa(int(transitionTime / time.Millisecond / 100))
+	if turnOn {
+		hueLightState.On = "true"
+	}
 
 	if colorTemperature != -1 {
 		// Set supported colormodes. If both are, the brigde will prefer xy colors
@@ -158,17 +221,56 @@ func (light *HueLight) setLightState(colorTemperature int, brightness int, trans
 
 	// Send new state to the light
 	log.Debugf("💡 HueLight %s - Setting light state to %dK and %d%% brightness (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, TransitionTime: %s)", light.Name, colorTemperature, brightness, light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness, hueLightState.TransitionTime)
-	result, err := light.HueLight.SetState(hueLightState)
-	if err != nil {
-		log.Warningf("💡 HueLight %s - Setting light state failed: %v (Result: %v)", light.Name, err, result)
-		return err
+	if light.clip != nil {
+		err := light.clip.setLightState(light.clipID, hueLightState)
+		if err != nil {
+			log.Warningf("💡 HueLight %s - Setting light state via Hue API v2 failed: %v", light.Name, err)
+			return err
+		}
+	} else {
+		light.limiter.wait()
+		result, err := light.HueLight.SetState(hueLightState)
+		if err != nil {
+			log.Warningf("💡 HueLight %s - Setting light state failed: %v (Result: %v)", light.Name, err, result)
+			return err
+		}
 	}
 
+	if turnOn && brightness != 0 {
+		light.On = true
+	}
 	log.Debugf("💡 HueLight %s - Light was successfully updated (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, TransitionTime: %s)", light.Name, light.TargetColorTemperature, light.CurrentColorTemperature, light.TargetColor, light.CurrentColor, light.TargetBrightness, light.CurrentBrightness, hueLightState.TransitionTime)
 	return nil
 }
 
+// setTargetLightState stores the given light state as the new target of
+// this light without sending it. It returns the color temperature adjusted
+// to the capabilities of the light.
+func (light *HueLight) setTargetLightState(colorTemperature int, brightness int) int {
+	if colorTemperature != -1 && (colorTemperature < 1000 || colorTemperature > 6500) {
+		log.Warningf("💡 Light %s - Invalid color temperature %d", light.Name, colorTemperature)
+	}
+	if brightness < -1 || brightness > 100 {
+		log.Warningf("💡 Light %s - Invalid brightness %d", light.Name, brightness)
+	}
+
+	colorTemperature = light.adjustColorTemperature(colorTemperature)
+
+	light.SetColorTemperature = colorTemperature
+	light.SetBrightness = brightness
+
+	// map parameters to target values
+	light.TargetColorTemperature = mapColorTemperature(colorTemperature)
+	light.TargetColor = clampToGamut(colorTemperatureToXYColor(colorTemperature), light.ColorGamut)
+	light.TargetBrightness = mapBrightness(brightness)
+	return colorTemperature
+}
+
 func (light *HueLight) hasChanged() bool {
+	return light.hasColorTemperatureChanged() || light.hasBrightnessChanged()
+}
+
+func (light *HueLight) hasColorTemperatureChanged() bool {
 	if light.SupportsXYColor && light.CurrentColorMode == "xy" {
 		if !equalsFloat(light.TargetColor, []float32{-1, -1}, 0) && !equalsFloat(light.TargetColor, light.CurrentColor, 0.001) {
 			log.Debugf("💡 HueLight %s - Color has changed! CurrentColor: %v, TargetColor: %v (%dK)", light.Name, light.CurrentColor, light.TargetColor, light.SetColorTemperature)
@@ -180,12 +282,14 @@ func (light *HueLight) hasChanged() bool {
 			return true
 		}
 	}
+	return false
+}
 
+func (light *HueLight) hasBrightnessChanged() bool {
 	if light.Dimmable && light.TargetBrightness != -1 && !equalsInt(light.TargetBrightness, light.CurrentBrightness, 2) {
 		log.Debugf("💡 HueLight %s - Brightness has changed! CurrentBrightness: %d, TargetBrightness: %d (%d%%)", light.Name, light.CurrentBrightness, light.TargetBrightness, light.SetBrightness)
 		return true
 	}
-
 	return false
 }
 
@@ -201,13 +305,10 @@ func (light *HueLight) hasColorTemperature(colorTemperature int) bool {
 		return true
 	}
 
-	if colorTemperature < light.MinimumColorTemperature {
-		colorTemperature = light.MinimumColorTemperature
-		log.Debugf("💡 Light %s - Adjusted color temperature to light capability of %dK", light.Name, colorTemperature)
-	}
+	colorTemperature = light.adjustColorTemperature(colorTemperature)
 
 	if light.SupportsXYColor && light.CurrentColorMode == "xy" {
-		if equalsFloat(colorTemperatureToXYColor(colorTemperature), light.CurrentColor, 0.001) {
+		if equalsFloat(clampToGamut(colorTemperatureToXYColor(colorTemperature), light.ColorGamut), light.CurrentColor, 0.001) {
 			return true
 		}
 		return false
@@ -224,6 +325,23 @@ func (light *HueLight) hasColorTemperature(colorTemperature int) bool {
 	return true
 }
 
+// adjustColorTemperature limits the given color temperature to the
+// range supported by this light.
+func (light *HueLight) adjustColorTemperature(colorTemperature int) int {
+	if colorTemperature == -1 {
+		return colorTemperature
+	}
+	if colorTemperature < light.MinimumColorTemperature {
+		colorTemperature = light.MinimumColorTemperature
+		log.Debugf("💡 Light %s - Adjusted color temperature to light capability of %dK", light.Name, colorTemperature)
+	}
+	if light.MaximumColorTemperature != 0 && colorTemperature > light.MaximumColorTemperature {
+		colorTemperature = light.MaximumColorTemperature
+		log.Debugf("💡 Light %s - Adjusted color temperature to light capability of %dK", light.Name, colorTemperature)
+	}
+	return colorTemperature
+}
+
 func (light *HueLight) hasBrightness(brightness int) bool {
 	if brightness == -1 || light.TargetBrightness == -1 {
 		return true
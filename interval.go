@@ -24,11 +24,13 @@ package main
 import "time"
 import log "github.com/sirupsen/logrus"
 
-// Interval represents a time range of one day with
-// the given start and end configurations.
+// Interval represents a time range between two consecutive timestamps of
+// a schedule. As the timeline of a schedule is continuous, an interval may
+// span midnight.
 type Interval struct {
 	Start TimeStamp
 	End   TimeStamp
+	curve *elevationCurve
 }
 
 func (interval *Interval) calculateLightStateInInterval(timestamp time.Time) LightState {
@@ -42,23 +44,25 @@ func (interval *Interval) calculateLightStateInInterval(timestamp time.Time) Lig
 		return LightState{interval.End.ColorTemperature, interval.End.Brightness}
 	}
 
+	// Follow the elevation of the sun if configured
+	if interval.curve != nil {
+		return interval.curve.lightState(timestamp)
+	}
+
 	// Calculate regular progress inside interval
 	intervalDuration := interval.End.Time.Sub(interval.Start.Time)
 	intervalProgress := timestamp.Sub(interval.Start.Time)
 	percentProgress := intervalProgress.Minutes() / intervalDuration.Minutes()
 
+	// The easing of the end of the interval defines the way towards it
 	targetColorTemperature := interval.End.ColorTemperature
 	if interval.Start.ColorTemperature != -1 && interval.End.ColorTemperature != -1 {
-		colorTemperatureDiff := interval.End.ColorTemperature - interval.Start.ColorTemperature
-		colorTemperaturePercentageValue := float64(colorTemperatureDiff) * percentProgress
-		targetColorTemperature = interval.Start.ColorTemperature + int(colorTemperaturePercentageValue)
+		targetColorTemperature = easeColorTemperature(interval.Start.ColorTemperature, interval.End.ColorTemperature, percentProgress, interval.End.Easing.ColorTemperature)
 	}
 
 	targetBrightness := interval.End.Brightness
 	if interval.Start.Brightness != -1 && interval.End.Brightness != -1 {
-		brightnessDiff := interval.End.Brightness - interval.Start.Brightness
-		brightnessPercentageValue := float64(brightnessDiff) * percentProgress
-		targetBrightness = interval.Start.Brightness + int(brightnessPercentageValue)
+		targetBrightness = easeBrightness(interval.Start.Brightness, interval.End.Brightness, percentProgress, interval.End.Easing.Brightness)
 	}
 
 	lightstate := LightState{targetColorTemperature, targetBrightness}
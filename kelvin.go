@@ -22,10 +22,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -43,14 +45,29 @@ var flagForceUpdate = flag.Bool("forceUpdate", false, "Update to new major versi
 var flagEnableWebInterface = flag.Bool("enableWebInterface", false, "Enable the web interface at startup")
 var flagDisableRateLimiting = flag.Bool("disableRateLimiting", false, "Disable the limiting of requests to the hue bridge")
 var flagDisableHTTPS = flag.Bool("disableHTTPS", false, "Disable HTTPS for the connection to the hue bridge")
+var flagDisableGroups = flag.Bool("disableGroups", false, "Disable the control of lights via rooms, zones and groups")
+var flagStateFile = flag.String("state", "", "Specify the filename to persist the state of lights to (defaults to state.json next to the configuration)")
 
 var configuration *Configuration
-var bridge = &HueBridge{}
-var lights []*Light
+var bridges []LightBackend
+var lights = make(map[string][]*Light)
+
+// lightsMutex guards the bridges and the lists of managed lights of all
+// bridges.
+var lightsMutex sync.Mutex
+
+// reloadRequests and restartRequests ask the main loop to stop all tasks
+// and reload the configuration or restart the binary.
+var reloadRequests = make(chan struct{}, 1)
+var restartRequests = make(chan struct{}, 1)
 
 const lightUpdateInterval = 1 * time.Second
 const stateUpdateInterval = 1 * time.Minute
 
+// reloadTimeout limits the time to connect to all bridges of a reloaded
+// configuration before Kelvin reverts to the previous one.
+const reloadTimeout = 2 * time.Minute
+
 const timeBetweenHueAPICalls = 100 * time.Millisecond // see https://developers.meethue.com/develop/application-design-guidance/hue-system-performance/
 const lightTransistionTime = 400 * time.Millisecond
 
@@ -62,9 +79,14 @@ func main() {
 	log.Debugf("🤖 Built at %s based on commit %s", date, commit)
 	log.Debugf("🤖 Current working directory: %v", workingDirectory())
 
-	go CheckForUpdate(version, *flagForceUpdate)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go CheckForUpdate(ctx, version, *flagForceUpdate)
 	go validateSystemTime()
-	go handleSIGHUP()
+
+	// Stop on SIGINT and SIGTERM, reload on SIGHUP
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 
 	// Load configuration or create a new one
 	conf, err := InitializeConfiguration(*flagConfigurationFile, *flagEnableWebInterface)
@@ -72,75 +94,281 @@ func main() {
 		log.Fatal(err)
 	}
 	configuration = &conf
+	backends, err := newLightBackends(configuration)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Start web interface
-	go startInterface()
-
-	// Find Hue bridge
-	log.Printf("🤖 Initializing bridge connection...")
+	var previous *Configuration
+	restart := false
 	for {
-		err = bridge.InitializeBridge(configuration)
-		if err != nil {
-			log.Errorf("Could not initialize bridge: %v - Retrying...", err)
-			time.Sleep(10 * time.Second)
-		} else {
+		runCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		failed := make(chan error, 1)
+		timeout := time.Duration(0)
+		if previous != nil {
+			timeout = reloadTimeout
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := run(runCtx, &wg, backends, timeout)
+			if err != nil {
+				failed <- err
+			}
+		}()
+
+		var next *Configuration
+		var nextBackends []LightBackend
+		exit, reverted := false, false
+		for next == nil && !exit {
+			select {
+			case err := <-failed:
+				if previous == nil {
+					log.Fatal(err)
+				}
+				log.Errorf("🤖 Could not start with the new configuration: %v - Continuing with the previous one...", err)
+				next, reverted = previous, true
+				nextBackends, err = newLightBackends(previous)
+				if err != nil {
+					log.Fatal(err)
+				}
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					log.Printf("🤖 Received signal SIGHUP. Reloading configuration...")
+					next, nextBackends = reloadConfiguration()
+				} else {
+					log.Printf("🤖 Received signal %v. Shutting down...", sig)
+					exit = true
+				}
+			case <-reloadRequests:
+				log.Printf("🤖 Reloading configuration...")
+				next, nextBackends = reloadConfiguration()
+			case <-restartRequests:
+				log.Printf("🤖 Restarting...")
+				restart, exit = true, true
+			}
+		}
+
+		cancel()
+		shutdown(&wg)
+		if exit {
 			break
 		}
+		previous = configuration
+		if reverted {
+			previous = nil
+		}
+		configuration, backends = next, nextBackends
+	}
+
+	stop()
+	log.Printf("🤖 Kelvin stopped. Bye!")
+	if restart {
+		restartBinary()
+	}
+}
+
+// reloadConfiguration reads the configuration from disk again. If it is
+// invalid, nil is returned and Kelvin keeps running with the current one.
+func reloadConfiguration() (*Configuration, []LightBackend) {
+	conf, err := InitializeConfiguration(*flagConfigurationFile, *flagEnableWebInterface)
+	if err != nil {
+		log.Errorf("⚙ Could not reload configuration: %v - Keeping the current one...", err)
+		return nil, nil
+	}
+	backends, err := newLightBackends(&conf)
+	if err != nil {
+		log.Errorf("⚙ Could not reload configuration: %v - Keeping the current one...", err)
+		return nil, nil
+	}
+	return &conf, backends
+}
+
+// newLightBackends returns an uninitialized backend for every bridge of the
+// given configuration.
+func newLightBackends(configuration *Configuration) ([]LightBackend, error) {
+	var backends []LightBackend
+	for _, bridgeConfiguration := range configuration.Bridges {
+		backend, err := newLightBackend(bridgeConfiguration.Type)
+		if err != nil {
+			return nil, err
+		}
+		backends = append(backends, backend)
+	}
+	return backends, nil
+}
+
+// run connects to the given bridges and manages their lights until the
+// given context is done. All goroutines started are added to wg. If timeout
+// is not zero, run fails if the bridges can't be initialized in time.
+// Otherwise it keeps retrying.
+func run(ctx context.Context, wg *sync.WaitGroup, backends []LightBackend, timeout time.Duration) error {
+	setBridges(backends)
+
+	// Start web interface
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		startInterface(ctx)
+	}()
+
+	// Connect to all bridges
+	initCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		initCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	for index, bridge := range backends {
+		log.Printf("🤖 Initializing connection to bridge %d of %d...", index+1, len(backends))
+		for {
+			err := bridge.InitializeBridge(initCtx, configuration, index)
+			if err == nil {
+				break
+			}
+			if initCtx.Err() == nil {
+				log.Errorf("Could not initialize bridge: %v - Retrying...", err)
+				select {
+				case <-initCtx.Done():
+				case <-time.After(10 * time.Second):
+				}
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if initCtx.Err() != nil {
+				return fmt.Errorf("Could not initialize bridge %d within %v: %v", index+1, timeout, err)
+			}
+		}
 	}
 
 	// Find geo location
-	_, err = InitializeLocation(configuration)
+	_, err := InitializeLocation(configuration)
 	if err != nil {
 		log.Warning(err)
 	}
+	configuration.validateSchedules(time.Now())
 
 	// Save configuration
 	err = configuration.Write()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
+	// Restore the state of lights from the last run
+	states, err := loadLightStates()
+	if err != nil {
+		log.Warningf("🤖 Could not load state of lights: %v", err)
+	}
+	savedStates = states
+
+	// Start cyclic update for all lights and scenes of every bridge
+	for _, bridge := range backends {
+		bridge := bridge
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manageLights(ctx, bridge)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		persistLightStates(ctx)
+	}()
+	return nil
+}
+
+// shutdown waits for all goroutines of the last run to stop and saves the
+// configuration and the state of all lights. All goroutines honor the
+// context of the run, so no task of the last run can interfere with the
+// next one.
+func shutdown(wg *sync.WaitGroup) {
+	wg.Wait()
+
+	// Don't overwrite the saved state if Kelvin stopped before managing lights
+	if len(allLights()) > 0 {
+		err := saveLightStates()
+		if err != nil {
+			log.Warningf("🤖 Could not save state of lights: %v", err)
+		}
+	}
+	err := configuration.Write()
+	if err != nil {
+		log.Warningf("⚙ Could not save configuration: %v", err)
+	}
+
+	for _, bridge := range allBridges() {
+		if closable, ok := bridge.(closableBackend); ok {
+			closable.close()
+		}
+	}
+	lightsMutex.Lock()
+	bridges = nil
+	lights = make(map[string][]*Light)
+	lightsMutex.Unlock()
+}
+
+// manageLights keeps all lights of the given bridge in sync with their
+// schedules. It returns when the given context is done.
+func manageLights(ctx context.Context, bridge LightBackend) {
 	// Initialize lights
 	l, err := bridge.Lights()
 	if err != nil {
 		log.Warning(err)
 	}
-	printDevices(l)
-	for _, light := range l {
-		light := light
+	printDevices(bridge, l)
+	addLights(bridge, l)
 
-		// Filter devices we can't control
-		if !light.HueLight.supportsColorTemperature() && !light.HueLight.supportsBrightness() {
-			log.Printf("🤖 Light %s - This device doesn't support any functionality Kelvin uses. Ignoring...", light.Name)
-		} else {
-			lights = append(lights, light)
-			updateScheduleForLight(light)
-		}
-	}
+	// Initialize scenes and groups
+	updateScenes(bridge)
+	updateGroups(bridge)
 
-	// Initialize scenes
-	updateScenes()
+	// Subscribe to light events if supported by the bridge
+	var lightEvents <-chan LightEvent
+	eventStream := bridge.LightEvents()
+	var streams sync.WaitGroup
+	defer streams.Wait()
+	if eventStream != nil {
+		lightEvents = eventStream.Events()
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+			eventStream.Start(ctx)
+		}()
+	}
 
-	// Start cyclic update for all lights and scenes
-	log.Debugf("🤖 Starting cyclic update...")
+	log.Debugf("🤖 Starting cyclic update for bridge %s...", bridge.bridgeID())
 	lightUpdateTimer := time.NewTimer(lightUpdateInterval)
-	stateUpdateTick := time.Tick(stateUpdateInterval)
+	defer lightUpdateTimer.Stop()
+	stateUpdateTicker := time.NewTicker(stateUpdateInterval)
+	defer stateUpdateTicker.Stop()
 	newDayTimer := time.After(durationUntilNextDay())
 	for {
 		select {
+		case <-ctx.Done():
+			log.Debugf("🤖 Stopping cyclic update for bridge %s...", bridge.bridgeID())
+			return
 		case <-newDayTimer:
 			// A new day has begun, calculate new schedule
-			log.Printf("🤖 Calculating schedule for %v", time.Now().Format("Jan 2 2006"))
-			for _, light := range lights {
+			log.Printf("🤖 Calculating schedule for %v on bridge %s", time.Now().Format("Jan 2 2006"), bridge.bridgeID())
+			for _, light := range managedLights(bridge) {
 				light := light
 				updateScheduleForLight(light)
 			}
-			updateScenes()
+			l, err := bridge.Lights()
+			if err != nil {
+				log.Warningf("🤖 Could not look for new lights: %v", err)
+			}
+			addLights(bridge, l)
+			updateScenes(bridge)
+			updateGroups(bridge)
 			newDayTimer = time.After(durationUntilNextDay())
-		case <-stateUpdateTick:
+		case <-stateUpdateTicker.C:
 			// update interval and color every minute
 			updated := false
-			for _, light := range lights {
+			for _, light := range managedLights(bridge) {
 				light := light
 				light.updateInterval()
 				if light.updateTargetLightState() {
@@ -149,28 +377,46 @@ func main() {
 			}
 			// update scenes
 			if updated {
-				updateScenes()
+				updateScenes(bridge)
+			}
+		case event := <-lightEvents:
+			for _, light := range managedLights(bridge) {
+				light := light
+				if light.ID == event.ID {
+					light.updateCurrentLightState(event.State)
+					updateLight(light)
+				}
 			}
 		case <-lightUpdateTimer.C:
-			states, err := bridge.LightStates()
-			if err != nil {
-				log.Warningf("🤖 Failed to update light states: %v", err)
+			// Current light states are kept up to date by the event stream if available
+			updatable := managedLights(bridge)
+			if eventStream == nil || !eventStream.IsConnected() {
+				states, err := bridge.LightStates()
+				if err != nil {
+					log.Warningf("🤖 Failed to update light states of bridge %s: %v", bridge.bridgeID(), err)
+				}
+
+				updatable = []*Light{}
+				for _, light := range managedLights(bridge) {
+					light := light
+					currentLightState, found := states[light.ID]
+					if found {
+						light.updateCurrentLightState(currentLightState)
+						updatable = append(updatable, light)
+					} else {
+						log.Warningf("🤖 Light %s - No current light state found", light.Name)
+					}
+				}
 			}
 
-			for _, light := range lights {
+			grouped := make(map[int]bool)
+			if groups, ok := bridge.(groupBackend); ok {
+				grouped = groups.updateLightsViaGroups(updatable, lightTransistionTime)
+			}
+			for _, light := range updatable {
 				light := light
-				currentLightState, found := states[light.ID]
-				if found {
-					light.updateCurrentLightState(currentLightState)
-					updated, err := light.update(lightTransistionTime)
-					if err != nil {
-						log.Warningf("🤖 Light %s - Failed to update light: %v", light.Name, err)
-					}
-					if updated {
-						log.Debugf("🤖 Light %s - Updated light state. Awaiting transition...", light.Name)
-					}
-				} else {
-					log.Warningf("🤖 Light %s - No current light state found", light.Name)
+				if !grouped[light.ID] {
+					updateLight(light)
 				}
 			}
 
@@ -179,8 +425,103 @@ func main() {
 	}
 }
 
+func updateScenes(bridge LightBackend) {
+	if scenes, ok := bridge.(sceneBackend); ok {
+		scenes.updateScenes()
+	}
+}
+
+func updateGroups(bridge LightBackend) {
+	if *flagDisableGroups {
+		return
+	}
+	if groups, ok := bridge.(groupBackend); ok {
+		groups.updateGroups()
+	}
+}
+
+func updateLight(light *Light) {
+	updated, err := light.update(lightTransistionTime)
+	if err != nil {
+		log.Warningf("🤖 Light %s - Failed to update light: %v", light.Name, err)
+	}
+	if updated {
+		log.Debugf("🤖 Light %s - Updated light state. Awaiting transition...", light.Name)
+	}
+}
+
+// addLights starts managing all given lights Kelvin doesn't know yet.
+func addLights(bridge LightBackend, l []*Light) {
+	for _, light := range l {
+		light := light
+
+		known := false
+		for _, existing := range managedLights(bridge) {
+			if existing.ID == light.ID {
+				known = true
+			}
+		}
+		if known {
+			continue
+		}
+
+		// Filter devices we can't control
+		if !light.Device.supportsColorTemperature() && !light.Device.supportsBrightness() {
+			log.Printf("🤖 Light %s - This device doesn't support any functionality Kelvin uses. Ignoring...", light.Name)
+		} else {
+			updateScheduleForLight(light)
+			light.savedState = savedStates[light.BridgeID][light.ID]
+			lightsMutex.Lock()
+			lights[bridge.bridgeID()] = append(lights[bridge.bridgeID()], light)
+			lightsMutex.Unlock()
+		}
+	}
+}
+
+// managedLights returns all lights of the bridge managed by Kelvin.
+func managedLights(bridge LightBackend) []*Light {
+	lightsMutex.Lock()
+	defer lightsMutex.Unlock()
+	return lights[bridge.bridgeID()]
+}
+
+// setBridges replaces the list of all bridges.
+func setBridges(backends []LightBackend) {
+	lightsMutex.Lock()
+	defer lightsMutex.Unlock()
+	bridges = backends
+}
+
+// allBridges returns a copy of the list of all bridges.
+func allBridges() []LightBackend {
+	lightsMutex.Lock()
+	defer lightsMutex.Unlock()
+	return append([]LightBackend(nil), bridges...)
+}
+
+// allLights returns the managed lights of all bridges.
+func allLights() []*Light {
+	lightsMutex.Lock()
+	defer lightsMutex.Unlock()
+	var all []*Light
+	for _, bridge := range bridges {
+		all = append(all, lights[bridge.bridgeID()]...)
+	}
+	return all
+}
+
+// findLight returns the managed light with the given ID on the given bridge.
+func findLight(bridgeID string, lightID int) *Light {
+	for _, light := range allLights() {
+		if light.BridgeID == bridgeID && light.ID == lightID {
+			return light
+		}
+	}
+	return nil
+}
+
 func updateScheduleForLight(light *Light) {
-	schedule, err := configuration.lightScheduleForDay(light.ID, time.Now())
+	schedule, err := configuration.lightScheduleForDay(light.BridgeID, light.ID, time.Now())
 	if err != nil {
 		log.Printf("🤖 Light %s - Light is not associated to any schedule. Ignoring...", light.Name)
 		light.Schedule = schedule // Assign empty schedule
@@ -191,26 +532,19 @@ func updateScheduleForLight(light *Light) {
 	}
 }
 
-func printDevices(l []*Light) {
-	log.Printf("🤖 Devices found on current bridge:")
+func printDevices(bridge LightBackend, l []*Light) {
+	log.Printf("🤖 Devices found on bridge %s:", bridge.bridgeID())
 	log.Printf("| %-32s | %3v | %-5v | %-8v | %-11v | %-5v | %17v |", "Name", "ID", "On", "Dimmable", "Temperature", "Color", "Temperature range")
 	for _, light := range l {
 		ctRange := ""
-		if light.HueLight.supportsColorTemperature() {
-			ctRange = fmt.Sprintf("%dK - %dK", light.HueLight.MinimumColorTemperature, 6500)
+		if light.Device.supportsColorTemperature() {
+			minimum, maximum := light.Device.colorTemperatureRange()
+			ctRange = fmt.Sprintf("%dK - %dK", minimum, maximum)
 		}
-		log.Printf("| %-32s | %3v | %-5v | %-8v | %-11v | %-5v | %17v |", light.Name, light.ID, light.On, light.HueLight.Dimmable, light.HueLight.SupportsColorTemperature, light.HueLight.SupportsXYColor, ctRange)
+		log.Printf("| %-32s | %3v | %-5v | %-8v | %-11v | %-5v | %17v |", light.Name, light.ID, light.On, light.Device.supportsBrightness(), light.Device.supportsColorTemperature(), light.Device.supportsColor(), ctRange)
 	}
 }
 
-func handleSIGHUP() {
-	sighup := make(chan os.Signal, 1)
-	signal.Notify(sighup, syscall.SIGHUP)
-	<-sighup // wait for signal
-	log.Printf("🤖 Received signal SIGHUP. Restarting...")
-	Restart()
-}
-
 func configureLogging() {
 	formatter := new(log.TextFormatter)
 	formatter.FullTimestamp = true
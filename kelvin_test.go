@@ -0,0 +1,86 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unreachableBackend fails to connect to its bridge.
+type unreachableBackend struct {
+	LightBackend
+}
+
+func (backend *unreachableBackend) InitializeBridge(ctx context.Context, configuration *Configuration, index int) error {
+	return errors.New("no route to host")
+}
+
+func TestRunTimeout(t *testing.T) {
+	previous := configuration
+	defer func() {
+		configuration = previous
+		setBridges(nil)
+	}()
+	configuration = &Configuration{}
+
+	// A reloaded configuration has to connect in time
+	var wg sync.WaitGroup
+	failed := make(chan error, 1)
+	go func() {
+		failed <- run(context.Background(), &wg, []LightBackend{&unreachableBackend{}}, 50*time.Millisecond)
+	}()
+	select {
+	case err := <-failed:
+		if err == nil || !strings.Contains(err.Error(), "no route to host") {
+			t.Errorf("Run returned %v; want timeout with the last error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not give up on the unreachable bridge")
+	}
+	wg.Wait()
+
+	// Without timeout run keeps retrying until it is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		failed <- run(ctx, &wg, []LightBackend{&unreachableBackend{}}, 0)
+	}()
+	select {
+	case err := <-failed:
+		t.Fatalf("Run returned %v; want retries", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-failed:
+		if err != nil {
+			t.Errorf("Stopped run returned %v; want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop")
+	}
+	wg.Wait()
+}
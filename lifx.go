@@ -0,0 +1,674 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const backendLIFX = "lifx"
+const lifxDefaultID = "lifx"
+const lifxPort = 56700
+const lifxHeaderSize = 36
+const lifxDiscoveryDuration = 1 * time.Second
+const lifxTimeout = 500 * time.Millisecond
+const lifxRetries = 3
+
+// Most LIFX bulbs support this range. Bulbs will clamp other values.
+const lifxMinimumColorTemperature = 2500
+const lifxMaximumColorTemperature = 9000
+
+// Message types of the LIFX LAN protocol.
+// See https://lan.developer.lifx.com/docs/packet-contents
+const (
+	lifxGetService      = 2
+	lifxStateService    = 3
+	lifxGetGroup        = 51
+	lifxStateGroup      = 53
+	lifxAcknowledgement = 45
+	lifxLightGet        = 101
+	lifxLightSetColor   = 102
+	lifxLightState      = 107
+	lifxLightSetPower   = 117
+)
+
+const lifxFlagAckRequired = 0x02
+const lifxFlagResRequired = 0x01
+
+// LIFXBridge controls all LIFX bulbs in the local network via the LIFX LAN
+// protocol. Bulbs are discovered by broadcasting and polled for their state
+// as the protocol doesn't report changes.
+type LIFXBridge struct {
+	ID            string
+	Broadcast     *net.UDPAddr
+	conn          *net.UDPConn
+	source        uint32
+	sequence      uint8
+	exchangeMutex sync.Mutex
+	mutex         sync.Mutex
+	bulbs         map[int]*lifxBulb
+}
+
+// lifxBulb represents a bulb found during discovery.
+type lifxBulb struct {
+	ID      int
+	target  uint64
+	address *net.UDPAddr
+	label   string
+	group   string
+}
+
+// lifxBulbState is the state of a bulb as reported by Light::State.
+type lifxBulbState struct {
+	Reachable  bool
+	Power      bool
+	Label      string
+	Hue        uint16
+	Saturation uint16
+	Brightness uint16
+	Kelvin     uint16
+}
+
+// lifxHeader contains the relevant fields of the header of a LIFX message.
+type lifxHeader struct {
+	size        uint16
+	source      uint32
+	target      uint64
+	sequence    uint8
+	messageType uint16
+}
+
+// LIFXLight represents a single LIFX bulb. It implements the Device interface.
+type LIFXLight struct {
+	Name                   string
+	ID                     int
+	bridge                 *LIFXBridge
+	SetColorTemperature    int
+	SetBrightness          int
+	TargetColorTemperature int
+	TargetBrightness       int
+	Current                lifxBulbState
+}
+
+// InitializeBridge opens the socket used to talk to all bulbs in the
+// network configured at the given index.
+func (bridge *LIFXBridge) InitializeBridge(ctx context.Context, configuration *Configuration, index int) error {
+	if index >= len(configuration.Bridges) {
+		return fmt.Errorf("Bridge %d is not configured", index)
+	}
+	bridgeConfiguration := &configuration.Bridges[index]
+	if bridgeConfiguration.ID == "" {
+		bridgeConfiguration.ID = lifxDefaultID
+	}
+	bridge.ID = bridgeConfiguration.ID
+
+	broadcast, err := lifxAddress(bridgeConfiguration.IP)
+	if err != nil {
+		return err
+	}
+	bridge.Broadcast = broadcast
+	bridge.bulbs = make(map[int]*lifxBulb)
+	bridge.source = uint32(time.Now().UnixNano())
+
+	bridge.conn, err = net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+
+	err = bridge.discover()
+	if err != nil {
+		return err
+	}
+	if len(bridge.bulbs) == 0 {
+		log.Warningf("⌘ No LIFX bulbs found via %s", bridge.Broadcast)
+	}
+	log.Printf("⌘ Found %d LIFX bulbs via %s", len(bridge.bulbs), bridge.Broadcast)
+	return nil
+}
+
+// close releases the socket of the bridge.
+func (bridge *LIFXBridge) close() {
+	if bridge.conn != nil {
+		bridge.conn.Close()
+	}
+}
+
+// lifxAddress returns the address the discovery is broadcasted to.
+// If no address is configured the limited broadcast address is used.
+func lifxAddress(address string) (*net.UDPAddr, error) {
+	if address == "" {
+		address = "255.255.255.255"
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(lifxPort))
+	}
+	return net.ResolveUDPAddr("udp4", address)
+}
+
+// Lights looks for new bulbs and returns all bulbs found so far.
+func (bridge *LIFXBridge) Lights() ([]*Light, error) {
+	err := bridge.discover()
+	if err != nil {
+		log.Warningf("⌘ Could not look for LIFX bulbs: %v", err)
+	}
+
+	var lights []*Light
+	for _, bulb := range bridge.knownBulbs() {
+		state, err := bridge.lightState(bulb)
+		if err != nil {
+			log.Warningf("⌘ Could not read state of LIFX bulb %s: %v", bulb.label, err)
+		}
+
+		var light Light
+		light.ID = bulb.ID
+		light.BridgeID = bridge.ID
+		lifxLight := &LIFXLight{Name: bulb.label, ID: bulb.ID, bridge: bridge}
+		lifxLight.updateCurrentLightState(state)
+		light.Device = lifxLight
+		light.Name = lifxLight.Name
+		light.Reachable = lifxLight.isReachable()
+		light.On = lifxLight.isOn()
+		log.Debugf("💡 Light %s - Initialization complete. Identified as LIFX bulb %s at %s", lifxLight.Name, lifxMAC(bulb.target), bulb.address)
+
+		lights = append(lights, &light)
+	}
+	return lights, nil
+}
+
+// LightStates polls the state of every bulb. Bulbs not answering are
+// reported as unreachable.
+func (bridge *LIFXBridge) LightStates() (map[int]DeviceState, error) {
+	states := make(map[int]DeviceState)
+	for _, bulb := range bridge.knownBulbs() {
+		state, err := bridge.lightState(bulb)
+		if err != nil {
+			log.Debugf("⌘ Could not read state of LIFX bulb %s: %v", bulb.label, err)
+		}
+		states[bulb.ID] = state
+	}
+	return states, nil
+}
+
+// LightEvents returns nil as the LIFX LAN protocol doesn't report changes.
+func (bridge *LIFXBridge) LightEvents() LightEventSource {
+	return nil
+}
+
+func (bridge *LIFXBridge) bridgeID() string {
+	return bridge.ID
+}
+
+// deviceDirectory returns the labels of all bulbs. LIFX groups are
+// reported as rooms.
+func (bridge *LIFXBridge) deviceDirectory() (DeviceDirectory, error) {
+	directory := DeviceDirectory{make(map[int]string), make(map[string][]int), make(map[string][]int)}
+	for _, bulb := range bridge.knownBulbs() {
+		directory.Lights[bulb.ID] = bulb.label
+		if bulb.group != "" {
+			directory.Rooms[bulb.group] = append(directory.Rooms[bulb.group], bulb.ID)
+		}
+	}
+	return directory, nil
+}
+
+// knownBulbs returns all discovered bulbs ordered by their ID.
+func (bridge *LIFXBridge) knownBulbs() []lifxBulb {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	var bulbs []lifxBulb
+	for _, bulb := range bridge.bulbs {
+		bulbs = append(bulbs, *bulb)
+	}
+	sort.Slice(bulbs, func(i, j int) bool { return bulbs[i].ID < bulbs[j].ID })
+	return bulbs
+}
+
+// discover broadcasts GetService and adds every bulb answering to the
+// known bulbs. IDs are assigned in the order of the MAC addresses and
+// stay stable while Kelvin is running.
+func (bridge *LIFXBridge) discover() error {
+	bridge.exchangeMutex.Lock()
+	sequence := bridge.nextSequence()
+	_, err := bridge.conn.WriteToUDP(lifxPacket(bridge.source, 0, sequence, lifxFlagResRequired, lifxGetService, nil), bridge.Broadcast)
+	if err != nil {
+		bridge.exchangeMutex.Unlock()
+		return err
+	}
+
+	addresses := make(map[uint64]*net.UDPAddr)
+	deadline := time.Now().Add(lifxDiscoveryDuration)
+	for {
+		header, payload, from, err := bridge.receive(deadline)
+		if err != nil {
+			break // discovery time is over
+		}
+		if header.sequence != sequence || header.messageType != lifxStateService || len(payload) < 5 || payload[0] != 1 {
+			continue // only UDP services are of interest
+		}
+		port := int(binary.LittleEndian.Uint32(payload[1:]))
+		addresses[header.target] = &net.UDPAddr{IP: from.IP, Port: port}
+	}
+	bridge.exchangeMutex.Unlock()
+
+	var targets []uint64
+	for target := range addresses {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	for _, target := range targets {
+		bridge.mutex.Lock()
+		id := lifxLightID(target)
+		bulb, found := bridge.bulbs[id]
+		if found && bulb.target != target {
+			bridge.mutex.Unlock()
+			log.Warningf("⌘ Ignoring LIFX bulb %s as its ID %d is already used by %s", lifxMAC(target), id, lifxMAC(bulb.target))
+			continue
+		}
+		if !found {
+			bulb = &lifxBulb{ID: id, target: target}
+			bridge.bulbs[id] = bulb
+		}
+		bulb.address = addresses[target]
+		known := *bulb
+		bridge.mutex.Unlock()
+
+		label, group := known.label, known.group
+		state, err := bridge.lightState(known)
+		if err != nil {
+			log.Debugf("⌘ Could not read label of LIFX bulb %s: %v", lifxMAC(target), err)
+		} else {
+			label = state.Label
+		}
+		payload, err := bridge.exchange(known, lifxGetGroup, nil, lifxStateGroup)
+		if err == nil && len(payload) >= 48 {
+			group = lifxString(payload[16:48])
+		}
+
+		bridge.mutex.Lock()
+		bulb.label = label
+		bulb.group = group
+		bridge.mutex.Unlock()
+	}
+	return nil
+}
+
+// lightState reads the current state of the given bulb.
+func (bridge *LIFXBridge) lightState(bulb lifxBulb) (lifxBulbState, error) {
+	payload, err := bridge.exchange(bulb, lifxLightGet, nil, lifxLightState)
+	if err != nil {
+		return lifxBulbState{}, err
+	}
+	if len(payload) < 44 {
+		return lifxBulbState{}, fmt.Errorf("Invalid state of %d bytes", len(payload))
+	}
+	var state lifxBulbState
+	state.Reachable = true
+	state.Hue = binary.LittleEndian.Uint16(payload[0:])
+	state.Saturation = binary.LittleEndian.Uint16(payload[2:])
+	state.Brightness = binary.LittleEndian.Uint16(payload[4:])
+	state.Kelvin = binary.LittleEndian.Uint16(payload[6:])
+	state.Power = binary.LittleEndian.Uint16(payload[10:]) != 0
+	state.Label = lifxString(payload[12:44])
+	return state, nil
+}
+
+func (bridge *LIFXBridge) setColor(id int, state lifxBulbState, transitionTime time.Duration) error {
+	bulb, found := bridge.bulb(id)
+	if !found {
+		return fmt.Errorf("Unknown LIFX bulb %d", id)
+	}
+	payload := make([]byte, 13)
+	binary.LittleEndian.PutUint16(payload[1:], state.Hue)
+	binary.LittleEndian.PutUint16(payload[3:], state.Saturation)
+	binary.LittleEndian.PutUint16(payload[5:], state.Brightness)
+	binary.LittleEndian.PutUint16(payload[7:], state.Kelvin)
+	binary.LittleEndian.PutUint32(payload[9:], uint32(transitionTime/time.Millisecond))
+	_, err := bridge.exchange(bulb, lifxLightSetColor, payload, lifxAcknowledgement)
+	return err
+}
+
+func (bridge *LIFXBridge) setPower(id int, on bool, transitionTime time.Duration) error {
+	bulb, found := bridge.bulb(id)
+	if !found {
+		return fmt.Errorf("Unknown LIFX bulb %d", id)
+	}
+	payload := make([]byte, 6)
+	if on {
+		binary.LittleEndian.PutUint16(payload, math.MaxUint16)
+	}
+	binary.LittleEndian.PutUint32(payload[2:], uint32(transitionTime/time.Millisecond))
+	_, err := bridge.exchange(bulb, lifxLightSetPower, payload, lifxAcknowledgement)
+	return err
+}
+
+// bulb returns the discovered bulb with the given ID.
+func (bridge *LIFXBridge) bulb(id int) (lifxBulb, bool) {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	bulb, found := bridge.bulbs[id]
+	if !found {
+		return lifxBulb{}, false
+	}
+	return *bulb, true
+}
+
+// exchange sends a message to the given bulb and returns the payload of
+// the expected response. Lost messages are retried.
+func (bridge *LIFXBridge) exchange(bulb lifxBulb, messageType uint16, payload []byte, responseType uint16) ([]byte, error) {
+	bridge.exchangeMutex.Lock()
+	defer bridge.exchangeMutex.Unlock()
+
+	flags := byte(lifxFlagResRequired)
+	if responseType == lifxAcknowledgement {
+		flags = lifxFlagAckRequired
+	}
+
+	for attempt := 0; attempt < lifxRetries; attempt++ {
+		sequence := bridge.nextSequence()
+		_, err := bridge.conn.WriteToUDP(lifxPacket(bridge.source, bulb.target, sequence, flags, messageType, payload), bulb.address)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(lifxTimeout)
+		for {
+			header, response, _, err := bridge.receive(deadline)
+			if err != nil {
+				break // retry
+			}
+			if header.sequence == sequence && header.target == bulb.target && header.messageType == responseType {
+				return response, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No response from %s after %d attempts", bulb.address, lifxRetries)
+}
+
+// receive reads the next message addressed to this bridge.
+// The caller must hold the exchange mutex.
+func (bridge *LIFXBridge) receive(deadline time.Time) (lifxHeader, []byte, *net.UDPAddr, error) {
+	buffer := make([]byte, 1024)
+	for {
+		bridge.conn.SetReadDeadline(deadline)
+		n, from, err := bridge.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return lifxHeader{}, nil, nil, err
+		}
+		header, payload, err := parseLIFXPacket(buffer[:n])
+		if err != nil || header.source != bridge.source {
+			continue // not an answer to one of our messages
+		}
+		return header, payload, from, nil
+	}
+}
+
+// nextSequence returns the sequence number of the next message.
+// The caller must hold the exchange mutex.
+func (bridge *LIFXBridge) nextSequence() uint8 {
+	bridge.sequence++
+	return bridge.sequence
+}
+
+// lifxPacket encodes a message. If no target is given the message is
+// addressed to all bulbs.
+func lifxPacket(source uint32, target uint64, sequence uint8, flags byte, messageType uint16, payload []byte) []byte {
+	packet := make([]byte, lifxHeaderSize+len(payload))
+	binary.LittleEndian.PutUint16(packet[0:], uint16(len(packet)))
+	protocol := uint16(1024) | 1<<12 // protocol version and addressable flag
+	if target == 0 {
+		protocol |= 1 << 13 // tagged
+	}
+	binary.LittleEndian.PutUint16(packet[2:], protocol)
+	binary.LittleEndian.PutUint32(packet[4:], source)
+	binary.LittleEndian.PutUint64(packet[8:], target)
+	packet[22] = flags
+	packet[23] = sequence
+	binary.LittleEndian.PutUint16(packet[32:], messageType)
+	copy(packet[lifxHeaderSize:], payload)
+	return packet
+}
+
+func parseLIFXPacket(packet []byte) (lifxHeader, []byte, error) {
+	var header lifxHeader
+	if len(packet) < lifxHeaderSize {
+		return header, nil, errors.New("Message too short")
+	}
+	header.size = binary.LittleEndian.Uint16(packet[0:])
+	if int(header.size) < lifxHeaderSize || int(header.size) > len(packet) {
+		return header, nil, fmt.Errorf("Invalid message size %d", header.size)
+	}
+	header.source = binary.LittleEndian.Uint32(packet[4:])
+	header.target = binary.LittleEndian.Uint64(packet[8:])
+	header.sequence = packet[23]
+	header.messageType = binary.LittleEndian.Uint16(packet[32:])
+	return header, packet[lifxHeaderSize:header.size], nil
+}
+
+// lifxString decodes a zero terminated label.
+func lifxString(data []byte) string {
+	if index := bytes.IndexByte(data, 0); index != -1 {
+		data = data[:index]
+	}
+	return string(data)
+}
+
+// lifxMAC returns the MAC address contained in the given target.
+// lifxLightID returns the ID of the bulb with the given target. It consists
+// of the last three bytes of the MAC address, which are unique for all
+// bulbs made by LIFX and don't change when bulbs are added or offline.
+func lifxLightID(target uint64) int {
+	mac := make([]byte, 8)
+	binary.LittleEndian.PutUint64(mac, target)
+	return int(mac[3])<<16 | int(mac[4])<<8 | int(mac[5])
+}
+
+func lifxMAC(target uint64) string {
+	mac := make([]byte, 8)
+	binary.LittleEndian.PutUint64(mac, target)
+	return net.HardwareAddr(mac[:6]).String()
+}
+
+// lifxBrightness maps the given brightness in percent to the range of LIFX.
+func lifxBrightness(brightness int) int {
+	if brightness == -1 {
+		return -1
+	}
+	if brightness > 100 {
+		brightness = 100
+	} else if brightness < 0 {
+		brightness = 0
+	}
+	return int(math.Round(float64(brightness) / 100 * math.MaxUint16))
+}
+
+func (light *LIFXLight) name() string {
+	return light.Name
+}
+
+func (light *LIFXLight) isReachable() bool {
+	return light.Current.Reachable
+}
+
+func (light *LIFXLight) isOn() bool {
+	return light.Current.Reachable && light.Current.Power
+}
+
+func (light *LIFXLight) supportsColorTemperature() bool {
+	return true
+}
+
+func (light *LIFXLight) supportsBrightness() bool {
+	return true
+}
+
+// supportsColor returns false as Kelvin only uses the white spectrum of a
+// LIFX bulb.
+func (light *LIFXLight) supportsColor() bool {
+	return false
+}
+
+func (light *LIFXLight) colorTemperatureRange() (int, int) {
+	return lifxMinimumColorTemperature, lifxMaximumColorTemperature
+}
+
+func (light *LIFXLight) stateDescription() string {
+	return fmt.Sprintf("TargetColorTemperature: %d, CurrentColorTemperature: %d, CurrentSaturation: %d, TargetBrightness: %d, CurrentBrightness: %d", light.TargetColorTemperature, light.Current.Kelvin, light.Current.Saturation, light.TargetBrightness, light.Current.Brightness)
+}
+
+func (light *LIFXLight) observedState() DeviceState {
+	return light.Current
+}
+
+func (light *LIFXLight) updateCurrentLightState(state DeviceState) {
+	current, ok := state.(lifxBulbState)
+	if !ok {
+		log.Warningf("💡 Light %s - Ignoring unknown light state %+v", light.Name, state)
+		return
+	}
+	if current.Label != "" {
+		light.Name = current.Label
+	}
+	light.Current = current
+}
+
+// setLightState sets the given color temperature natively as LIFX bulbs
+// accept Kelvin values directly.
+func (light *LIFXLight) setLightState(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	colorTemperature = light.adjustColorTemperature(colorTemperature)
+	light.SetColorTemperature = colorTemperature
+	light.SetBrightness = brightness
+	light.TargetColorTemperature = colorTemperature
+	light.TargetBrightness = lifxBrightness(brightness)
+
+	if brightness == 0 {
+		// Target brightness zero should turn the light off.
+		err := light.bridge.setPower(light.ID, false, transitionTime)
+		if err != nil {
+			return err
+		}
+		light.Current.Power = false
+		return nil
+	}
+
+	state := light.Current
+	if colorTemperature != -1 {
+		state.Hue = 0
+		state.Saturation = 0
+		state.Kelvin = uint16(colorTemperature)
+	}
+	if brightness != -1 {
+		state.Brightness = uint16(light.TargetBrightness)
+	}
+	if state == light.Current {
+		return nil
+	}
+
+	log.Debugf("💡 Light %s - Setting color to %dK at brightness %d", light.Name, state.Kelvin, state.Brightness)
+	err := light.bridge.setColor(light.ID, state, transitionTime)
+	if err != nil {
+		return err
+	}
+	light.Current = state
+	return nil
+}
+
+// turnOn sets the given light state while the bulb is still off and
+// powers it on afterwards.
+func (light *LIFXLight) turnOn(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	err := light.setLightState(colorTemperature, brightness, 0)
+	if err != nil || brightness == 0 {
+		return err
+	}
+	err = light.bridge.setPower(light.ID, true, transitionTime)
+	if err != nil {
+		return err
+	}
+	light.Current.Power = true
+	return nil
+}
+
+func (light *LIFXLight) hasChanged() bool {
+	return light.hasColorTemperatureChanged() || light.hasBrightnessChanged()
+}
+
+func (light *LIFXLight) hasColorTemperatureChanged() bool {
+	if light.TargetColorTemperature != -1 {
+		if light.Current.Saturation != 0 {
+			log.Debugf("💡 Light %s - Color has changed! CurrentSaturation: %d, TargetColorTemperature: %dK", light.Name, light.Current.Saturation, light.TargetColorTemperature)
+			return true
+		}
+		if !equalsInt(light.TargetColorTemperature, int(light.Current.Kelvin), 10) {
+			log.Debugf("💡 Light %s - Color temperature has changed! CurrentColorTemperature: %dK, TargetColorTemperature: %dK", light.Name, light.Current.Kelvin, light.TargetColorTemperature)
+			return true
+		}
+	}
+	return false
+}
+
+func (light *LIFXLight) hasBrightnessChanged() bool {
+	if light.TargetBrightness != -1 && !equalsInt(light.TargetBrightness, int(light.Current.Brightness), lifxBrightness(1)) {
+		log.Debugf("💡 Light %s - Brightness has changed! CurrentBrightness: %d, TargetBrightness: %d (%d%%)", light.Name, light.Current.Brightness, light.TargetBrightness, light.SetBrightness)
+		return true
+	}
+	return false
+}
+
+func (light *LIFXLight) hasState(colorTemperature int, brightness int) bool {
+	if colorTemperature != -1 {
+		colorTemperature = light.adjustColorTemperature(colorTemperature)
+		if light.Current.Saturation != 0 || !equalsInt(int(light.Current.Kelvin), colorTemperature, 10) {
+			return false
+		}
+	}
+	if brightness != -1 && !equalsInt(int(light.Current.Brightness), lifxBrightness(brightness), lifxBrightness(1)) {
+		return false
+	}
+	return true
+}
+
+// adjustColorTemperature limits the given color temperature to the
+// range supported by LIFX bulbs.
+func (light *LIFXLight) adjustColorTemperature(colorTemperature int) int {
+	if colorTemperature == -1 {
+		return colorTemperature
+	}
+	if colorTemperature < lifxMinimumColorTemperature {
+		return lifxMinimumColorTemperature
+	}
+	if colorTemperature > lifxMaximumColorTemperature {
+		return lifxMaximumColorTemperature
+	}
+	return colorTemperature
+}
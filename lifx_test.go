@@ -0,0 +1,173 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLIFXBulb answers LIFX LAN messages like a single bulb would.
+type fakeLIFXBulb struct {
+	conn     *net.UDPConn
+	target   uint64
+	label    string
+	mutex    sync.Mutex
+	state    [8]byte // hue, saturation, brightness, kelvin
+	power    uint16
+	duration uint32
+}
+
+func newFakeLIFXBulb(t *testing.T, label string) *fakeLIFXBulb {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulb := &fakeLIFXBulb{conn: conn, target: 0x0000563412d573d0, label: label, power: 65535}
+	binary.LittleEndian.PutUint16(bulb.state[4:], 32768)
+	binary.LittleEndian.PutUint16(bulb.state[6:], 3500)
+	go bulb.serve()
+	return bulb
+}
+
+func (bulb *fakeLIFXBulb) serve() {
+	buffer := make([]byte, 1024)
+	for {
+		n, from, err := bulb.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+		header, payload, err := parseLIFXPacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+
+		bulb.mutex.Lock()
+		var responseType uint16
+		var response []byte
+		switch header.messageType {
+		case lifxGetService:
+			responseType = lifxStateService
+			response = make([]byte, 5)
+			response[0] = 1
+			binary.LittleEndian.PutUint32(response[1:], uint32(bulb.conn.LocalAddr().(*net.UDPAddr).Port))
+		case lifxGetGroup:
+			responseType = lifxStateGroup
+			response = make([]byte, 56)
+			copy(response[16:], "Office")
+		case lifxLightGet:
+			responseType = lifxLightState
+			response = make([]byte, 52)
+			copy(response, bulb.state[:])
+			binary.LittleEndian.PutUint16(response[10:], bulb.power)
+			copy(response[12:], bulb.label)
+		case lifxLightSetColor:
+			responseType = lifxAcknowledgement
+			copy(bulb.state[:], payload[1:9])
+			bulb.duration = binary.LittleEndian.Uint32(payload[9:])
+		case lifxLightSetPower:
+			responseType = lifxAcknowledgement
+			bulb.power = binary.LittleEndian.Uint16(payload)
+		}
+		bulb.mutex.Unlock()
+
+		if responseType != 0 {
+			bulb.conn.WriteToUDP(lifxPacket(header.source, bulb.target, header.sequence, 0, responseType, response), from)
+		}
+	}
+}
+
+func (bulb *fakeLIFXBulb) kelvin() int {
+	bulb.mutex.Lock()
+	defer bulb.mutex.Unlock()
+	return int(binary.LittleEndian.Uint16(bulb.state[6:]))
+}
+
+func TestLIFXBridge(t *testing.T) {
+	bulb := newFakeLIFXBulb(t, "Desk")
+	defer bulb.conn.Close()
+
+	configuration := &Configuration{Bridges: []Bridge{{Type: backendLIFX, IP: "127.0.0.1:" + strconv.Itoa(bulb.conn.LocalAddr().(*net.UDPAddr).Port)}}}
+	bridge := &LIFXBridge{}
+	err := bridge.InitializeBridge(context.Background(), configuration, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bridge.ID != lifxDefaultID || configuration.Bridges[0].ID != lifxDefaultID {
+		t.Errorf("Bridge has ID %s; want %s", bridge.ID, lifxDefaultID)
+	}
+
+	lights, err := bridge.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lights) != 1 || lights[0].ID != 0x123456 || lights[0].Name != "Desk" || !lights[0].On || !lights[0].Reachable {
+		t.Fatalf("Found lights %+v; want reachable light Desk", lights)
+	}
+	directory, _ := bridge.deviceDirectory()
+	if len(directory.Rooms["Office"]) != 1 {
+		t.Errorf("Directory contains rooms %v; want Office", directory.Rooms)
+	}
+
+	device := lights[0].Device.(*LIFXLight)
+	err = device.setLightState(2700, 80, 400*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bulb.kelvin() != 2700 || bulb.duration != 400 {
+		t.Errorf("Bulb was set to %dK within %dms; want 2700K within 400ms", bulb.kelvin(), bulb.duration)
+	}
+
+	states, err := bridge.LightStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lights[0].updateCurrentLightState(states[0x123456])
+	if !device.hasState(2700, 80) || device.hasChanged() {
+		t.Errorf("Light does not report the state it was set to: %s", device.stateDescription())
+	}
+
+	// Change the color temperature like an app would
+	bulb.mutex.Lock()
+	binary.LittleEndian.PutUint16(bulb.state[6:], 4000)
+	bulb.mutex.Unlock()
+	states, _ = bridge.LightStates()
+	lights[0].updateCurrentLightState(states[0x123456])
+	if !device.hasChanged() {
+		t.Errorf("Manual change was not detected: %s", device.stateDescription())
+	}
+
+	err = device.setLightState(2700, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states, _ = bridge.LightStates()
+	lights[0].updateCurrentLightState(states[0x123456])
+	if lights[0].On {
+		t.Errorf("Light is still on after setting brightness to zero")
+	}
+}
@@ -25,32 +25,43 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	hue "github.com/stefanwichmann/go.hue"
 )
 
 const initializationDuration = 3 * time.Second
 
 // Light represents a light kelvin can automate in your system.
 type Light struct {
-	ID               int        `json:"id"`
-	Name             string     `json:"name"`
-	HueLight         HueLight   `json:"-"`
-	TargetLightState LightState `json:"targetLightState,omitempty"`
-	Scheduled        bool       `json:"scheduled"`
-	Reachable        bool       `json:"reachable"`
-	On               bool       `json:"on"`
-	Tracking         bool       `json:"-"`
-	Automatic        bool       `json:"automatic"`
-	Initializing     bool       `json:"-"`
-	Schedule         Schedule   `json:"-"`
-	Interval         Interval   `json:"interval"`
-	Appearance       time.Time  `json:"-"`
+	ID                        int               `json:"id"`
+	BridgeID                  string            `json:"bridge"`
+	Name                      string            `json:"name"`
+	Device                    Device            `json:"-"`
+	TargetLightState          LightState        `json:"targetLightState,omitempty"`
+	Scheduled                 bool              `json:"scheduled"`
+	Reachable                 bool              `json:"reachable"`
+	On                        bool              `json:"on"`
+	Tracking                  bool              `json:"-"`
+	Automatic                 bool              `json:"automatic"`
+	AutomaticColorTemperature bool              `json:"automaticColorTemperature"`
+	AutomaticBrightness       bool              `json:"automaticBrightness"`
+	BrightnessOffset          *BrightnessOffset `json:"brightnessOffset,omitempty"`
+	Initializing              bool              `json:"-"`
+	Schedule                  Schedule          `json:"-"`
+	Interval                  Interval          `json:"interval"`
+	Appearance                time.Time         `json:"-"`
+	WakingUp                  bool              `json:"wakingUp"`
+	WakeUpCancelled           time.Time         `json:"-"`
+	FadingOut                 bool              `json:"fadingOut"`
+	FadeOutStart              time.Time         `json:"-"`
+	FadeOutStepEnd            time.Time         `json:"-"`
+	ResumeAt                  *time.Time        `json:"resumeAt,omitempty"`
+	TransitionEnd             time.Time         `json:"-"`
+	savedState                *lightRuntimeState
 }
 
-func (light *Light) updateCurrentLightState(attr hue.LightAttributes) error {
-	light.HueLight.updateCurrentLightState(attr)
-	light.Reachable = light.HueLight.Reachable
-	light.On = light.HueLight.On
+func (light *Light) updateCurrentLightState(state DeviceState) error {
+	light.Device.updateCurrentLightState(state)
+	light.Reachable = light.Device.isReachable()
+	light.On = light.Device.isOn()
 	return nil
 }
 
@@ -65,8 +76,11 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		if light.Tracking {
 			log.Printf("💡 Light %s - Light is no longer reachable. Clearing state...", light.Name)
 			light.Tracking = false
-			light.Automatic = false
+			light.setAutomatic(false)
+			light.BrightnessOffset = nil
 			light.Initializing = false
+			light.FadingOut = false
+			light.ResumeAt = nil
 			return false, nil
 		}
 
@@ -74,17 +88,35 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		return false, nil
 	}
 
+	// Wake-up alarms turn the light on even if it is switched off
+	if light.wakeUpActive(time.Now()) {
+		return light.wakeUp(transistionTime)
+	}
+	if light.WakingUp {
+		log.Printf("💡 Light %s - Wake-up finished. Continuing with schedule...", light.Name)
+		light.WakingUp = false
+	}
+
 	// If the light was turned off clean up
 	if !light.On {
 		if light.Tracking {
 			log.Printf("💡 Light %s - Light was turned off. Clearing state...", light.Name)
 			light.Tracking = false
-			light.Automatic = false
+			light.setAutomatic(false)
+			light.BrightnessOffset = nil
 			light.Initializing = false
+			light.FadingOut = false
+			light.ResumeAt = nil
 			return false, nil
 		}
 
 		// Ignore light because we are not tracking it.
+		light.savedState = nil
+		return false, nil
+	}
+
+	// Continue with the state saved before a restart
+	if !light.Tracking && light.restoreRuntimeState() {
 		return false, nil
 	}
 
@@ -98,19 +130,24 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		if light.Schedule.enableWhenLightsAppear {
 			log.Printf("💡 Light %s - Initializing state to %vK at %v%% brightness.", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
 
-			err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+			err := light.Device.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
 			if err != nil {
 				log.Debugf("💡 Light %s - Could not initialize light after %v", light.Name, time.Since(light.Appearance))
 				return true, err
 			}
 
-			light.Automatic = true
+			light.setAutomatic(true)
 			light.Initializing = true
 			log.Debugf("💡 Light %s - Light was initialized to %vK at %v%% brightness", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
 			return true, nil
 		}
 	}
 
+	// Resume automatic mode according to the policy of the schedule
+	if light.ResumeAt != nil && !time.Now().Before(*light.ResumeAt) {
+		return light.resume()
+	}
+
 	// Ignore light if it was changed manually
 	if !light.Automatic {
 		// return if we should ignore color temperature and brightness
@@ -119,13 +156,15 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		}
 
 		// if status == scene state --> Activate Kelvin
-		if light.HueLight.hasState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness) {
+		if light.Device.hasState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness) {
 			log.Printf("💡 Light %s - Detected matching target state. Activating Kelvin...", light.Name)
-			light.Automatic = true
+			light.setAutomatic(true)
+			light.BrightnessOffset = nil
+			light.ResumeAt = nil
 			light.Initializing = true
 
-			// set correct target lightstate on HueLight
-			err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+			// set correct target lightstate on device
+			err := light.Device.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
 			if err != nil {
 				return true, err
 			}
@@ -137,10 +176,16 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		return false, nil
 	}
 
+	// Fade out at the entries of the schedule configured to do so
+	if fadeOut := light.currentFadeOut(time.Now()); fadeOut != nil && light.AutomaticBrightness {
+		return light.fadeOut(fadeOut)
+	}
+	light.FadingOut = false
+
 	// Keep adjusting the light state for 10 seconds after the light appeared
 	if light.Initializing {
-		log.Debugf("💡 Light %s - Light in initialization for %v (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d)", light.Name, time.Since(light.Appearance), light.HueLight.TargetColorTemperature, light.HueLight.CurrentColorTemperature, light.HueLight.TargetColor, light.HueLight.CurrentColor, light.HueLight.TargetBrightness, light.HueLight.CurrentBrightness)
-		hasChanged := light.HueLight.hasChanged()
+		log.Debugf("💡 Light %s - Light in initialization for %v (%s)", light.Name, time.Since(light.Appearance), light.Device.stateDescription())
+		hasChanged := light.Device.hasChanged()
 
 		// Disable initialization phase if 10 seconds have passed and the light state has been adopted
 		if time.Now().After(light.Appearance.Add(initializationDuration)) && !hasChanged {
@@ -149,43 +194,95 @@ func (light *Light) update(transistionTime time.Duration) (bool, error) {
 		}
 
 		if hasChanged {
-			err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+			colorTemperature, brightness := light.automaticLightState()
+			err := light.Device.setLightState(colorTemperature, brightness, transistionTime)
 			if err != nil {
 				return true, err
 			}
-			log.Debugf("💡 Light %s - Adjusting light state to %vK at %v%% brightness (Initialization)", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
+			log.Debugf("💡 Light %s - Adjusting light state to %vK at %v%% brightness (Initialization)", light.Name, colorTemperature, brightness)
 			return true, nil
 		}
 
 		return false, nil
 	}
 
-	// Did the user manually change the light state?
-	if light.HueLight.hasChanged() {
+	// Wait for long transitions to finish
+	if time.Now().Before(light.TransitionEnd) {
+		return false, nil
+	}
+
+	// Did the user manually change the color temperature or brightness?
+	colorTemperatureChanged := light.AutomaticColorTemperature && light.Device.hasColorTemperatureChanged()
+	brightnessChanged := light.AutomaticBrightness && light.Device.hasBrightnessChanged()
+	if brightnessChanged && light.updateBrightnessOffset() {
+		brightnessChanged = false
+	}
+	if colorTemperatureChanged || brightnessChanged {
 		if log.GetLevel() == log.DebugLevel {
-			log.Debugf("💡 Light %s - Light state has been changed manually after %v (TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d)", light.Name, time.Since(light.Appearance), light.HueLight.TargetColorTemperature, light.HueLight.CurrentColorTemperature, light.HueLight.TargetColor, light.HueLight.CurrentColor, light.HueLight.TargetBrightness, light.HueLight.CurrentBrightness)
-		} else {
+			log.Debugf("💡 Light %s - Light state has been changed manually after %v (%s)", light.Name, time.Since(light.Appearance), light.Device.stateDescription())
+		}
+		if colorTemperatureChanged {
+			light.AutomaticColorTemperature = false
+		}
+		if brightnessChanged {
+			light.AutomaticBrightness = false
+		}
+
+		if !light.AutomaticColorTemperature && !light.AutomaticBrightness {
 			log.Printf("💡 Light %s - Light state has been changed manually. Disabling Kelvin...", light.Name)
+			light.disableAutomaticMode()
+			return false, nil
 		}
-		light.Automatic = false
-		return false, nil
+
+		// Keep adjusting the dimension which hasn't been changed
+		if colorTemperatureChanged {
+			log.Printf("💡 Light %s - Color temperature has been changed manually. Adjusting brightness only...", light.Name)
+		} else {
+			log.Printf("💡 Light %s - Brightness has been changed manually. Adjusting color temperature only...", light.Name)
+		}
+		light.scheduleResume()
 	}
 
 	// Update of lightstate needed?
-	if light.HueLight.hasState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness) {
+	colorTemperature, brightness := light.automaticLightState()
+	if light.Device.hasState(colorTemperature, brightness) {
 		return false, nil
 	}
 
 	// Light is turned on and in automatic state. Set target lightstate.
-	err := light.HueLight.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transistionTime)
+	err := light.Device.setLightState(colorTemperature, brightness, transistionTime)
 	if err != nil {
 		return true, err
 	}
 
-	log.Printf("💡 Light %s - Updated light state to %vK at %v%% brightness", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
+	log.Printf("💡 Light %s - Updated light state to %vK at %v%% brightness", light.Name, colorTemperature, brightness)
 	return true, nil
 }
 
+// setAutomatic enables or disables automatic mode for color temperature and
+// brightness at once. The brightness offset is kept, so a light resuming
+// automatic mode returns to the brightness chosen by the user.
+func (light *Light) setAutomatic(automatic bool) {
+	light.Automatic = automatic
+	light.AutomaticColorTemperature = automatic
+	light.AutomaticBrightness = automatic
+}
+
+// automaticLightState returns the target color temperature and brightness
+// of the light including its brightness offset. Values changed manually are
+// replaced by -1 to keep them.
+func (light *Light) automaticLightState() (int, int) {
+	colorTemperature := light.TargetLightState.ColorTemperature
+	if !light.AutomaticColorTemperature {
+		colorTemperature = -1
+	}
+	brightness := light.BrightnessOffset.apply(light.TargetLightState.Brightness)
+	if !light.AutomaticBrightness {
+		brightness = -1
+	}
+	return colorTemperature, brightness
+}
+
 func (light *Light) updateSchedule(schedule Schedule) {
 	light.Schedule = schedule
 	light.Scheduled = true
@@ -218,6 +315,9 @@ func (light *Light) updateTargetLightState() bool {
 
 	// Calculate the target lightstate from the interval
 	newLightState := light.Interval.calculateLightStateInInterval(time.Now())
+	if light.wakeUpActive(time.Now()) {
+		newLightState = light.Schedule.wakeUp.calculateLightStateInInterval(time.Now())
+	}
 
 	// Did the target light state change?
 	if newLightState.equals(light.TargetLightState) {
@@ -23,7 +23,9 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -66,6 +68,11 @@ func InitializeLocation(configuration *Configuration) (Geolocation, error) {
 		location.Longitude = configuration.Location.Longitude
 		log.Printf("🌍 Working with location %v, %v from configuration", location.Latitude, location.Longitude)
 	}
+
+	if _, err := configuration.Location.twilightAngle(); err != nil {
+		log.Warningf("🌍 %v - Falling back to %s", err, defaultTwilight)
+	}
+	log.Printf("🌍 Calculating sunrise and sunset based on %s", configuration.Location.TwilightDescription())
 	return location, nil
 }
 
@@ -102,22 +109,221 @@ func (location *Geolocation) updateByIP() error {
 	return nil
 }
 
+// twilightAngles maps the supported definitions of sunrise and sunset to
+// the elevation of the sun in degrees at these events.
+var twilightAngles = map[string]float64{
+	"official":     astrotime.SUNRISE,
+	"civil":        astrotime.CIVIL_DAWN,
+	"nautical":     astrotime.NAUTICAL_DAWN,
+	"astronomical": astrotime.ASTRONOMICAL_DAWN,
+	"golden hour":  astrotime.GOLDEN_HOUR,
+}
+
+const defaultTwilight = "golden hour"
+
+// twilightAngle returns the elevation of the sun in degrees which defines
+// sunrise and sunset for this location. Invalid definitions fall back to
+// the golden hour and return an error.
+func (location Location) twilightAngle() (float64, error) {
+	twilight := strings.ToLower(strings.TrimSpace(location.Twilight))
+	if twilight == "" {
+		return twilightAngles[defaultTwilight], nil
+	}
+	if twilight == "custom" {
+		if location.TwilightAngle < -90 || location.TwilightAngle > 90 {
+			return twilightAngles[defaultTwilight], fmt.Errorf("Invalid twilight angle %v", location.TwilightAngle)
+		}
+		return location.TwilightAngle, nil
+	}
+	angle, found := twilightAngles[twilight]
+	if !found {
+		return twilightAngles[defaultTwilight], fmt.Errorf("Unknown twilight definition %s", location.Twilight)
+	}
+	return angle, nil
+}
+
+// TwilightDescription returns a readable description of the definition of
+// sunrise and sunset used for this location.
+func (location Location) TwilightDescription() string {
+	angle, err := location.twilightAngle()
+	twilight := strings.ToLower(strings.TrimSpace(location.Twilight))
+	if err != nil || twilight == "" {
+		twilight = defaultTwilight
+	}
+	return fmt.Sprintf("%s (sun at %v°)", twilight, angle)
+}
+
 // CalculateSunset calculates the sunset for the given day based on
-// the configured position on earth.
-func CalculateSunset(date time.Time, latitude float64, longitude float64) time.Time {
-	// calculate start of day
+// the configured position on earth and definition of twilight.
+func CalculateSunset(date time.Time, location Location) time.Time {
+	return CalculateSunTimes(date, location).Sunset
+}
+
+// CalculateSunrise calculates the sunrise for the given day based on
+// the configured position on earth and definition of twilight.
+func CalculateSunrise(date time.Time, location Location) time.Time {
+	return CalculateSunTimes(date, location).Sunrise
+}
+
+// SunTimes contains all events of the sun on a given day schedule entries
+// can refer to. Sunrise and sunset follow the configured definition of
+// twilight while dawn and dusk mark the beginning and end of civil twilight.
+// Fallback is set if the sun doesn't rise or set on this day (polar day or
+// night) and sunrise and sunset have been replaced by fallback times.
+type SunTimes struct {
+	Dawn     time.Time
+	Sunrise  time.Time
+	Noon     time.Time
+	Sunset   time.Time
+	Dusk     time.Time
+	Fallback bool
+}
+
+// CalculateSunTimes calculates all events of the sun for the given day based
+// on the configured position on earth. If the sun doesn't cross the horizon
+// as defined by the twilight on this day, sunrise and sunset are replaced by
+// the configured fallback times or the times the sun passes its mean
+// elevation of the day, six hours before and after solar noon. Missing dawn
+// and dusk are replaced by sunrise and sunset.
+func CalculateSunTimes(date time.Time, location Location) SunTimes {
 	yr, mth, day := date.Date()
 	startOfDay := time.Date(yr, mth, day, 0, 0, 0, 0, date.Location())
 
-	return astrotime.CalcDusk(startOfDay, latitude, longitude, astrotime.GOLDEN_HOUR)
+	var sun SunTimes
+	sun.Noon = CalculateSolarNoon(date, location.Longitude)
+
+	angle, _ := location.twilightAngle()
+	sunrise, sunriseFound := location.sunEvent(startOfDay, angle, true)
+	sunset, sunsetFound := location.sunEvent(startOfDay, angle, false)
+	if sunriseFound && sunsetFound && sunrise.Before(sunset) {
+		sun.Sunrise, sun.Sunset = sunrise, sunset
+	} else {
+		sun.Sunrise, sun.Sunset = location.fallbackSunTimes(startOfDay, sun.Noon)
+		sun.Fallback = true
+	}
+
+	dawn, dawnFound := location.sunEvent(startOfDay, astrotime.CIVIL_DAWN, true)
+	dusk, duskFound := location.sunEvent(startOfDay, astrotime.CIVIL_DUSK, false)
+	if dawnFound && duskFound && !dawn.After(sun.Sunrise) && !dusk.Before(sun.Sunset) {
+		sun.Dawn, sun.Dusk = dawn, dusk
+	} else {
+		sun.Dawn, sun.Dusk = sun.Sunrise, sun.Sunset
+	}
+	return sun
 }
 
-// CalculateSunrise calculates the sunrise for the given day based on
-// the configured position on earth.
-func CalculateSunrise(date time.Time, latitude float64, longitude float64) time.Time {
-	// calculate start of day
+// sunEvent calculates the time the sun rises above or sets below the given
+// elevation on the day starting at startOfDay. It fails if the sun doesn't
+// cross this elevation on that day.
+func (location Location) sunEvent(startOfDay time.Time, angle float64, rising bool) (time.Time, bool) {
+	// astrotime corrects the refraction at the horizon
+	elevation := angle
+	if angle == astrotime.SUNRISE {
+		elevation = -0.833
+	}
+	noon := CalculateSolarNoon(startOfDay, location.Longitude)
+	highest := CalculateSolarElevation(noon, location.Latitude, location.Longitude)
+	lowest := CalculateSolarElevation(noon.Add(12*time.Hour), location.Latitude, location.Longitude)
+	if highest < elevation || lowest > elevation {
+		return startOfDay, false
+	}
+
+	var t time.Time
+	if rising {
+		t = astrotime.CalcDawn(startOfDay, location.Latitude, location.Longitude, angle)
+	} else {
+		t = astrotime.CalcDusk(startOfDay, location.Latitude, location.Longitude, angle)
+	}
+	// Close to the poles the event may move to the previous or next day
+	if t.Before(startOfDay) || !t.Before(startOfDay.AddDate(0, 0, 1)) {
+		return startOfDay, false
+	}
+	return t, true
+}
+
+// fallbackSunTimes returns the configured fallback times for sunrise and
+// sunset or six hours before and after solar noon.
+func (location Location) fallbackSunTimes(startOfDay time.Time, noon time.Time) (time.Time, time.Time) {
+	if location.FallbackSunrise != "" && location.FallbackSunset != "" {
+		sunrise, sunriseErr := timeOnDay(startOfDay, location.FallbackSunrise)
+		sunset, sunsetErr := timeOnDay(startOfDay, location.FallbackSunset)
+		if sunriseErr == nil && sunsetErr == nil && sunrise.Before(sunset) {
+			return sunrise, sunset
+		}
+		log.Warningf("🌍 Invalid fallback times %s - %s. Using solar noon instead...", location.FallbackSunrise, location.FallbackSunset)
+	}
+
+	endOfDay := startOfDay.AddDate(0, 0, 1).Add(-time.Minute)
+	sunrise := noon.Add(-6 * time.Hour)
+	if sunrise.Before(startOfDay) {
+		sunrise = startOfDay.Add(time.Minute)
+	}
+	sunset := noon.Add(6 * time.Hour)
+	if sunset.After(endOfDay) {
+		sunset = endOfDay
+	}
+	return sunrise, sunset
+}
+
+// CalculateSolarNoon calculates the time the sun reaches its highest
+// elevation on the given day at the given longitude.
+func CalculateSolarNoon(date time.Time, longitude float64) time.Time {
 	yr, mth, day := date.Date()
-	startOfDay := time.Date(yr, mth, day, 0, 0, 0, 0, date.Location())
+	midnight := time.Date(yr, mth, day, 0, 0, 0, 0, time.UTC)
+
+	// Use the equation of time of the approximate noon for better precision
+	noon := midnight.Add(time.Duration((720 - 4*longitude) * float64(time.Minute)))
+	_, equationOfTime := solarCoordinates(noon)
+	noon = midnight.Add(time.Duration((720 - 4*longitude - equationOfTime) * float64(time.Minute)))
+	return noon.In(date.Location())
+}
+
+// CalculateSolarElevation calculates the angle of the sun above the horizon
+// in degrees at the given time and position on earth. Negative values mean
+// the sun is below the horizon. Atmospheric refraction is ignored.
+func CalculateSolarElevation(t time.Time, latitude float64, longitude float64) float64 {
+	utc := t.UTC()
+	declination, equationOfTime := solarCoordinates(utc)
+
+	minutes := float64(utc.Hour()*60+utc.Minute()) + float64(utc.Second())/60
+	trueSolarTime := math.Mod(minutes+equationOfTime+4*longitude, 1440)
+	hourAngle := trueSolarTime/4 - 180
+
+	zenith := math.Acos(sinDeg(latitude)*sinDeg(declination) + cosDeg(latitude)*cosDeg(declination)*cosDeg(hourAngle))
+	return 90 - zenith*180/math.Pi
+}
+
+// solarCoordinates returns the declination of the sun in degrees and the
+// equation of time in minutes at the given time.
+func solarCoordinates(t time.Time) (float64, float64) {
+	julianDay := float64(t.Unix())/86400 + 2440587.5
+	julianCentury := (julianDay - 2451545) / 36525
+
+	meanLongitude := math.Mod(280.46646+julianCentury*(36000.76983+julianCentury*0.0003032), 360)
+	meanAnomaly := 357.52911 + julianCentury*(35999.05029-0.0001537*julianCentury)
+	eccentricity := 0.016708634 - julianCentury*(0.000042037+0.0000001267*julianCentury)
+	center := sinDeg(meanAnomaly)*(1.914602-julianCentury*(0.004817+0.000014*julianCentury)) +
+		sinDeg(2*meanAnomaly)*(0.019993-0.000101*julianCentury) +
+		sinDeg(3*meanAnomaly)*0.000289
+	omega := 125.04 - 1934.136*julianCentury
+	apparentLongitude := meanLongitude + center - 0.00569 - 0.00478*sinDeg(omega)
+	meanObliquity := 23 + (26+(21.448-julianCentury*(46.815+julianCentury*(0.00059-julianCentury*0.001813)))/60)/60
+	obliquity := meanObliquity + 0.00256*cosDeg(omega)
+	declination := math.Asin(sinDeg(obliquity)*sinDeg(apparentLongitude)) * 180 / math.Pi
+
+	y := math.Pow(math.Tan(obliquity/2*math.Pi/180), 2)
+	equationOfTime := 4 * 180 / math.Pi * (y*sinDeg(2*meanLongitude) -
+		2*eccentricity*sinDeg(meanAnomaly) +
+		4*eccentricity*y*sinDeg(meanAnomaly)*cosDeg(2*meanLongitude) -
+		0.5*y*y*sinDeg(4*meanLongitude) -
+		1.25*eccentricity*eccentricity*sinDeg(2*meanAnomaly))
+	return declination, equationOfTime
+}
+
+func sinDeg(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
 
-	return astrotime.CalcDawn(startOfDay, latitude, longitude, astrotime.GOLDEN_HOUR)
+func cosDeg(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
 }
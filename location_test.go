@@ -0,0 +1,169 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTwilightAngle(t *testing.T) {
+	tests := []struct {
+		location Location
+		angle    float64
+		valid    bool
+	}{
+		{Location{}, 6, true},
+		{Location{Twilight: "official"}, 0, true},
+		{Location{Twilight: "Civil"}, -6, true},
+		{Location{Twilight: "nautical"}, -12, true},
+		{Location{Twilight: "astronomical"}, -18, true},
+		{Location{Twilight: "golden hour"}, 6, true},
+		{Location{Twilight: "custom", TwilightAngle: -3.5}, -3.5, true},
+		{Location{Twilight: "custom", TwilightAngle: 120}, 6, false},
+		{Location{Twilight: "sundown"}, 6, false},
+	}
+	for _, test := range tests {
+		angle, err := test.location.twilightAngle()
+		if (err == nil) != test.valid {
+			t.Errorf("twilightAngle(%+v) returned error %v; want valid %v", test.location, err, test.valid)
+		}
+		if angle != test.angle {
+			t.Errorf("twilightAngle(%+v) = %v; want %v", test.location, angle, test.angle)
+		}
+	}
+}
+
+func TestTwilightAcrossSeasons(t *testing.T) {
+	berlin := Location{Latitude: 52.52, Longitude: 13.40}
+	// Astronomical twilight doesn't end during summer nights in Berlin
+	definitions := []string{"nautical", "civil", "official", "golden hour"}
+	days := []time.Time{
+		time.Date(2024, time.March, 20, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.June, 21, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.September, 22, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.December, 21, 0, 0, 0, 0, time.UTC),
+	}
+
+	civilTwilight := make(map[time.Month]time.Duration)
+	for _, day := range days {
+		// A larger depression angle leads to an earlier sunrise and a later sunset
+		var sunrise, sunset time.Time
+		for index, definition := range definitions {
+			location := berlin
+			location.Twilight = definition
+			currentSunrise := CalculateSunrise(day, location)
+			currentSunset := CalculateSunset(day, location)
+			if index > 0 && (!currentSunrise.After(sunrise) || !currentSunset.Before(sunset)) {
+				t.Errorf("%s sunrise and sunset (%v - %v) should lie within %s ones (%v - %v) on %v", definition, currentSunrise.Format("15:04"), currentSunset.Format("15:04"), definitions[index-1], sunrise.Format("15:04"), sunset.Format("15:04"), day.Format("Jan 2"))
+			}
+			sunrise, sunset = currentSunrise, currentSunset
+		}
+
+		official := berlin
+		official.Twilight = "official"
+		civil := berlin
+		civil.Twilight = "civil"
+		civilTwilight[day.Month()] = CalculateSunrise(day, official).Sub(CalculateSunrise(day, civil))
+	}
+
+	// Twilight lasts longer in summer than at the equinoxes
+	if civilTwilight[time.June] <= civilTwilight[time.March] || civilTwilight[time.June] <= civilTwilight[time.September] {
+		t.Errorf("Civil twilight should last longer in June than at the equinoxes: %v", civilTwilight)
+	}
+
+	// Official sunrise in Berlin: 02:43 UTC in June and 07:15 UTC in December
+	official := berlin
+	official.Twilight = "official"
+	expected := map[time.Month]time.Time{
+		time.June:     time.Date(2024, time.June, 21, 2, 43, 0, 0, time.UTC),
+		time.December: time.Date(2024, time.December, 21, 7, 15, 0, 0, time.UTC),
+	}
+	for month, sunrise := range expected {
+		calculated := CalculateSunrise(sunrise, official)
+		if difference := calculated.Sub(sunrise); difference > 3*time.Minute || difference < -3*time.Minute {
+			t.Errorf("Official sunrise in %v = %v; want %v", month, calculated.Format("15:04"), sunrise.Format("15:04"))
+		}
+	}
+}
+
+func TestPolarDayAndNight(t *testing.T) {
+	zone := time.FixedZone("CET", 60*60)
+	tromso := Location{Latitude: 69.65, Longitude: 18.96, Twilight: "official"}
+	days := []time.Time{
+		time.Date(2024, time.June, 21, 0, 0, 0, 0, zone),     // midnight sun
+		time.Date(2024, time.December, 21, 0, 0, 0, 0, zone), // polar night
+	}
+
+	for _, day := range days {
+		sun := CalculateSunTimes(day, tromso)
+		if !sun.Fallback {
+			t.Errorf("The sun should neither rise nor set in Tromsø on %v: %+v", day.Format("Jan 2"), sun)
+		}
+		if !sun.Sunrise.Before(sun.Noon) || !sun.Noon.Before(sun.Sunset) || sun.Sunrise.Day() != day.Day() || sun.Sunset.Day() != day.Day() {
+			t.Errorf("Fallback sun times in Tromsø on %v are invalid: %+v", day.Format("Jan 2"), sun)
+		}
+		if !sun.Dawn.Equal(sun.Sunrise) || !sun.Dusk.Equal(sun.Sunset) {
+			t.Errorf("Missing dawn and dusk in Tromsø on %v should equal sunrise and sunset: %+v", day.Format("Jan 2"), sun)
+		}
+
+		fixed := tromso
+		fixed.FallbackSunrise = "07:00"
+		fixed.FallbackSunset = "19:00"
+		sun = CalculateSunTimes(day, fixed)
+		if sun.Sunrise.Format("15:04") != "07:00" || sun.Sunset.Format("15:04") != "19:00" {
+			t.Errorf("Fixed fallback in Tromsø on %v = %v - %v; want 07:00 - 19:00", day.Format("Jan 2"), sun.Sunrise.Format("15:04"), sun.Sunset.Format("15:04"))
+		}
+
+		// Every moment of the day has to be covered by an interval
+		c := newTestConfiguration(tromso, LightSchedule{
+			Name:                    "polar",
+			AssociatedDeviceIDs:     []int{1},
+			DefaultColorTemperature: 4000,
+			DefaultBrightness:       100,
+			BeforeSunrise:           []TimedColorTemperature{{Time: "sunrise-1h", ColorTemperature: 2000, Brightness: 60}},
+			AfterSunset:             []TimedColorTemperature{{Time: "22:00", ColorTemperature: 2000, Brightness: 60}},
+		})
+		schedule := testScheduleForDay(t, &c, day)
+		for minute := 1; minute < 24*60; minute += 7 {
+			timestamp := day.Add(time.Duration(minute) * time.Minute)
+			interval, err := schedule.currentInterval(timestamp)
+			if err != nil {
+				t.Errorf("No interval in Tromsø at %v: %v", timestamp, err)
+				continue
+			}
+			if state := interval.calculateLightStateInInterval(timestamp); !state.isValid() {
+				t.Errorf("Invalid light state in Tromsø at %v: %+v", timestamp, state)
+			}
+		}
+	}
+
+	// Regular days are not affected
+	berlin := Location{Latitude: 52.52, Longitude: 13.40, Twilight: "official"}
+	if sun := CalculateSunTimes(days[0], berlin); sun.Fallback {
+		t.Errorf("The sun should rise and set in Berlin on %v: %+v", days[0].Format("Jan 2"), sun)
+	}
+	berlin.Twilight = "astronomical"
+	if sun := CalculateSunTimes(days[0], berlin); !sun.Fallback {
+		t.Errorf("Astronomical twilight should not end in Berlin on %v: %+v", days[0].Format("Jan 2"), sun)
+	}
+}
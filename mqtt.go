@@ -0,0 +1,336 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const mqttKeepAlive = 30 * time.Second
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnAck    = 2
+	mqttPublish    = 3
+	mqttPubAck     = 4
+	mqttSubscribe  = 8
+	mqttSubAck     = 9
+	mqttPingReq    = 12
+	mqttPingResp   = 13
+	mqttDisconnect = 14
+)
+
+// mqttMaxRemaining is the maximum remaining length of a single packet.
+const mqttMaxRemaining = 268435455
+
+// mqttClient is a minimal MQTT 3.1.1 client. It supports everything Kelvin
+// needs to talk to Zigbee2MQTT: Subscriptions and publishing with QoS 0.
+// Incoming messages are passed to the handler from the reading goroutine.
+type mqttClient struct {
+	address   string
+	clientID  string
+	username  string
+	password  string
+	handler   func(topic string, payload []byte)
+	keepAlive time.Duration
+	conn      net.Conn
+	done      chan struct{}
+	mutex     sync.Mutex
+	packetID  uint16
+}
+
+func newMQTTClient(address string, clientID string, username string, password string, handler func(topic string, payload []byte)) *mqttClient {
+	return &mqttClient{address: address, clientID: clientID, username: username, password: password, handler: handler, keepAlive: mqttKeepAlive}
+}
+
+// connect opens a new session with the broker. Addresses may be given as
+// host, host:port, tcp://host:port or ssl://host:port.
+func (client *mqttClient) connect() error {
+	address := client.address
+	secure := false
+	for _, scheme := range []string{"tcp://", "mqtt://", "ssl://", "tls://", "mqtts://"} {
+		if strings.HasPrefix(address, scheme) {
+			address = strings.TrimPrefix(address, scheme)
+			secure = scheme != "tcp://" && scheme != "mqtt://"
+		}
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		if secure {
+			address = net.JoinHostPort(address, "8883")
+		} else {
+			address = net.JoinHostPort(address, "1883")
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if secure {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Variable header: protocol name, level 4 (3.1.1), flags and keep alive
+	var packet []byte
+	packet = appendMQTTString(packet, "MQTT")
+	flags := byte(0x02) // clean session
+	if client.username != "" {
+		flags |= 0x80
+	}
+	if client.password != "" {
+		flags |= 0x40
+	}
+	packet = append(packet, 4, flags)
+	packet = appendUint16(packet, uint16(client.keepAlive/time.Second))
+	packet = appendMQTTString(packet, client.clientID)
+	if client.username != "" {
+		packet = appendMQTTString(packet, client.username)
+	}
+	if client.password != "" {
+		packet = appendMQTTString(packet, client.password)
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	err = writeMQTTPacket(conn, mqttConnect<<4, packet)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	header, payload, err := readMQTTPacket(reader)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if header>>4 != mqttConnAck || len(payload) != 2 {
+		conn.Close()
+		return fmt.Errorf("Unexpected response from MQTT broker %s", client.address)
+	}
+	if payload[1] != 0 {
+		conn.Close()
+		return fmt.Errorf("MQTT broker %s refused connection (Return code %d)", client.address, payload[1])
+	}
+	conn.SetDeadline(time.Time{})
+
+	client.mutex.Lock()
+	client.conn = conn
+	client.done = make(chan struct{})
+	client.mutex.Unlock()
+
+	go client.read(conn, reader, client.done)
+	go client.ping(conn, client.done)
+	log.Debugf("⌘ Connected to MQTT broker %s", client.address)
+	return nil
+}
+
+// Done returns a channel which is closed as soon as the connection is lost.
+func (client *mqttClient) Done() <-chan struct{} {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.done
+}
+
+func (client *mqttClient) close() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn != nil {
+		writeMQTTPacket(client.conn, mqttDisconnect<<4, nil)
+		client.conn.Close()
+	}
+}
+
+// subscribe subscribes to the given topic filter with QoS 0.
+func (client *mqttClient) subscribe(topic string) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn == nil {
+		return errors.New("Not connected to MQTT broker")
+	}
+	client.packetID++
+	if client.packetID == 0 {
+		client.packetID = 1
+	}
+	packet := appendUint16(nil, client.packetID)
+	packet = appendMQTTString(packet, topic)
+	packet = append(packet, 0) // QoS 0
+	return writeMQTTPacket(client.conn, mqttSubscribe<<4|0x02, packet)
+}
+
+// publish sends the payload to the given topic with QoS 0.
+func (client *mqttClient) publish(topic string, payload []byte) error {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.conn == nil {
+		return errors.New("Not connected to MQTT broker")
+	}
+	packet := appendMQTTString(nil, topic)
+	packet = append(packet, payload...)
+	return writeMQTTPacket(client.conn, mqttPublish<<4, packet)
+}
+
+func (client *mqttClient) read(conn net.Conn, reader *bufio.Reader, done chan struct{}) {
+	defer close(done)
+	defer conn.Close()
+	for {
+		// The broker answers our pings, so we should hear from it regularly
+		conn.SetReadDeadline(time.Now().Add(2 * client.keepAlive))
+		header, payload, err := readMQTTPacket(reader)
+		if err != nil {
+			log.Debugf("⌘ Connection to MQTT broker %s lost: %v", client.address, err)
+			return
+		}
+
+		switch header >> 4 {
+		case mqttPublish:
+			topic, message, packetID, err := parseMQTTPublish(header, payload)
+			if err != nil {
+				log.Debugf("⌘ Ignoring invalid MQTT message: %v", err)
+				continue
+			}
+			if header&0x06 == 0x02 { // QoS 1
+				client.mutex.Lock()
+				writeMQTTPacket(conn, mqttPubAck<<4, appendUint16(nil, packetID))
+				client.mutex.Unlock()
+			}
+			client.handler(topic, message)
+		case mqttSubAck, mqttPingResp:
+			// nothing to do
+		default:
+			log.Debugf("⌘ Ignoring unexpected MQTT packet of type %d", header>>4)
+		}
+	}
+}
+
+func (client *mqttClient) ping(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(client.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			client.mutex.Lock()
+			err := writeMQTTPacket(conn, mqttPingReq<<4, nil)
+			client.mutex.Unlock()
+			if err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func parseMQTTPublish(header byte, payload []byte) (string, []byte, uint16, error) {
+	if len(payload) < 2 {
+		return "", nil, 0, errors.New("Message too short")
+	}
+	length := int(binary.BigEndian.Uint16(payload))
+	if len(payload) < 2+length {
+		return "", nil, 0, errors.New("Invalid topic length")
+	}
+	topic := string(payload[2 : 2+length])
+	payload = payload[2+length:]
+
+	var packetID uint16
+	if header&0x06 != 0 {
+		if len(payload) < 2 {
+			return "", nil, 0, errors.New("Missing packet identifier")
+		}
+		packetID = binary.BigEndian.Uint16(payload)
+		payload = payload[2:]
+	}
+	return topic, payload, packetID, nil
+}
+
+func writeMQTTPacket(writer io.Writer, header byte, payload []byte) error {
+	if len(payload) > mqttMaxRemaining {
+		return errors.New("MQTT packet too large")
+	}
+	packet := []byte{header}
+	length := len(payload)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	_, err := writer.Write(append(packet, payload...))
+	return err
+}
+
+func readMQTTPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	multiplier := 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("Malformed remaining length")
+		}
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	return header, payload, err
+}
+
+func appendMQTTString(packet []byte, value string) []byte {
+	packet = appendUint16(packet, uint16(len(value)))
+	return append(packet, value...)
+}
+
+func appendUint16(packet []byte, value uint16) []byte {
+	return append(packet, byte(value>>8), byte(value))
+}
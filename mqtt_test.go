@@ -0,0 +1,348 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts a single MQTT connection and reports every packet it receives.
+func fakeBroker(t *testing.T, packets chan<- []byte, outgoing <-chan []byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for packet := range outgoing {
+				conn.Write(packet)
+			}
+		}()
+		reader := bufio.NewReader(conn)
+		for {
+			header, payload, err := readMQTTPacket(reader)
+			if err != nil {
+				close(packets)
+				return
+			}
+			if header>>4 == mqttConnect {
+				conn.Write([]byte{mqttConnAck << 4, 2, 0, 0})
+			}
+			packets <- append([]byte{header}, payload...)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestMQTTClient(t *testing.T) {
+	packets := make(chan []byte, 10)
+	outgoing := make(chan []byte, 10)
+	defer close(outgoing)
+	address := fakeBroker(t, packets, outgoing)
+
+	messages := make(chan string, 10)
+	client := newMQTTClient("tcp://"+address, "kelvin-test", "user", "secret", func(topic string, payload []byte) {
+		messages <- topic + " " + string(payload)
+	})
+	err := client.connect()
+	if err != nil {
+		t.Fatalf("Could not connect to fake broker: %v", err)
+	}
+	defer client.close()
+
+	connect := <-packets
+	if connect[0]>>4 != mqttConnect || connect[7] != 4 || connect[8] != 0xC2 {
+		t.Errorf("Invalid CONNECT packet: %v", connect)
+	}
+
+	err = client.subscribe("zigbee2mqtt/#")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscribe := <-packets
+	if subscribe[0] != mqttSubscribe<<4|0x02 || string(subscribe[5:18]) != "zigbee2mqtt/#" {
+		t.Errorf("Invalid SUBSCRIBE packet: %v", subscribe)
+	}
+
+	err = client.publish("zigbee2mqtt/Desk/set", []byte(`{"brightness":254}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	publish := <-packets
+	topic, payload, _, err := parseMQTTPublish(publish[0], publish[1:])
+	if err != nil || topic != "zigbee2mqtt/Desk/set" || string(payload) != `{"brightness":254}` {
+		t.Errorf("Invalid PUBLISH packet: %v", publish)
+	}
+
+	// Deliver a message with QoS 1 which has to be acknowledged
+	var packet []byte
+	packet = appendMQTTString(packet, "zigbee2mqtt/Desk")
+	packet = appendUint16(packet, 42)
+	packet = append(packet, `{"state":"ON"}`...)
+	var buffer bytes.Buffer
+	writeMQTTPacket(&buffer, mqttPublish<<4|0x02, packet)
+	outgoing <- buffer.Bytes()
+
+	select {
+	case message := <-messages:
+		if message != `zigbee2mqtt/Desk {"state":"ON"}` {
+			t.Errorf("Received unexpected message %s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message was not delivered to handler")
+	}
+	acknowledge := <-packets
+	if acknowledge[0] != mqttPubAck<<4 || acknowledge[1] != 0 || acknowledge[2] != 42 {
+		t.Errorf("Invalid PUBACK packet: %v", acknowledge)
+	}
+}
+
+func TestMQTTRemainingLength(t *testing.T) {
+	for _, length := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		var buffer bytes.Buffer
+		err := writeMQTTPacket(&buffer, mqttPublish<<4, make([]byte, length))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, payload, err := readMQTTPacket(bufio.NewReader(&buffer))
+		if err != nil || len(payload) != length {
+			t.Errorf("Packet with %d bytes was read as %d bytes: %v", length, len(payload), err)
+		}
+	}
+}
+
+// newMQTTTestBroker accepts any number of connections and lets serve handle
+// each of them. Connections are closed as soon as serve returns.
+func newMQTTTestBroker(t *testing.T, serve func(conn net.Conn, reader *bufio.Reader)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn, bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// acceptMQTTSession reads the CONNECT packet of a client and answers it
+// with the given return code.
+func acceptMQTTSession(conn net.Conn, reader *bufio.Reader, returnCode byte) bool {
+	header, _, err := readMQTTPacket(reader)
+	if err != nil || header>>4 != mqttConnect {
+		return false
+	}
+	return writeMQTTPacket(conn, mqttConnAck<<4, []byte{0, returnCode}) == nil
+}
+
+func TestMQTTConnectRefused(t *testing.T) {
+	address := newMQTTTestBroker(t, func(conn net.Conn, reader *bufio.Reader) {
+		acceptMQTTSession(conn, reader, 5) // not authorized
+	})
+
+	client := newMQTTClient("tcp://"+address, "kelvin-test", "user", "wrong", func(string, []byte) {})
+	err := client.connect()
+	if err == nil || !strings.Contains(err.Error(), "Return code 5") {
+		t.Errorf("Connecting with wrong credentials returned %v; want refused connection", err)
+	}
+}
+
+func TestMQTTFragmentedPackets(t *testing.T) {
+	long := strings.Repeat("x", 300) // needs two bytes of remaining length
+	address := newMQTTTestBroker(t, func(conn net.Conn, reader *bufio.Reader) {
+		if !acceptMQTTSession(conn, reader, 0) {
+			return
+		}
+		var buffer bytes.Buffer
+		for _, message := range []string{"first", "second", long} {
+			writeMQTTPacket(&buffer, mqttPublish<<4, append(appendMQTTString(nil, "zigbee2mqtt/Desk"), message...))
+		}
+		stream := buffer.Bytes()
+		third := len(stream) - (1 + 2 + 2 + len("zigbee2mqtt/Desk") + len(long))
+
+		// Two packets in a single write, the third one split within its
+		// remaining length and its payload
+		for _, chunk := range [][]byte{stream[:third], stream[third : third+2], stream[third+2 : third+20], stream[third+20:]} {
+			conn.Write(chunk)
+			time.Sleep(10 * time.Millisecond)
+		}
+		readMQTTPacket(reader) // wait for DISCONNECT
+	})
+
+	messages := make(chan string, 10)
+	client := newMQTTClient("tcp://"+address, "kelvin-test", "", "", func(topic string, payload []byte) {
+		messages <- topic + " " + string(payload)
+	})
+	err := client.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+
+	for _, expected := range []string{"first", "second", long} {
+		select {
+		case message := <-messages:
+			if message != "zigbee2mqtt/Desk "+expected {
+				t.Errorf("Received message %q; want %q", message, "zigbee2mqtt/Desk "+expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Message %.10s was not delivered to handler", expected)
+		}
+	}
+}
+
+func TestMQTTKeepAlive(t *testing.T) {
+	answer := int32(1)
+	pings := make(chan struct{}, 100)
+	address := newMQTTTestBroker(t, func(conn net.Conn, reader *bufio.Reader) {
+		if !acceptMQTTSession(conn, reader, 0) {
+			return
+		}
+		for {
+			header, _, err := readMQTTPacket(reader)
+			if err != nil {
+				return
+			}
+			if header>>4 == mqttPingReq {
+				pings <- struct{}{}
+				if atomic.LoadInt32(&answer) == 1 {
+					writeMQTTPacket(conn, mqttPingResp<<4, nil)
+				}
+			}
+		}
+	})
+
+	client := newMQTTClient("tcp://"+address, "kelvin-test", "", "", func(string, []byte) {})
+	client.keepAlive = 50 * time.Millisecond
+	err := client.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.close()
+
+	// Answered pings keep the connection alive for longer than the timeout
+	for i := 0; i < 6; i++ {
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Client did not send PINGREQ")
+		}
+	}
+	select {
+	case <-client.Done():
+		t.Fatal("Connection was closed although the broker answered all pings")
+	default:
+	}
+
+	// Without PINGRESP the client has to give up the connection
+	atomic.StoreInt32(&answer, 0)
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection was not closed although the broker stopped answering pings")
+	}
+}
+
+func TestMQTTReconnect(t *testing.T) {
+	connections := int32(0)
+	subscriptions := make(chan string, 10)
+	address := newMQTTTestBroker(t, func(conn net.Conn, reader *bufio.Reader) {
+		connection := atomic.AddInt32(&connections, 1)
+		if !acceptMQTTSession(conn, reader, 0) {
+			return
+		}
+		for {
+			header, payload, err := readMQTTPacket(reader)
+			if err != nil {
+				return
+			}
+			if header>>4 == mqttSubscribe {
+				subscriptions <- string(payload[4 : len(payload)-1])
+				if connection == 1 {
+					return // drop the first session
+				}
+			}
+		}
+	})
+
+	bridge := &Zigbee2MQTTBridge{ID: "zigbee2mqtt", Broker: "tcp://" + address, BaseTopic: "zigbee2mqtt"}
+	bridge.client = newMQTTClient(bridge.Broker, "kelvin-test", "", "", bridge.handleMessage)
+	err := bridge.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bridge.close()
+
+	for connection := 1; connection <= 2; connection++ {
+		select {
+		case topic := <-subscriptions:
+			if topic != "zigbee2mqtt/#" {
+				t.Errorf("Session %d subscribed to %s; want zigbee2mqtt/#", connection, topic)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Session %d did not subscribe", connection)
+		}
+		if connection == 2 {
+			break
+		}
+
+		select {
+		case <-bridge.client.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Lost connection was not detected")
+		}
+		err = bridge.connect()
+		if err != nil {
+			t.Fatalf("Could not reconnect: %v", err)
+		}
+	}
+
+	if !bridge.IsConnected() {
+		t.Errorf("Bridge should be connected again")
+	}
+	select {
+	case <-bridge.client.Done():
+		t.Errorf("New session should not be closed")
+	default:
+	}
+}
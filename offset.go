@@ -0,0 +1,138 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported modes to keep a brightness chosen by the user relative to the
+// schedule.
+const (
+	brightnessOffsetNone     = "none"
+	brightnessOffsetAbsolute = "absolute"
+	brightnessOffsetRelative = "relative"
+)
+
+var brightnessOffsetModes = []string{brightnessOffsetNone, brightnessOffsetAbsolute, brightnessOffsetRelative}
+
+// BrightnessOffset describes how far the brightness of a light was changed
+// manually away from its schedule. Absolute offsets are percentage points,
+// relative offsets are a factor.
+type BrightnessOffset struct {
+	Mode  string  `json:"mode"`
+	Value float64 `json:"value"`
+}
+
+// brightnessReader is implemented by devices able to report the brightness
+// the light currently has.
+type brightnessReader interface {
+	getCurrentBrightness() (int, error)
+}
+
+// brightnessOffsetMode returns the normalized brightness offset mode of
+// this schedule. Unknown modes are reported and replaced by
+// brightnessOffsetNone.
+func (schedule *LightSchedule) brightnessOffsetMode() (string, error) {
+	mode := strings.ToLower(strings.TrimSpace(schedule.BrightnessOffset))
+	if mode == "" {
+		return brightnessOffsetNone, nil
+	}
+	if !containsString(brightnessOffsetModes, mode) {
+		return brightnessOffsetNone, fmt.Errorf("Unknown brightness offset %s", schedule.BrightnessOffset)
+	}
+	return mode, nil
+}
+
+// newBrightnessOffset returns the offset between the target brightness of
+// the schedule and the brightness chosen by the user.
+func newBrightnessOffset(mode string, target int, current int) (*BrightnessOffset, error) {
+	if target <= 0 || current <= 0 {
+		return nil, fmt.Errorf("Can't derive offset from %d%% to %d%%", target, current)
+	}
+	switch mode {
+	case brightnessOffsetAbsolute:
+		return &BrightnessOffset{Mode: mode, Value: float64(current - target)}, nil
+	case brightnessOffsetRelative:
+		return &BrightnessOffset{Mode: mode, Value: math.Round(float64(current)/float64(target)*100) / 100}, nil
+	}
+	return nil, fmt.Errorf("Brightness offsets are disabled")
+}
+
+// apply returns the given brightness of the schedule with the offset
+// applied. Lights are never turned off or ignored because of an offset.
+func (offset *BrightnessOffset) apply(brightness int) int {
+	if offset == nil || brightness <= 0 {
+		return brightness
+	}
+
+	if offset.Mode == brightnessOffsetRelative {
+		brightness = int(math.Round(float64(brightness) * offset.Value))
+	} else {
+		brightness += int(offset.Value)
+	}
+	if brightness < 1 {
+		return 1
+	}
+	if brightness > 100 {
+		return 100
+	}
+	return brightness
+}
+
+func (offset *BrightnessOffset) String() string {
+	if offset.Mode == brightnessOffsetRelative {
+		return fmt.Sprintf("×%.2f", offset.Value)
+	}
+	return fmt.Sprintf("%+.0f points", offset.Value)
+}
+
+// updateBrightnessOffset derives a new brightness offset after the user
+// changed the brightness manually. It returns false if the schedule doesn't
+// use offsets or the current brightness of the light is unknown.
+func (light *Light) updateBrightnessOffset() bool {
+	if light.Schedule.brightnessOffset == brightnessOffsetNone {
+		return false
+	}
+	device, ok := light.Device.(brightnessReader)
+	if !ok {
+		return false
+	}
+	current, err := device.getCurrentBrightness()
+	if err != nil {
+		log.Debugf("💡 Light %s - Could not determine brightness offset: %v", light.Name, err)
+		return false
+	}
+	offset, err := newBrightnessOffset(light.Schedule.brightnessOffset, light.TargetLightState.Brightness, current)
+	if err != nil {
+		log.Debugf("💡 Light %s - Could not determine brightness offset: %v", light.Name, err)
+		return false
+	}
+
+	light.BrightnessOffset = offset
+	log.Printf("💡 Light %s - Brightness has been changed manually to %v%%. Following the schedule with an offset of %v...", light.Name, current, offset)
+	return true
+}
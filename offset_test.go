@@ -0,0 +1,100 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+)
+
+func TestBrightnessOffset(t *testing.T) {
+	tests := []struct {
+		mode     string
+		target   int
+		current  int
+		schedule int
+		expected int
+	}{
+		{brightnessOffsetAbsolute, 80, 40, 60, 20},
+		{brightnessOffsetAbsolute, 80, 40, 30, 1},
+		{brightnessOffsetAbsolute, 40, 80, 100, 100},
+		{brightnessOffsetAbsolute, 80, 40, 0, 0},
+		{brightnessOffsetAbsolute, 80, 40, -1, -1},
+		{brightnessOffsetRelative, 80, 40, 60, 30},
+		{brightnessOffsetRelative, 80, 40, 1, 1},
+		{brightnessOffsetRelative, 50, 100, 80, 100},
+	}
+	for _, test := range tests {
+		offset, err := newBrightnessOffset(test.mode, test.target, test.current)
+		if err != nil {
+			t.Fatalf("newBrightnessOffset(%s, %d, %d) returned error: %v", test.mode, test.target, test.current, err)
+		}
+		if brightness := offset.apply(test.schedule); brightness != test.expected {
+			t.Errorf("Offset %v applied to %d%% = %d%%; want %d%%", offset, test.schedule, brightness, test.expected)
+		}
+	}
+
+	if _, err := newBrightnessOffset(brightnessOffsetNone, 80, 40); err == nil {
+		t.Errorf("Offsets should be disabled for mode %s", brightnessOffsetNone)
+	}
+	if _, err := newBrightnessOffset(brightnessOffsetRelative, 0, 40); err == nil {
+		t.Errorf("Relative offsets can't be derived from 0%%")
+	}
+	var offset *BrightnessOffset
+	if brightness := offset.apply(60); brightness != 60 {
+		t.Errorf("Missing offset changed brightness to %d%%", brightness)
+	}
+}
+
+func TestUpdateBrightnessOffset(t *testing.T) {
+	device := &HueLight{Name: "Desk", Dimmable: true}
+	device.SetBrightness, device.TargetBrightness = 80, mapBrightness(80)
+	device.CurrentBrightness = mapBrightness(40) + 1 // dimmed by the user
+	light := &Light{Name: "Desk", Device: device, TargetLightState: LightState{2700, 80}}
+	light.setAutomatic(true)
+
+	light.Schedule.brightnessOffset = brightnessOffsetNone
+	if light.updateBrightnessOffset() || light.BrightnessOffset != nil {
+		t.Errorf("Brightness offset should be disabled: %+v", light.BrightnessOffset)
+	}
+
+	light.Schedule.brightnessOffset = brightnessOffsetAbsolute
+	if !light.updateBrightnessOffset() || light.BrightnessOffset == nil || light.BrightnessOffset.Value != -40 {
+		t.Fatalf("Expected an offset of -40 points, got %+v", light.BrightnessOffset)
+	}
+
+	// The offset follows the schedule and is kept to resume automatic mode
+	light.TargetLightState.Brightness = 60
+	if _, brightness := light.automaticLightState(); brightness != 20 {
+		t.Errorf("Expected brightness of 20%% with offset, got %d%%", brightness)
+	}
+	light.setAutomatic(false)
+	if light.BrightnessOffset == nil {
+		t.Errorf("Brightness offset should be kept while automatic mode is disabled")
+	}
+
+	// Turning the light off clears the offset
+	light.Scheduled, light.Reachable, light.Tracking = true, true, true
+	light.update(0)
+	if light.BrightnessOffset != nil {
+		t.Errorf("Brightness offset should be cleared after the light was turned off: %+v", light.BrightnessOffset)
+	}
+}
@@ -0,0 +1,119 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Supported policies to resume automatic mode after a light has been
+// changed manually.
+const (
+	resumeNever     = "never"
+	resumeTimeout   = "timeout"
+	resumeNextEntry = "next entry"
+	resumeSunEvent  = "sunrise or sunset"
+)
+
+var resumePolicies = []string{resumeNever, resumeTimeout, resumeNextEntry, resumeSunEvent}
+
+const defaultResumeTimeout = 60 * time.Minute
+
+// A resumed light fades back to its target light state.
+const resumeTransitionTime = 10 * time.Second
+
+// resumePolicy returns the normalized resume policy of this schedule.
+// Unknown policies are reported and replaced by resumeNever.
+func (schedule *LightSchedule) resumePolicy() (string, error) {
+	policy := strings.ToLower(strings.TrimSpace(schedule.ResumePolicy))
+	if policy == "" {
+		return resumeNever, nil
+	}
+	if !containsString(resumePolicies, policy) {
+		return resumeNever, fmt.Errorf("Unknown resume policy %s", schedule.ResumePolicy)
+	}
+	return policy, nil
+}
+
+// nextResume returns the time a light changed manually at the given time
+// resumes automatic mode. It returns false if the light should stay manual.
+func (schedule *Schedule) nextResume(timestamp time.Time) (time.Time, bool) {
+	switch schedule.resumePolicy {
+	case resumeTimeout:
+		return timestamp.Add(schedule.resumeTimeout), true
+	case resumeNextEntry:
+		for _, candidate := range schedule.timeline {
+			if candidate.Time.After(timestamp) {
+				return candidate.Time, true
+			}
+		}
+	case resumeSunEvent:
+		// The timeline ends with the sunrise of the next day
+		candidates := []time.Time{schedule.sunrise.Time, schedule.sunset.Time}
+		if len(schedule.timeline) > 0 {
+			candidates = append(candidates, schedule.timeline[len(schedule.timeline)-1].Time)
+		}
+		for _, candidate := range candidates {
+			if candidate.After(timestamp) {
+				return candidate, true
+			}
+		}
+	}
+	return timestamp, false
+}
+
+// disableAutomaticMode stops Kelvin from changing the light after a manual
+// change until it resumes according to the policy of its schedule.
+func (light *Light) disableAutomaticMode() {
+	light.setAutomatic(false)
+	light.scheduleResume()
+}
+
+// scheduleResume determines when a light changed manually resumes
+// automatic mode for all of its values.
+func (light *Light) scheduleResume() {
+	light.ResumeAt = nil
+	resumeAt, ok := light.Schedule.nextResume(time.Now())
+	if ok {
+		light.ResumeAt = &resumeAt
+		log.Printf("💡 Light %s - Resuming automatic mode at %v", light.Name, resumeAt.Format("Jan 2 15:04"))
+	}
+}
+
+// resume enables automatic mode again and fades the light to its target
+// light state including the brightness offset chosen by the user.
+func (light *Light) resume() (bool, error) {
+	light.ResumeAt = nil
+	light.setAutomatic(true)
+	colorTemperature, brightness := light.automaticLightState()
+	log.Printf("💡 Light %s - Resuming automatic mode. Fading to %vK at %v%% brightness...", light.Name, colorTemperature, brightness)
+	err := light.Device.setLightState(colorTemperature, brightness, resumeTransitionTime)
+	if err != nil {
+		return true, err
+	}
+	light.TransitionEnd = time.Now().Add(resumeTransitionTime)
+	return true, nil
+}
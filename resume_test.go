@@ -0,0 +1,99 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextResume(t *testing.T) {
+	zone := time.FixedZone("CET", 60*60)
+	date := time.Date(2024, time.March, 10, 0, 0, 0, 0, zone)
+
+	c := newTestConfiguration(Location{Latitude: 52.52, Longitude: 13.40}, LightSchedule{
+		Name:                    "resume",
+		AssociatedDeviceIDs:     []int{1},
+		DefaultColorTemperature: 4000,
+		DefaultBrightness:       100,
+		AfterSunset:             []TimedColorTemperature{{Time: "20:00", ColorTemperature: 2700, Brightness: 80}, {Time: "22:00", ColorTemperature: 2000, Brightness: 40}},
+	})
+	changed := time.Date(2024, time.March, 10, 20, 30, 0, 0, zone)
+
+	tests := []struct {
+		policy   string
+		timeout  int
+		expected time.Time
+		resumes  bool
+	}{
+		{"", 0, time.Time{}, false},
+		{"never", 0, time.Time{}, false},
+		{"timeout", 0, changed.Add(defaultResumeTimeout), true},
+		{"Timeout", 15, changed.Add(15 * time.Minute), true},
+		{"next entry", 0, time.Date(2024, time.March, 10, 22, 0, 0, 0, zone), true},
+		{"unknown", 0, time.Time{}, false},
+	}
+	for _, test := range tests {
+		c.Schedules[0].ResumePolicy = test.policy
+		c.Schedules[0].ResumeTimeout = test.timeout
+		schedule := testScheduleForDay(t, &c, date)
+		resumeAt, ok := schedule.nextResume(changed)
+		if ok != test.resumes || (ok && !resumeAt.Equal(test.expected)) {
+			t.Errorf("nextResume with policy %q = %v, %v; want %v, %v", test.policy, resumeAt, ok, test.expected, test.resumes)
+		}
+	}
+
+	// Lights changed in the evening resume at the next sunrise
+	c.Schedules[0].ResumePolicy = "sunrise or sunset"
+	schedule := testScheduleForDay(t, &c, date)
+	resumeAt, ok := schedule.nextResume(changed)
+	tomorrow := CalculateSunTimes(date.AddDate(0, 0, 1), c.Location)
+	if !ok || !resumeAt.Equal(tomorrow.Sunrise) {
+		t.Errorf("nextResume at %v = %v; want sunrise of the next day at %v", changed, resumeAt, tomorrow.Sunrise)
+	}
+	resumeAt, ok = schedule.nextResume(schedule.sunrise.Time.Add(time.Hour))
+	if !ok || !resumeAt.Equal(schedule.sunset.Time) {
+		t.Errorf("nextResume during the day = %v; want sunset at %v", resumeAt, schedule.sunset.Time)
+	}
+}
+
+func TestResumeKeepsBrightnessOffset(t *testing.T) {
+	fake, client := newFakeClipV2(t)
+	defer fake.server.Close()
+
+	device := &HueLight{Name: "Desk", Dimmable: true, SupportsColorTemperature: true, MinimumColorTemperature: 2000, MaximumColorTemperature: 6500, clip: client, clipID: "3f8e4b6a"}
+	light := &Light{Name: "Desk", Device: device, TargetLightState: LightState{2700, 80}}
+	light.BrightnessOffset = &BrightnessOffset{Mode: brightnessOffsetAbsolute, Value: -30}
+
+	// A manual change of the color temperature disables automatic mode
+	light.disableAutomaticMode()
+	_, err := light.resume()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !light.Automatic || light.BrightnessOffset == nil || light.BrightnessOffset.Value != -30 {
+		t.Errorf("Light should resume automatic mode with its offset: %+v", light.BrightnessOffset)
+	}
+	if device.SetColorTemperature != 2700 || device.SetBrightness != 50 {
+		t.Errorf("Resumed light was set to %dK at %d%%; want 2700K at 50%%", device.SetColorTemperature, device.SetBrightness)
+	}
+}
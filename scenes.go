@@ -26,26 +26,33 @@ import hue "github.com/stefanwichmann/go.hue"
 import "time"
 import "strings"
 
-func updateScenes() {
-	log.Debugf("🎨 Updating scenes...")
+func (bridge *HueBridge) updateScenes() {
+	log.Debugf("🎨 Updating scenes on bridge %s...", bridge.ID)
+	bridge.limiter.wait()
 	scenes, _ := bridge.bridge.AllScenes()
 	for _, scene := range scenes {
 		if strings.Contains(strings.ToLower(scene.Name), "kelvin") {
-			for _, schedule := range configuration.Schedules {
+			for index, schedule := range configuration.Schedules {
 				if strings.Contains(strings.ToLower(scene.Name), strings.ToLower(schedule.Name)) {
 					log.Debugf("🎨 Updating scene \"%s\" for schedule \"%s\"...", scene.Name, schedule.Name)
-					updateSceneForSchedule(scene, schedule)
+					bridge.updateSceneForSchedule(scene, configuration.associatedLightIDs(index, bridge.ID, time.Now()))
 				}
 			}
 		}
 	}
 }
 
-func updateSceneForSchedule(scene *hue.Scene, lightSchedule LightSchedule) {
+func (bridge *HueBridge) updateSceneForSchedule(scene *hue.Scene, lightIDs []int) {
+	if len(lightIDs) == 0 {
+		log.Debugf("🎨 Schedule for scene \"%s\" has no associated lights", scene.Name)
+		return
+	}
+
 	// Updating lights
 	var modifyScene hue.ModifyScene
-	modifyScene.Lights = toStringArray(lightSchedule.AssociatedDeviceIDs)
+	modifyScene.Lights = toStringArray(lightIDs)
 
+	bridge.limiter.wait()
 	_, err := scene.Modify(modifyScene)
 	if err != nil {
 		log.Warningf("🎨 %v", err)
@@ -53,8 +60,7 @@ func updateSceneForSchedule(scene *hue.Scene, lightSchedule LightSchedule) {
 	}
 
 	// Updating light states
-	light := lightSchedule.AssociatedDeviceIDs[0]
-	schedule, err := configuration.lightScheduleForDay(light, time.Now())
+	schedule, err := configuration.lightScheduleForDay(bridge.ID, lightIDs[0], time.Now())
 	if err != nil {
 		log.Warningf("🎨 %v", err)
 		return
@@ -79,6 +85,7 @@ func updateSceneForSchedule(scene *hue.Scene, lightSchedule LightSchedule) {
 		modifyState.Brightness = uint8(mapBrightness(state.Brightness))
 	}
 
+	bridge.limiter.wait()
 	_, err = scene.ModifyLightStates(modifyState)
 	if err != nil {
 		log.Warningf("🎨 %v", err)
@@ -24,86 +24,61 @@ package main
 import (
 	"fmt"
 	"time"
-
-	log "github.com/sirupsen/logrus"
 )
 
 // Schedule represents all relevants timestamps of one day.
 // Kelvin will calculate all light states based on the intervals
-// between this timestamps.
+// between this timestamps. The timeline reaches from the previous sunset
+// to the next sunrise, so intervals span midnight.
 type Schedule struct {
+	name                   string
 	endOfDay               time.Time
 	beforeSunrise          []TimeStamp
 	sunrise                TimeStamp
 	sunset                 TimeStamp
 	afterSunset            []TimeStamp
+	timeline               []TimeStamp
+	wakeUp                 *Interval
+	fadeOuts               []Interval
+	resumePolicy           string
+	resumeTimeout          time.Duration
+	brightnessOffset       string
+	elevationCurve         *elevationCurve
 	enableWhenLightsAppear bool
 }
 
 func (schedule *Schedule) currentInterval(timestamp time.Time) (Interval, error) {
-	// check if timestamp respresents the current day
-	if timestamp.After(schedule.endOfDay) {
-		return Interval{TimeStamp{time.Now(), 0, 0}, TimeStamp{time.Now(), 0, 0}}, fmt.Errorf("No current interval as the requested timestamp (%v) lays after the end of the current schedule (%v)", timestamp, schedule.endOfDay)
+	before, after, err := findTargetTimes(timestamp, schedule.timeline)
+	if err != nil {
+		return Interval{before, after, nil}, err
 	}
 
 	// if we are between todays sunrise and sunset, return daylight interval
-	if timestamp.After(schedule.sunrise.Time) && timestamp.Before(schedule.sunset.Time) {
-		return Interval{schedule.sunrise, schedule.sunset}, nil
-	}
-
-	var before, after TimeStamp
-	// Before sunrise
-	if timestamp.Before(schedule.sunrise.Time) {
-		yr, mth, dy := timestamp.Date()
-		startOfDay := TimeStamp{time.Date(yr, mth, dy, 0, 0, 0, 0, timestamp.Location()), -1, -1}
-		candidates := append(schedule.beforeSunrise, startOfDay, schedule.sunrise)
-
-		before, after = findTargetTimes(timestamp, candidates)
-
-		// fix dummy values
-		if before.ColorTemperature == -1 && before.Brightness == -1 {
-			before.ColorTemperature = after.ColorTemperature
-			before.Brightness = after.Brightness
-		}
-
-		return Interval{before, after}, nil
+	if before.Time.Equal(schedule.sunrise.Time) && after.Time.Equal(schedule.sunset.Time) {
+		return Interval{before, after, schedule.elevationCurve}, nil
 	}
-
-	// After sunset
-	if timestamp.After(schedule.sunset.Time) {
-		yr, mth, dy := timestamp.Date()
-		endOfDay := TimeStamp{time.Date(yr, mth, dy, 23, 59, 59, 0, timestamp.Location()), -1, -1}
-		candidates := append(schedule.afterSunset, endOfDay, schedule.sunset)
-
-		before, after = findTargetTimes(timestamp, candidates)
-	}
-
-	// fix dummy values
-	if after.ColorTemperature == -1 && after.Brightness == -1 {
-		after.ColorTemperature = before.ColorTemperature
-		after.Brightness = before.Brightness
-	}
-
-	return Interval{before, after}, nil
+	return Interval{before, after, nil}, nil
 }
 
-func findTargetTimes(timestamp time.Time, candidates []TimeStamp) (TimeStamp, TimeStamp) {
-	beforeCandidate := TimeStamp{timestamp.AddDate(0, 0, -2), 0, 0}
-	afterCandidate := TimeStamp{timestamp.AddDate(0, 0, 2), 0, 0}
+func findTargetTimes(timestamp time.Time, candidates []TimeStamp) (TimeStamp, TimeStamp, error) {
+	var beforeCandidate, afterCandidate TimeStamp
+	foundBefore, foundAfter := false, false
 
 	for _, candidate := range candidates {
-		if candidate.Time.Before(timestamp) && candidate.Time.After(beforeCandidate.Time) {
+		if !candidate.Time.After(timestamp) && (!foundBefore || candidate.Time.After(beforeCandidate.Time)) {
 			beforeCandidate = candidate
+			foundBefore = true
 			continue
 		}
-		if candidate.Time.After(timestamp) && candidate.Time.Before(afterCandidate.Time) {
+		if candidate.Time.After(timestamp) && (!foundAfter || candidate.Time.Before(afterCandidate.Time)) {
 			afterCandidate = candidate
+			foundAfter = true
 		}
 	}
 
-	if beforeCandidate.Time.Day() != timestamp.Day() || afterCandidate.Time.Day() != timestamp.Day() {
-		log.Fatal("Could not find targetTime in candidates.")
+	if !foundBefore || !foundAfter {
+		return beforeCandidate, afterCandidate, fmt.Errorf("Could not find target times for %v in candidates", timestamp.Format("Jan 2 15:04"))
 	}
 
-	return beforeCandidate, afterCandidate
+	return beforeCandidate, afterCandidate, nil
 }
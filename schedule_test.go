@@ -0,0 +1,88 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindTargetTimes(t *testing.T) {
+	timestamp := time.Date(2024, time.June, 21, 12, 0, 0, 0, time.UTC)
+	candidates := []TimeStamp{{timestamp.Add(-time.Hour), 2000, 50, Easing{}}}
+	if _, _, err := findTargetTimes(timestamp, candidates); err == nil {
+		t.Errorf("findTargetTimes should fail without a candidate after %v", timestamp)
+	}
+	candidates = append(candidates, TimeStamp{timestamp.Add(time.Hour), 3000, 80, Easing{}})
+	before, after, err := findTargetTimes(timestamp, candidates)
+	if err != nil || before.ColorTemperature != 2000 || after.ColorTemperature != 3000 {
+		t.Errorf("findTargetTimes(%v) = %+v, %+v, %v", timestamp, before, after, err)
+	}
+}
+
+func TestScheduleAcrossMidnight(t *testing.T) {
+	zone := time.FixedZone("CET", 60*60)
+	date := time.Date(2024, time.March, 10, 0, 0, 0, 0, zone)
+
+	c := newTestConfiguration(Location{Latitude: 52.52, Longitude: 13.40}, LightSchedule{
+		Name:                    "night shift",
+		AssociatedDeviceIDs:     []int{1},
+		DefaultColorTemperature: 4000,
+		DefaultBrightness:       100,
+		BeforeSunrise:           []TimedColorTemperature{{Time: "05:00", ColorTemperature: 2000, Brightness: 40}},
+		AfterSunset:             []TimedColorTemperature{{Time: "22:00", ColorTemperature: 2000, Brightness: 60}, {Time: "00:30", ColorTemperature: 2000, Brightness: 20}},
+	})
+	today := testScheduleForDay(t, &c, date)
+	tomorrow := testScheduleForDay(t, &c, date.AddDate(0, 0, 1))
+
+	bedtime := time.Date(2024, time.March, 11, 0, 30, 0, 0, zone)
+	if len(today.afterSunset) != 2 || !today.afterSunset[1].Time.Equal(bedtime) {
+		t.Fatalf("Entry at 00:30 should belong to the following night but entries after sunset are %v", today.afterSunset)
+	}
+
+	// Both schedules have to agree on the night in between
+	for _, timestamp := range []time.Time{bedtime.Add(-31 * time.Minute), bedtime.Add(-29 * time.Minute), bedtime.Add(time.Hour)} {
+		interval, err := today.currentInterval(timestamp)
+		if err != nil {
+			t.Fatalf("No interval at %v: %v", timestamp, err)
+		}
+		next, err := tomorrow.currentInterval(timestamp)
+		if err != nil {
+			t.Fatalf("No interval at %v in the schedule of the next day: %v", timestamp, err)
+		}
+		if interval != next {
+			t.Errorf("Intervals at %v differ: %v - %v and %v - %v", timestamp, interval.Start.Time, interval.End.Time, next.Start.Time, next.End.Time)
+		}
+	}
+
+	// The light state fades from 22:00 to 00:30 without a jump at midnight
+	midnight := date.AddDate(0, 0, 1)
+	interval, _ := today.currentInterval(midnight.Add(-time.Second))
+	if interval.Start.Brightness != 60 || interval.End.Brightness != 20 {
+		t.Errorf("Interval before midnight should fade from 60%% to 20%% but is %+v", interval)
+	}
+	before := interval.calculateLightStateInInterval(midnight.Add(-time.Second))
+	after := interval.calculateLightStateInInterval(midnight.Add(time.Second))
+	if before.Brightness-after.Brightness > 1 || before.Brightness <= 20 || before.Brightness >= 60 {
+		t.Errorf("Light state should be continuous at midnight but changes from %+v to %+v", before, after)
+	}
+}
@@ -0,0 +1,228 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const stateSaveInterval = 1 * time.Minute
+
+// Saved states are only restored if Kelvin was stopped for a short time.
+// Otherwise the lights may have been switched off and on in the meantime.
+const stateMaxAge = 5 * time.Minute
+
+// lightRuntimeState contains everything about a light Kelvin has learned
+// while running and would lose on a restart.
+type lightRuntimeState struct {
+	BridgeID                  string            `json:"bridge"`
+	ID                        int               `json:"id"`
+	Automatic                 bool              `json:"automatic"`
+	AutomaticColorTemperature bool              `json:"automaticColorTemperature"`
+	AutomaticBrightness       bool              `json:"automaticBrightness"`
+	BrightnessOffset          *BrightnessOffset `json:"brightnessOffset,omitempty"`
+	ResumeAt                  *time.Time        `json:"resumeAt,omitempty"`
+	Appearance                time.Time         `json:"appearance"`
+	WakeUpCancelled           time.Time         `json:"wakeUpCancelled"`
+	LightState                LightState        `json:"lightState"`
+	DeviceState               json.RawMessage   `json:"deviceState,omitempty"`
+	Saved                     time.Time         `json:"-"`
+}
+
+// runtimeState is the content of the state file.
+type runtimeState struct {
+	Saved  time.Time           `json:"saved"`
+	Lights []lightRuntimeState `json:"lights"`
+}
+
+// stateMutex serializes writes to the state file.
+var stateMutex sync.Mutex
+
+// savedStates contains the states of all lights loaded at startup by bridge
+// and light ID.
+var savedStates = make(map[string]map[int]*lightRuntimeState)
+
+// stateFilename returns the file the state of all lights is persisted to.
+// It defaults to state.json next to the configuration.
+func stateFilename() string {
+	if *flagStateFile != "" {
+		return *flagStateFile
+	}
+	return filepath.Join(filepath.Dir(*flagConfigurationFile), "state.json")
+}
+
+// saveLightStates writes the state of all lights Kelvin is tracking to the
+// state file.
+func saveLightStates() error {
+	state := runtimeState{Saved: time.Now(), Lights: []lightRuntimeState{}}
+	for _, light := range allLights() {
+		if !light.Tracking {
+			continue
+		}
+		state.Lights = append(state.Lights, light.runtimeState())
+	}
+
+	raw, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once so a crash never leaves a broken state behind
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	filename := stateFilename()
+	err = ioutil.WriteFile(filename+".tmp", raw, 0644)
+	if err != nil {
+		return err
+	}
+	log.Debugf("🤖 Saved state of %d lights to %s", len(state.Lights), filename)
+	return os.Rename(filename+".tmp", filename)
+}
+
+// persistLightStates saves the state of all lights periodically until the
+// given context is done.
+func persistLightStates(ctx context.Context) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := saveLightStates()
+			if err != nil {
+				log.Warningf("🤖 Could not save state of lights: %v", err)
+			}
+		}
+	}
+}
+
+// loadLightStates reads the states saved by a previous run of Kelvin.
+// Outdated states are ignored.
+func loadLightStates() (map[string]map[int]*lightRuntimeState, error) {
+	states := make(map[string]map[int]*lightRuntimeState)
+	raw, err := ioutil.ReadFile(stateFilename())
+	if os.IsNotExist(err) {
+		return states, nil
+	}
+	if err != nil {
+		return states, err
+	}
+
+	var state runtimeState
+	err = json.Unmarshal(raw, &state)
+	if err != nil {
+		return states, err
+	}
+	if time.Since(state.Saved) > stateMaxAge {
+		log.Printf("🤖 Ignoring state of lights saved at %v", state.Saved.Format("Jan 2 15:04"))
+		return states, nil
+	}
+
+	for index := range state.Lights {
+		light := &state.Lights[index]
+		light.Saved = state.Saved
+		if states[light.BridgeID] == nil {
+			states[light.BridgeID] = make(map[int]*lightRuntimeState)
+		}
+		states[light.BridgeID][light.ID] = light
+	}
+	log.Debugf("🤖 Loaded state of %d lights saved at %v", len(state.Lights), state.Saved.Format("15:04:05"))
+	return states, nil
+}
+
+func (light *Light) runtimeState() lightRuntimeState {
+	colorTemperature, brightness := light.automaticLightState()
+	if !light.Automatic {
+		colorTemperature, brightness = -1, -1
+	}
+	deviceState, err := json.Marshal(light.Device.observedState())
+	if err != nil {
+		log.Warningf("💡 Light %s - Could not save state of device: %v", light.Name, err)
+	}
+	return lightRuntimeState{
+		BridgeID:                  light.BridgeID,
+		ID:                        light.ID,
+		Automatic:                 light.Automatic,
+		AutomaticColorTemperature: light.AutomaticColorTemperature,
+		AutomaticBrightness:       light.AutomaticBrightness,
+		BrightnessOffset:          light.BrightnessOffset,
+		ResumeAt:                  light.ResumeAt,
+		Appearance:                light.Appearance,
+		WakeUpCancelled:           light.WakeUpCancelled,
+		LightState:                LightState{colorTemperature, brightness},
+		DeviceState:               deviceState,
+	}
+}
+
+// restoreRuntimeState continues where Kelvin left off before a restart. The
+// saved state is only used if the light still has the state Kelvin set or,
+// for lights controlled manually, the state the device reported before.
+func (light *Light) restoreRuntimeState() bool {
+	state := light.savedState
+	light.savedState = nil
+	if state == nil || time.Since(state.Saved) > stateMaxAge {
+		return false
+	}
+	if !light.hasSavedState(state) {
+		log.Printf("💡 Light %s - Light has been changed while Kelvin was stopped. Ignoring saved state...", light.Name)
+		return false
+	}
+
+	light.Tracking = true
+	light.Automatic = state.Automatic
+	light.AutomaticColorTemperature = state.AutomaticColorTemperature
+	light.AutomaticBrightness = state.AutomaticBrightness
+	light.BrightnessOffset = state.BrightnessOffset
+	light.ResumeAt = state.ResumeAt
+	light.Appearance = state.Appearance
+	light.WakeUpCancelled = state.WakeUpCancelled
+
+	// The device doesn't know which state Kelvin has set before
+	light.Initializing = light.Automatic
+	log.Printf("💡 Light %s - Restored saved state (Automatic: %v)", light.Name, light.Automatic)
+	return true
+}
+
+// hasSavedState returns true if the light is unchanged since the given
+// state has been saved.
+func (light *Light) hasSavedState(state *lightRuntimeState) bool {
+	if state.Automatic {
+		return light.Device.hasState(state.LightState.ColorTemperature, state.LightState.Brightness)
+	}
+	// The state file is indented, so compare both states in compact form
+	var saved bytes.Buffer
+	if state.DeviceState == nil || json.Compact(&saved, state.DeviceState) != nil {
+		return false
+	}
+	current, err := json.Marshal(light.Device.observedState())
+	return err == nil && bytes.Equal(current, saved.Bytes())
+}
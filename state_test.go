@@ -0,0 +1,107 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreLightState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	*flagStateFile = filename
+	defer func() { *flagStateFile = "" }()
+
+	newLight := func(brightness int) *Light {
+		device := &HueLight{Name: "Desk", Dimmable: true, SupportsColorTemperature: true, CurrentColorMode: "ct"}
+		device.CurrentColorTemperature, device.CurrentBrightness = mapColorTemperature(2700), mapBrightness(brightness)
+		return &Light{ID: 1, BridgeID: "bridge", Name: "Desk", Device: device, Reachable: true, On: true}
+	}
+
+	// Kelvin was dimming the light with an offset before the restart
+	light := newLight(60)
+	light.Tracking = true
+	light.setAutomatic(true)
+	light.BrightnessOffset = &BrightnessOffset{Mode: brightnessOffsetAbsolute, Value: -20}
+	light.TargetLightState = LightState{2700, 80}
+	light.Appearance = time.Now().Add(-time.Hour)
+	raw, _ := json.Marshal(runtimeState{Saved: time.Now(), Lights: []lightRuntimeState{light.runtimeState()}})
+	ioutil.WriteFile(filename, raw, 0644)
+
+	states, err := loadLightStates()
+	if err != nil {
+		t.Fatalf("Could not load state: %v", err)
+	}
+
+	restored := newLight(60)
+	restored.savedState = states["bridge"][1]
+	if !restored.restoreRuntimeState() {
+		t.Fatalf("State of unchanged light was not restored")
+	}
+	if !restored.Tracking || !restored.Automatic || !restored.Initializing || restored.BrightnessOffset == nil || !restored.Appearance.Equal(light.Appearance) {
+		t.Errorf("Restored light differs from saved one: %+v", restored)
+	}
+
+	// Someone changed the light while Kelvin was stopped
+	changed := newLight(100)
+	changed.savedState = states["bridge"][1]
+	if changed.restoreRuntimeState() || changed.Tracking {
+		t.Errorf("State of changed light should not be restored")
+	}
+
+	// A light controlled manually is only restored if nobody touched it
+	light = newLight(100)
+	light.Tracking = true
+	light.setAutomatic(false)
+	saveAndLoad := func(lights ...*Light) map[string]map[int]*lightRuntimeState {
+		state := runtimeState{Saved: time.Now()}
+		for _, light := range lights {
+			state.Lights = append(state.Lights, light.runtimeState())
+		}
+		raw, _ := json.MarshalIndent(state, "", "  ")
+		ioutil.WriteFile(filename, raw, 0644)
+		states, _ := loadLightStates()
+		return states
+	}
+	states = saveAndLoad(light)
+	restored = newLight(100)
+	restored.savedState = states["bridge"][1]
+	if !restored.restoreRuntimeState() || restored.Automatic {
+		t.Errorf("State of unchanged manual light was not restored: %+v", restored)
+	}
+	changed = newLight(40)
+	changed.savedState = states["bridge"][1]
+	if changed.restoreRuntimeState() || changed.Tracking {
+		t.Errorf("State of manual light changed while Kelvin was stopped should not be restored")
+	}
+
+	// Outdated states are ignored
+	raw, _ = json.Marshal(runtimeState{Saved: time.Now().Add(-time.Hour), Lights: []lightRuntimeState{light.runtimeState()}})
+	ioutil.WriteFile(filename, raw, 0644)
+	states, _ = loadLightStates()
+	if len(states) != 0 {
+		t.Errorf("Outdated state should be ignored: %+v", states)
+	}
+}
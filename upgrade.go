@@ -22,6 +22,7 @@
 package main
 
 import log "github.com/sirupsen/logrus"
+import "context"
 import "runtime"
 import "path/filepath"
 import "github.com/Masterminds/semver"
@@ -35,7 +36,8 @@ const updateCheckInterval = 12 * time.Hour
 // CheckForUpdate will get the latest release information of Kelvin
 // from github and compare it to the given version. If a newer version
 // is found it will try to replace the running binary and restart.
-func CheckForUpdate(currentVersion string, forceUpdate bool) {
+// It returns when the given context is done.
+func CheckForUpdate(ctx context.Context, currentVersion string, forceUpdate bool) {
 	// only look for update if version string matches a valid release version
 	version, err := semver.NewVersion(currentVersion)
 	if err != nil {
@@ -52,12 +54,16 @@ func CheckForUpdate(currentVersion string, forceUpdate bool) {
 			if err != nil {
 				log.Warningf("Error updating binary: %v.", err)
 			} else {
-				log.Printf("Restarting...")
 				Restart()
+				return
 			}
 		}
 		// try again in 12 hours...
-		time.Sleep(updateCheckInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(updateCheckInterval):
+		}
 	}
 }
 
@@ -28,7 +28,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func containsString(slice []string, element string) bool {
@@ -49,6 +52,15 @@ func containsInt(slice []int, element int) bool {
 	return false
 }
 
+func containsDevice(slice []DeviceID, element DeviceID) bool {
+	for _, current := range slice {
+		if current == element {
+			return true
+		}
+	}
+	return false
+}
+
 func abs(value int) int {
 	if value < 0 {
 		return value * -1
@@ -98,22 +110,46 @@ func equalsInt(a int, b int, maxDiff int) bool {
 	return true
 }
 
-// Restart the running binary.
-// All arguments, pipes and environment variables will
-// be preserved.
+// Reload stops all tasks and restarts them with the configuration read
+// from disk again. The process keeps running.
+func Reload() {
+	select {
+	case reloadRequests <- struct{}{}:
+	default: // already requested
+	}
+}
+
+// Restart stops all tasks gracefully and replaces the running process by
+// the binary on disk, e.g. after an update.
 func Restart() {
-	binary := os.Args[0]
-	args := []string{}
-	if len(os.Args) > 1 {
-		args = os.Args[1:]
+	select {
+	case restartRequests <- struct{}{}:
+	default: // already requested
+	}
+}
+
+// restartBinary replaces the running process by a new instance of the binary.
+// All arguments, pipes and environment variables will be preserved.
+func restartBinary() {
+	binary, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		log.Errorf("🤖 Could not find binary %s: %v", os.Args[0], err)
+		os.Exit(1)
 	}
 
-	cmd := exec.Command(binary, args...)
+	// Keep process ID and pipes. This isn't supported on Windows.
+	err = syscall.Exec(binary, os.Args, os.Environ())
+	log.Debugf("🤖 Could not replace process: %v - Starting new one...", err)
+
+	cmd := exec.Command(binary, os.Args[1:]...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Env = os.Environ()
-
-	cmd.Start()
+	err = cmd.Start()
+	if err != nil {
+		log.Errorf("🤖 Could not start %s: %v", binary, err)
+		os.Exit(1)
+	}
 	os.Exit(0)
 }
 
@@ -140,6 +176,12 @@ func durationUntilNextDay() time.Duration {
 	return time.Until(endOfDay)
 }
 
+func sameDay(a time.Time, b time.Time) bool {
+	yearA, monthA, dayA := a.Date()
+	yearB, monthB, dayB := b.Date()
+	return yearA == yearB && monthA == monthB && dayA == dayB
+}
+
 func isYAMLFile(filename string) bool {
 	fileExt := filepath.Ext(filename)
 	if fileExt == ".yaml" || fileExt == ".yml" {
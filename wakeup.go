@@ -0,0 +1,191 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// A wake-up alarm starts with a warm and dim light and brightens it to
+// full brightness over its duration.
+const (
+	wakeUpColorTemperature = 2000
+	wakeUpBrightness       = 1
+	defaultWakeUpDuration  = 30 * time.Minute
+)
+
+// validate checks all weekdays, times and holidays of the alarm.
+func (wakeUp *WakeUp) validate() error {
+	for day, alarm := range wakeUp.Times {
+		if _, err := parseWeekday(day); err != nil {
+			return err
+		}
+		if _, err := time.Parse("15:04", alarm); err != nil {
+			return fmt.Errorf("Invalid time %s on %s", alarm, day)
+		}
+	}
+	_, err := wakeUp.isHoliday(time.Now())
+	return err
+}
+
+// rampForDay returns the interval of the wake-up alarm on the given day.
+// If there is no alarm on this day nil is returned. The light brightens
+// towards the given color temperature unless the alarm configures its own.
+func (wakeUp *WakeUp) rampForDay(date time.Time, colorTemperature int) (*Interval, error) {
+	holiday, err := wakeUp.isHoliday(date)
+	if err != nil || holiday {
+		return nil, err
+	}
+
+	var start time.Time
+	for day, alarm := range wakeUp.Times {
+		weekday, err := parseWeekday(day)
+		if err != nil {
+			return nil, err
+		}
+		if weekday != date.Weekday() || alarm == "" {
+			continue
+		}
+		start, err = timeOnDay(date, alarm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if start.IsZero() {
+		return nil, nil
+	}
+
+	duration := time.Duration(wakeUp.Duration) * time.Minute
+	if duration <= 0 {
+		duration = defaultWakeUpDuration
+	}
+	if wakeUp.ColorTemperature != 0 {
+		colorTemperature = wakeUp.ColorTemperature
+	}
+	startColorTemperature := wakeUpColorTemperature
+	if colorTemperature == -1 {
+		startColorTemperature = -1
+	}
+
+	// Brighten evenly to the human eye
+	easing := Easing{easingMired, easingPerceptual}
+	return &Interval{TimeStamp{start, startColorTemperature, wakeUpBrightness, easing}, TimeStamp{start.Add(duration), colorTemperature, 100, easing}, nil}, nil
+}
+
+// isHoliday returns true if the given day is one of the holidays of the alarm.
+func (wakeUp *WakeUp) isHoliday(date time.Time) (bool, error) {
+	for _, holiday := range wakeUp.Holidays {
+		day, recurring, err := parseVariantDate(strings.TrimSpace(holiday))
+		if err != nil {
+			return false, err
+		}
+		if day.Month() == date.Month() && day.Day() == date.Day() && (recurring || day.Year() == date.Year()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// wakeUpActive returns true if the wake-up alarm of today is running at the
+// given time and has not been cancelled.
+func (light *Light) wakeUpActive(timestamp time.Time) bool {
+	ramp := light.Schedule.wakeUp
+	if ramp == nil || timestamp.Before(ramp.Start.Time) || !timestamp.Before(ramp.End.Time) {
+		return false
+	}
+	return !light.WakeUpCancelled.Equal(ramp.Start.Time)
+}
+
+// PendingWakeUp returns the start of the wake-up alarm of today if it is
+// running or still ahead. Otherwise an empty string is returned.
+func (light *Light) PendingWakeUp() string {
+	ramp := light.Schedule.wakeUp
+	if ramp == nil || !time.Now().Before(ramp.End.Time) || light.WakeUpCancelled.Equal(ramp.Start.Time) {
+		return ""
+	}
+	return ramp.Start.Time.Format("15:04")
+}
+
+// cancelWakeUp cancels the running or upcoming wake-up alarm of today.
+// A light which is already waking up keeps its current state.
+func (light *Light) cancelWakeUp() error {
+	if light.PendingWakeUp() == "" {
+		return fmt.Errorf("No wake-up pending for light %s", light.Name)
+	}
+	light.WakeUpCancelled = light.Schedule.wakeUp.Start.Time
+	if light.WakingUp {
+		light.WakingUp = false
+		light.disableAutomaticMode()
+	}
+	return nil
+}
+
+// wakeUp turns the light on at the start of a wake-up alarm and keeps
+// brightening it. Turning the light off or changing it manually cancels
+// the alarm.
+func (light *Light) wakeUp(transitionTime time.Duration) (bool, error) {
+	if !light.WakingUp {
+		device, ok := light.Device.(switchableDevice)
+		if !ok {
+			log.Warningf("💡 Light %s - Light can't be turned on. Ignoring wake-up...", light.Name)
+			light.WakeUpCancelled = light.Schedule.wakeUp.Start.Time
+			return false, nil
+		}
+
+		light.updateTargetLightState()
+		log.Printf("💡 Light %s - Waking up until %v. Turning light on at %vK and %v%% brightness.", light.Name, light.Schedule.wakeUp.End.Time.Format("15:04"), light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
+		err := device.turnOn(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transitionTime)
+		if err != nil {
+			return true, err
+		}
+		light.WakingUp = true
+		light.On = true
+		light.Tracking = true
+		light.setAutomatic(true)
+		light.Initializing = false
+		light.Appearance = time.Now()
+		return true, nil
+	}
+
+	// Give the light some time to report its new state
+	if time.Since(light.Appearance) > initializationDuration && (!light.On || light.Device.hasChanged()) {
+		log.Printf("💡 Light %s - Light state has been changed manually. Cancelling wake-up...", light.Name)
+		light.WakeUpCancelled = light.Schedule.wakeUp.Start.Time
+		light.WakingUp = false
+		light.disableAutomaticMode()
+		return false, nil
+	}
+
+	if light.Device.hasState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness) {
+		return false, nil
+	}
+	err := light.Device.setLightState(light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness, transitionTime)
+	if err != nil {
+		return true, err
+	}
+	log.Debugf("💡 Light %s - Updated light state to %vK at %v%% brightness (Wake-up)", light.Name, light.TargetLightState.ColorTemperature, light.TargetLightState.Brightness)
+	return true, nil
+}
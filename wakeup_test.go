@@ -0,0 +1,94 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWakeUpRamp(t *testing.T) {
+	wakeUp := WakeUp{Times: map[string]string{"mon": "06:30", "Saturday": "09:00"}, Holidays: []string{"2024-06-17", "12-23"}}
+	if err := wakeUp.validate(); err != nil {
+		t.Fatalf("validate returned error: %v", err)
+	}
+
+	tests := []struct {
+		date  time.Time
+		start string
+	}{
+		{time.Date(2024, time.June, 10, 0, 0, 0, 0, time.UTC), "06:30"}, // Monday
+		{time.Date(2024, time.June, 11, 0, 0, 0, 0, time.UTC), ""},      // Tuesday
+		{time.Date(2024, time.June, 15, 0, 0, 0, 0, time.UTC), "09:00"}, // Saturday
+		{time.Date(2024, time.June, 17, 0, 0, 0, 0, time.UTC), ""},      // Holiday on a Monday
+		{time.Date(2024, time.December, 23, 0, 0, 0, 0, time.UTC), ""},  // Recurring holiday on a Monday
+		{time.Date(2024, time.December, 30, 0, 0, 0, 0, time.UTC), "06:30"},
+	}
+	for _, test := range tests {
+		ramp, err := wakeUp.rampForDay(test.date, 4000)
+		if err != nil {
+			t.Errorf("rampForDay(%v) returned error: %v", test.date.Format("Mon Jan 2"), err)
+			continue
+		}
+		if test.start == "" {
+			if ramp != nil {
+				t.Errorf("rampForDay(%v) = %v; want no alarm", test.date.Format("Mon Jan 2"), ramp.Start.Time)
+			}
+			continue
+		}
+		if ramp == nil || ramp.Start.Time.Format("15:04") != test.start {
+			t.Errorf("rampForDay(%v) = %+v; want alarm at %s", test.date.Format("Mon Jan 2"), ramp, test.start)
+			continue
+		}
+		if ramp.End.Time.Sub(ramp.Start.Time) != defaultWakeUpDuration {
+			t.Errorf("Alarm on %v lasts %v; want %v", test.date.Format("Mon Jan 2"), ramp.End.Time.Sub(ramp.Start.Time), defaultWakeUpDuration)
+		}
+		first := ramp.calculateLightStateInInterval(ramp.Start.Time)
+		last := ramp.calculateLightStateInInterval(ramp.End.Time)
+		if first.ColorTemperature != wakeUpColorTemperature || first.Brightness != wakeUpBrightness || last.ColorTemperature != 4000 || last.Brightness != 100 {
+			t.Errorf("Alarm on %v ramps from %+v to %+v", test.date.Format("Mon Jan 2"), first, last)
+		}
+	}
+
+	invalid := WakeUp{Times: map[string]string{"someday": "06:30"}}
+	if err := invalid.validate(); err == nil {
+		t.Errorf("validate should fail for unknown weekdays")
+	}
+}
+
+func TestCancelWakeUp(t *testing.T) {
+	start := time.Now().Add(time.Hour)
+	var light Light
+	light.Schedule.wakeUp = &Interval{TimeStamp{start, 2000, 1, Easing{}}, TimeStamp{start.Add(30 * time.Minute), 4000, 100, Easing{}}, nil}
+	if !light.wakeUpActive(start.Add(time.Minute)) || light.PendingWakeUp() == "" {
+		t.Fatalf("Wake-up at %v should be pending", start)
+	}
+	if err := light.cancelWakeUp(); err != nil {
+		t.Fatalf("cancelWakeUp returned error: %v", err)
+	}
+	if light.wakeUpActive(start.Add(time.Minute)) || light.PendingWakeUp() != "" {
+		t.Errorf("Cancelled wake-up should not be active")
+	}
+	if err := light.cancelWakeUp(); err == nil {
+		t.Errorf("cancelWakeUp should fail without a pending wake-up")
+	}
+}
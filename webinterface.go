@@ -30,8 +30,21 @@ import "encoding/json"
 import "fmt"
 import "strings"
 import "strconv"
+import "time"
+import "context"
 
-func startInterface() {
+const webInterfaceShutdownTimeout = 5 * time.Second
+
+// dashboardData contains all information shown on the dashboard.
+type dashboardData struct {
+	Lights   []*Light
+	Twilight string
+	Sunrise  string
+	Sunset   string
+}
+
+// startInterface serves the web interface until the given context is done.
+func startInterface(ctx context.Context) {
 	if !configuration.WebInterface.Enabled {
 		return
 	}
@@ -47,30 +60,60 @@ func startInterface() {
 	r.HandleFunc("/schedules", updateSchedulesHandler).Methods("PUT", "POST")
 	r.HandleFunc("/configuration", updateConfigurationHandler).Methods("PUT", "POST")
 	r.HandleFunc("/lights", lightsHandler).Methods("GET")
+	r.HandleFunc("/bridges/{bridge}/lights/{id}/automatic", automateLightHandler).Methods("PUT", "POST")
+	r.HandleFunc("/bridges/{bridge}/lights/{id}/activate", activateLightHandler).Methods("PUT", "POST")
+	r.HandleFunc("/bridges/{bridge}/lights/{id}/wakeup/cancel", cancelWakeUpHandler).Methods("PUT", "POST")
+	// lights of the first bridge
 	r.HandleFunc("/lights/{id}/automatic", automateLightHandler).Methods("PUT", "POST")
 	r.HandleFunc("/lights/{id}/activate", activateLightHandler).Methods("PUT", "POST")
+	r.HandleFunc("/lights/{id}/wakeup/cancel", cancelWakeUpHandler).Methods("PUT", "POST")
 
 	// static files
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("gui/static"))))
 
-	http.Handle("/", handlers.CompressHandler(r))
 	port := configuration.WebInterface.Port
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handlers.CompressHandler(r)}
+
+	// Finish running requests before the server is stopped
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), webInterfaceShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Warningf("Could not stop webinterface: %v", err)
+		}
+	}()
+
 	log.Printf("Webinterface started on port %d", port)
-	log.Warning(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Warning(err)
+	}
+	<-stopped
+	log.Debugf("Webinterface stopped")
 }
 
 func dashboardHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Serving dashboard page to %s", r.RemoteAddr)
-	if configuration.Bridge.IP == "" || configuration.Bridge.Username == "" {
+	backends := allBridges()
+	if index := configuration.unconfiguredBridge(); index >= 0 && index < len(backends) {
 		dashboardTemplate := template.Must(template.New("init.html").ParseGlob("gui/template/init.html"))
-		err := dashboardTemplate.Execute(w, bridge)
+		err := dashboardTemplate.Execute(w, backends[index])
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	} else {
+		var dashboard dashboardData
+		dashboard.Lights = allLights()
+		dashboard.Twilight = configuration.Location.TwilightDescription()
+		dashboard.Sunrise = CalculateSunrise(time.Now(), configuration.Location).Format("15:04")
+		dashboard.Sunset = CalculateSunset(time.Now(), configuration.Location).Format("15:04")
 		dashboardTemplate := template.Must(template.New("dashboard.html").ParseGlob("gui/template/dashboard.html"))
-		err := dashboardTemplate.Execute(w, lights)
+		err := dashboardTemplate.Execute(w, dashboard)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -90,7 +133,7 @@ func configurationHandler(w http.ResponseWriter, r *http.Request) {
 
 func schedulesHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Serving schedules page to %s", r.RemoteAddr)
-	schedulesTemplate := template.Must(template.New("schedules.html").Funcs(template.FuncMap{"lightsToString": lightsToString}).ParseGlob("gui/template/schedules.html"))
+	schedulesTemplate := template.Must(template.New("schedules.html").Funcs(template.FuncMap{"lightsToString": lightsToString, "namesToString": namesToString, "wakeUpTime": wakeUpTime}).ParseGlob("gui/template/schedules.html"))
 	err := schedulesTemplate.Execute(w, configuration.Schedules)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -112,6 +155,24 @@ func lightsToString(args ...interface{}) (string, error) {
 	return strings.Trim(strings.Join(strings.Fields(fmt.Sprint(s)), ","), "[]"), nil
 }
 
+func namesToString(names []string) string {
+	return strings.Join(names, ", ")
+}
+
+// wakeUpTime returns the alarm time of the given weekday or an empty string.
+func wakeUpTime(wakeUp *WakeUp, day string) string {
+	if wakeUp == nil {
+		return ""
+	}
+	weekday, _ := parseWeekday(day)
+	for name, alarm := range wakeUp.Times {
+		if candidate, err := parseWeekday(name); err == nil && candidate == weekday {
+			return alarm
+		}
+	}
+	return ""
+}
+
 func updateSchedulesHandler(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	var t []LightSchedule
@@ -123,6 +184,8 @@ func updateSchedulesHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	log.Debugf("Received schedule update from %s: %+v", r.RemoteAddr, t)
 	configuration.Schedules = t
+	configuration.resetAssociations()
+	configuration.validateSchedules(time.Now())
 	err = configuration.Write()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -130,10 +193,12 @@ func updateSchedulesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update scenes
-	updateScenes()
+	for _, bridge := range allBridges() {
+		updateScenes(bridge)
+	}
 
 	// Update lights
-	for _, light := range lights {
+	for _, light := range allLights() {
 		light := light
 		updateScheduleForLight(light)
 	}
@@ -150,7 +215,23 @@ func updateConfigurationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	log.Debugf("Received configuration update from %s: %+v", r.RemoteAddr, t)
-	configuration.Bridge = t.Bridge
+	if t.Bridge != nil {
+		// The setup page only sends the bridge currently being set up
+		if index := configuration.unconfiguredBridge(); index >= 0 {
+			configuration.Bridges[index].IP = t.Bridge.IP
+		}
+	}
+	if len(t.Bridges) > 0 {
+		// The configuration page doesn't know the IDs of devices
+		for index := range t.Bridges {
+			for _, bridge := range configuration.Bridges {
+				if t.Bridges[index].Devices == nil && bridge.ID == t.Bridges[index].ID {
+					t.Bridges[index].Devices = bridge.Devices
+				}
+			}
+		}
+		configuration.Bridges = t.Bridges
+	}
 	configuration.Location = t.Location
 	configuration.WebInterface = t.WebInterface
 	configuration.Write()
@@ -159,24 +240,20 @@ func updateConfigurationHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func automateLightHandler(w http.ResponseWriter, r *http.Request) {
-	lightID, err := strconv.Atoi(mux.Vars(r)["id"])
+	l, err := requestedLight(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	for _, l := range lights {
-		if l.ID == lightID {
-			log.Printf("💡 Light %s - Enabling automatic mode as requested by %s", l.Name, r.RemoteAddr)
-			l.Tracking = false
-		}
-	}
+	log.Printf("💡 Light %s - Enabling automatic mode as requested by %s", l.Name, r.RemoteAddr)
+	l.Tracking = false
 	w.Write([]byte("success"))
 }
 
 func activateLightHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Received new light state by %s", r.RemoteAddr)
 	defer r.Body.Close()
-	lightID, err := strconv.Atoi(mux.Vars(r)["id"])
+	l, err := requestedLight(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -194,20 +271,55 @@ func activateLightHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, l := range lights {
-		if l.ID == lightID {
-			log.Printf("💡 Light %s - Activating light state %+v as requested by %s", l.Name, t, r.RemoteAddr)
-			l.Automatic = false
-			l.HueLight.setLightState(t.ColorTemperature, t.Brightness, 0)
-		}
+	log.Printf("💡 Light %s - Activating light state %+v as requested by %s", l.Name, t, r.RemoteAddr)
+	l.setAutomatic(false)
+	l.BrightnessOffset = nil
+	l.Device.setLightState(t.ColorTemperature, t.Brightness, 0)
+	w.Write([]byte("success"))
+}
+
+func cancelWakeUpHandler(w http.ResponseWriter, r *http.Request) {
+	l, err := requestedLight(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = l.cancelWakeUp()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	log.Printf("💡 Light %s - Cancelled wake-up as requested by %s", l.Name, r.RemoteAddr)
 	w.Write([]byte("success"))
 }
 
+// requestedLight returns the light addressed by the bridge and id of the
+// request. Requests without a bridge address the first bridge.
+func requestedLight(r *http.Request) (*Light, error) {
+	vars := mux.Vars(r)
+	lightID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return nil, err
+	}
+	bridgeID, found := vars["bridge"]
+	if !found {
+		backends := allBridges()
+		if len(backends) == 0 {
+			return nil, fmt.Errorf("No bridge configured")
+		}
+		bridgeID = backends[0].bridgeID()
+	}
+	light := findLight(bridgeID, lightID)
+	if light == nil {
+		return nil, fmt.Errorf("Light %d not found on bridge %s", lightID, bridgeID)
+	}
+	return light, nil
+}
+
 func lightsHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Serving lights to %s", r.RemoteAddr)
 	ls := []Light{}
-	for _, l := range lights {
+	for _, l := range allLights() {
 		ls = append(ls, *l)
 	}
 	data, err := json.Marshal(ls)
@@ -222,5 +334,5 @@ func restartHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Restart requested by %s", r.RemoteAddr)
 	r.Body.Close()
 	w.Write([]byte("success"))
-	Restart()
+	Reload()
 }
@@ -0,0 +1,287 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Frame opcodes of RFC 6455
+const (
+	websocketContinuation = 0x0
+	websocketText         = 0x1
+	websocketBinary       = 0x2
+	websocketClose        = 0x8
+	websocketPing         = 0x9
+	websocketPong         = 0xA
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketMaxMessage limits the size of a single message.
+const websocketMaxMessage = 1024 * 1024
+
+// websocketKeepAlive is the interval in which the server has to send any
+// frame. Our pings make sure it has something to answer.
+const websocketKeepAlive = 30 * time.Second
+
+// websocketConn is a minimal websocket client connection. It supports
+// everything Kelvin needs to receive events: Reading text messages and
+// answering pings.
+type websocketConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	mutex     sync.Mutex
+	keepAlive time.Duration
+}
+
+// dialWebsocket opens a websocket connection to the given ws:// URL.
+func dialWebsocket(address string, timeout time.Duration) (*websocketConn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("Unsupported websocket scheme %s", u.Scheme)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	path := u.RequestURI()
+	request := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	_, err = conn.Write([]byte(request))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: "GET"})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("Websocket handshake failed with HTTP %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		conn.Close()
+		return nil, errors.New("Websocket handshake failed: Invalid accept key")
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &websocketConn{conn: conn, reader: reader, keepAlive: websocketKeepAlive}, nil
+}
+
+// websocketAccept returns the accept key the server must answer with.
+func websocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ReadMessage blocks until the next text or binary message was received.
+// Pings are answered while waiting. If keep alive is enabled and the server
+// stays silent for twice the interval, the connection is considered lost.
+func (ws *websocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		if ws.keepAlive > 0 {
+			ws.conn.SetReadDeadline(time.Now().Add(2 * ws.keepAlive))
+		}
+		final, opcode, payload, err := ws.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case websocketPing:
+			err = ws.writeFrame(websocketPong, payload)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case websocketPong:
+			continue
+		case websocketClose:
+			ws.writeFrame(websocketClose, payload)
+			return nil, io.EOF
+		}
+
+		message = append(message, payload...)
+		if len(message) > websocketMaxMessage {
+			return nil, errors.New("Websocket message too large")
+		}
+		if final {
+			return message, nil
+		}
+	}
+}
+
+func (ws *websocketConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(ws.reader, header[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	final := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(ws.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(ws.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if length > websocketMaxMessage {
+		return false, 0, nil, errors.New("Websocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		_, err = io.ReadFull(ws.reader, mask[:])
+		if err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(ws.reader, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for index := range payload {
+			payload[index] ^= mask[index%4]
+		}
+	}
+	return final, opcode, payload, nil
+}
+
+// writeFrame sends a single masked frame as required for clients.
+func (ws *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	frame := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = append(frame, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+
+	var mask [4]byte
+	_, err := rand.Read(mask[:])
+	if err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for index, b := range payload {
+		frame = append(frame, b^mask[index%4])
+	}
+	_, err = ws.conn.Write(frame)
+	return err
+}
+
+// ping sends a ping frame twice per keep alive interval until done is
+// closed or the connection fails.
+func (ws *websocketConn) ping(done <-chan struct{}) {
+	ticker := time.NewTicker(ws.keepAlive / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := ws.writeFrame(websocketPing, nil)
+			if err != nil {
+				ws.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close closes the underlying connection.
+func (ws *websocketConn) Close() error {
+	ws.writeFrame(websocketClose, nil)
+	return ws.conn.Close()
+}
+
+// websocketURL returns the ws:// URL for the given host and port. A port
+// contained in host is replaced.
+func websocketURL(host string, port int) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	return fmt.Sprintf("ws://%s", net.JoinHostPort(host, fmt.Sprint(port)))
+}
@@ -0,0 +1,327 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// acceptWebsocket upgrades the given request like a websocket server would.
+func acceptWebsocket(w http.ResponseWriter, r *http.Request) (net.Conn, *websocketConn) {
+	conn, buffer, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil
+	}
+	buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	buffer.WriteString("Sec-WebSocket-Accept: " + websocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	buffer.Flush()
+	return conn, &websocketConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// writeServerFrame sends an unmasked frame as servers do.
+func writeServerFrame(conn net.Conn, final bool, opcode byte, payload string) {
+	first := opcode
+	if final {
+		first |= 0x80
+	}
+	conn.Write(append([]byte{first, byte(len(payload))}, payload...))
+}
+
+func TestWebsocket(t *testing.T) {
+	pong := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ws := acceptWebsocket(w, r)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		writeServerFrame(conn, true, websocketPing, "ping")
+		writeServerFrame(conn, false, websocketText, `{"t":"event",`)
+		writeServerFrame(conn, true, websocketContinuation, `"e":"changed"}`)
+
+		_, opcode, payload, err := ws.readFrame()
+		if err == nil && opcode == websocketPong {
+			pong <- string(payload)
+		}
+		ws.readFrame() // wait for the client to close the connection
+	}))
+	defer server.Close()
+
+	ws, err := dialWebsocket("ws://"+strings.TrimPrefix(server.URL, "http://")+"/", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	message, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(message) != `{"t":"event","e":"changed"}` {
+		t.Errorf("Received message %s; want both fragments", message)
+	}
+	select {
+	case payload := <-pong:
+		if payload != "ping" {
+			t.Errorf("Pong contains %s; want ping", payload)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Ping was not answered")
+	}
+}
+
+// newWebsocketTestServer runs serve for every websocket connection and
+// returns the ws:// URL of the server.
+func newWebsocketTestServer(t *testing.T, serve func(conn net.Conn, ws *websocketConn)) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ws := acceptWebsocket(w, r)
+		if conn == nil {
+			return
+		}
+		defer conn.Close()
+		serve(conn, ws)
+	}))
+	t.Cleanup(server.Close)
+	return "ws://" + strings.TrimPrefix(server.URL, "http://") + "/"
+}
+
+func TestWebsocketFrameLengths(t *testing.T) {
+	for _, length := range []int{0, 125, 126, 65535, 65536} {
+		client, server := net.Pipe()
+		payload := bytes.Repeat([]byte("k"), length)
+		go func() {
+			ws := &websocketConn{conn: client}
+			ws.writeFrame(websocketText, payload)
+			client.Close()
+		}()
+
+		reader := bufio.NewReader(server)
+		header, err := reader.Peek(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header[1]&0x80 == 0 {
+			t.Errorf("Frame with %d bytes is not masked", length)
+		}
+		final, opcode, received, err := (&websocketConn{conn: server, reader: reader}).readFrame()
+		if err != nil || !final || opcode != websocketText || !bytes.Equal(received, payload) {
+			t.Errorf("Frame with %d bytes was read as %d bytes (final: %t, opcode: %d): %v", length, len(received), final, opcode, err)
+		}
+		server.Close()
+	}
+}
+
+func TestWebsocketControlFrames(t *testing.T) {
+	pong := make(chan string, 1)
+	closed := make(chan []byte, 1)
+	address := newWebsocketTestServer(t, func(conn net.Conn, ws *websocketConn) {
+		// Control frames may be sent between the fragments of a message
+		writeServerFrame(conn, false, websocketText, `{"t":"event",`)
+		writeServerFrame(conn, true, websocketPing, "keep")
+		writeServerFrame(conn, false, websocketContinuation, `"e":"changed",`)
+		writeServerFrame(conn, true, websocketPong, "")
+		writeServerFrame(conn, true, websocketContinuation, `"r":"lights"}`)
+		// Servers must not mask frames, but we accept them anyway
+		ws.writeFrame(websocketText, []byte(`{"t":"masked"}`))
+		writeServerFrame(conn, true, websocketClose, "\x03\xe8")
+
+		for {
+			_, opcode, payload, err := ws.readFrame()
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case websocketPong:
+				pong <- string(payload)
+			case websocketClose:
+				closed <- payload
+				return
+			}
+		}
+	})
+
+	ws, err := dialWebsocket(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	for _, expected := range []string{`{"t":"event","e":"changed","r":"lights"}`, `{"t":"masked"}`} {
+		message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(message) != expected {
+			t.Errorf("Received message %s; want %s", message, expected)
+		}
+	}
+	if _, err := ws.ReadMessage(); err != io.EOF {
+		t.Errorf("Reading after close frame returned %v; want EOF", err)
+	}
+
+	select {
+	case payload := <-pong:
+		if payload != "keep" {
+			t.Errorf("Pong contains %s; want keep", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Ping was not answered")
+	}
+	select {
+	case payload := <-closed:
+		if string(payload) != "\x03\xe8" {
+			t.Errorf("Close frame contains %v; want status code 1000", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Close frame was not answered")
+	}
+}
+
+func TestWebsocketKeepAlive(t *testing.T) {
+	answer := int32(1)
+	pings := make(chan struct{}, 100)
+	address := newWebsocketTestServer(t, func(conn net.Conn, ws *websocketConn) {
+		for {
+			_, opcode, _, err := ws.readFrame()
+			if err != nil {
+				return
+			}
+			if opcode == websocketPing {
+				pings <- struct{}{}
+				if atomic.LoadInt32(&answer) == 1 {
+					writeServerFrame(conn, true, websocketPong, "")
+				}
+			}
+		}
+	})
+
+	ws, err := dialWebsocket(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.keepAlive = 50 * time.Millisecond
+	done := make(chan struct{})
+	defer close(done)
+	go ws.ping(done)
+
+	failed := make(chan error, 1)
+	go func() {
+		_, err := ws.ReadMessage()
+		failed <- err
+	}()
+
+	// Answered pings keep the connection alive for longer than the timeout
+	for i := 0; i < 6; i++ {
+		select {
+		case <-pings:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Client did not send ping")
+		}
+	}
+	select {
+	case err := <-failed:
+		t.Fatalf("Connection failed although the server answered all pings: %v", err)
+	default:
+	}
+
+	// A silent server has to be detected
+	atomic.StoreInt32(&answer, 0)
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Errorf("Reading from a silent server should fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Silent server was not detected")
+	}
+}
+
+func TestWebsocketConnectionLost(t *testing.T) {
+	address := newWebsocketTestServer(t, func(conn net.Conn, ws *websocketConn) {
+		writeServerFrame(conn, false, websocketText, `{"t":`) // incomplete message
+	})
+
+	ws, err := dialWebsocket(address, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if _, err := ws.ReadMessage(); err == nil {
+		t.Errorf("Reading from a dropped connection should fail")
+	}
+
+	// The server is still available for a new connection
+	reconnected, err := dialWebsocket(address, time.Second)
+	if err != nil {
+		t.Fatalf("Could not reconnect: %v", err)
+	}
+	reconnected.Close()
+}
+
+func TestWebsocketHandshake(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/wrong" {
+			w.Header().Set("Upgrade", "websocket")
+			w.Header().Set("Connection", "Upgrade")
+			w.Header().Set("Sec-WebSocket-Accept", websocketAccept("another key"))
+			w.WriteHeader(http.StatusSwitchingProtocols)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	address := "ws://" + strings.TrimPrefix(server.URL, "http://")
+	for _, path := range []string{"/wrong", "/missing"} {
+		ws, err := dialWebsocket(address+path, time.Second)
+		if err == nil {
+			ws.Close()
+			t.Errorf("Handshake with %s should fail", path)
+		}
+	}
+	if _, err := dialWebsocket("wss://"+strings.TrimPrefix(server.URL, "http://"), time.Second); err == nil {
+		t.Errorf("Secure websockets are not supported and should fail")
+	}
+}
+
+func TestWebsocketURL(t *testing.T) {
+	tests := map[string]string{
+		"192.168.1.10":      "ws://192.168.1.10:8088",
+		"192.168.1.10:8080": "ws://192.168.1.10:8088",
+		"[fe80::1]:80":      "ws://[fe80::1]:8088",
+	}
+	for host, want := range tests {
+		if got := websocketURL(host, 8088); got != want {
+			t.Errorf("websocketURL(%s) = %s; want %s", host, got, want)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const backendWLED = "wled"
+const wledDefaultID = "wled"
+
+// WLED accepts color temperatures in this range and reports them relative
+// to it as value between 0 and 255.
+const wledMinimumColorTemperature = 1900
+const wledMaximumColorTemperature = 10091
+
+// Light capabilities reported by /json/info
+const wledCapabilityRGB = 0x01
+const wledCapabilityCCT = 0x04
+
+// WLEDBridge controls one or more LED controllers running WLED via their
+// JSON API. Every controller is represented by a single light.
+type WLEDBridge struct {
+	ID          string
+	Controllers []*WLEDController
+}
+
+// WLEDController represents a single WLED installation.
+type WLEDController struct {
+	ID      int
+	Address string
+	client  *http.Client
+	info    wledInfo
+}
+
+// wledInfo contains the relevant fields of /json/info.
+type wledInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"ver"`
+	MAC     string `json:"mac"`
+	Leds    struct {
+		Count        int `json:"count"`
+		Capabilities int `json:"lc"`
+	} `json:"leds"`
+}
+
+// wledJSONState contains the relevant fields of /json/state.
+type wledJSONState struct {
+	On         bool `json:"on"`
+	Brightness int  `json:"bri"`
+	Segments   []struct {
+		Colors [][]int `json:"col"`
+		CCT    int     `json:"cct"`
+	} `json:"seg"`
+}
+
+// wledLightState is the state of a controller as reported by /json/state.
+type wledLightState struct {
+	Reachable  bool
+	On         bool
+	Brightness int
+	CCT        int
+	Color      []int
+}
+
+// WLEDLight represents a WLED controller. It implements the Device interface.
+type WLEDLight struct {
+	Name                   string
+	controller             *WLEDController
+	SupportsCCT            bool
+	SetColorTemperature    int
+	SetBrightness          int
+	TargetColorTemperature int
+	TargetCCT              int
+	TargetColor            []int
+	TargetBrightness       int
+	Current                wledLightState
+}
+
+// InitializeBridge connects to all WLED controllers configured at the given
+// index. Their addresses are separated by commas.
+func (bridge *WLEDBridge) InitializeBridge(ctx context.Context, configuration *Configuration, index int) error {
+	if index >= len(configuration.Bridges) {
+		return fmt.Errorf("Bridge %d is not configured", index)
+	}
+	bridgeConfiguration := &configuration.Bridges[index]
+	if strings.TrimSpace(bridgeConfiguration.IP) == "" {
+		return errors.New("No WLED controller configured. Please enter its address as ip in config.json")
+	}
+	if bridgeConfiguration.ID == "" {
+		bridgeConfiguration.ID = wledDefaultID
+	}
+	bridge.ID = bridgeConfiguration.ID
+
+	bridge.Controllers = []*WLEDController{}
+	for _, address := range strings.Split(bridgeConfiguration.IP, ",") {
+		address = strings.TrimSuffix(strings.TrimSpace(address), "/")
+		if address == "" {
+			continue
+		}
+		if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
+			address = "http://" + address
+		}
+		controller := &WLEDController{ID: len(bridge.Controllers) + 1, Address: address, client: &http.Client{Timeout: 5 * time.Second}}
+		bridge.Controllers = append(bridge.Controllers, controller)
+	}
+
+	// Controllers may be powered off. Don't fail if any of them answers.
+	var lastErr error
+	connected := 0
+	for _, controller := range bridge.Controllers {
+		err := controller.readInfo()
+		if err != nil {
+			log.Warningf("⌘ Could not connect to WLED controller %s: %v", controller.Address, err)
+			lastErr = err
+			continue
+		}
+		connected++
+	}
+	if connected == 0 {
+		return lastErr
+	}
+	log.Printf("⌘ Connection to %d of %d WLED controllers established", connected, len(bridge.Controllers))
+	return nil
+}
+
+// Lights returns a light for every controller that reported its capabilities.
+func (bridge *WLEDBridge) Lights() ([]*Light, error) {
+	var lights []*Light
+	for _, controller := range bridge.Controllers {
+		if controller.info.Name == "" {
+			err := controller.readInfo()
+			if err != nil {
+				log.Debugf("⌘ Could not connect to WLED controller %s: %v", controller.Address, err)
+				continue
+			}
+		}
+
+		var light Light
+		light.ID = controller.ID
+		light.BridgeID = bridge.ID
+		wledLight := &WLEDLight{Name: controller.info.Name, controller: controller}
+		wledLight.SupportsCCT = controller.info.Leds.Capabilities&wledCapabilityCCT != 0
+		state, err := controller.lightState()
+		if err != nil {
+			log.Warningf("⌘ Could not read state of WLED controller %s: %v", controller.Address, err)
+		}
+		wledLight.updateCurrentLightState(state)
+		light.Device = wledLight
+		light.Name = wledLight.Name
+		light.Reachable = wledLight.isReachable()
+		light.On = wledLight.isOn()
+		log.Debugf("💡 Light %s - Initialization complete. Identified as WLED %s with %d LEDs (CCT: %v, MAC: %s)", wledLight.Name, controller.info.Version, controller.info.Leds.Count, wledLight.SupportsCCT, controller.info.MAC)
+
+		lights = append(lights, &light)
+	}
+	return lights, nil
+}
+
+// LightStates returns the current state of every controller. Controllers
+// not answering are reported as unreachable.
+func (bridge *WLEDBridge) LightStates() (map[int]DeviceState, error) {
+	states := make(map[int]DeviceState)
+	for _, controller := range bridge.Controllers {
+		state, err := controller.lightState()
+		if err != nil {
+			log.Debugf("⌘ Could not read state of WLED controller %s: %v", controller.Address, err)
+		}
+		states[controller.ID] = state
+	}
+	return states, nil
+}
+
+// LightEvents returns nil as light states are polled.
+func (bridge *WLEDBridge) LightEvents() LightEventSource {
+	return nil
+}
+
+func (bridge *WLEDBridge) bridgeID() string {
+	return bridge.ID
+}
+
+func (bridge *WLEDBridge) deviceDirectory() (DeviceDirectory, error) {
+	directory := DeviceDirectory{make(map[int]string), make(map[string][]int), make(map[string][]int)}
+	for _, controller := range bridge.Controllers {
+		if controller.info.Name != "" {
+			directory.Lights[controller.ID] = controller.info.Name
+		}
+	}
+	return directory, nil
+}
+
+func (controller *WLEDController) readInfo() error {
+	var info wledInfo
+	err := controller.request("GET", "/json/info", nil, &info)
+	if err != nil {
+		return err
+	}
+	if info.Name == "" {
+		info.Name = controller.Address
+	}
+	if info.Leds.Capabilities == 0 {
+		// Firmware versions before 0.13 only support RGB
+		info.Leds.Capabilities = wledCapabilityRGB
+	}
+	controller.info = info
+	return nil
+}
+
+func (controller *WLEDController) lightState() (wledLightState, error) {
+	var response wledJSONState
+	err := controller.request("GET", "/json/state", nil, &response)
+	if err != nil {
+		return wledLightState{}, err
+	}
+
+	state := wledLightState{Reachable: true, On: response.On, Brightness: response.Brightness}
+	if len(response.Segments) > 0 {
+		state.CCT = response.Segments[0].CCT
+		if len(response.Segments[0].Colors) > 0 && len(response.Segments[0].Colors[0]) >= 3 {
+			state.Color = append([]int(nil), response.Segments[0].Colors[0][:3]...)
+		}
+	}
+	return state, nil
+}
+
+func (controller *WLEDController) request(method string, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, controller.Address+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := controller.client.Do(req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("WLED returned HTTP %d for %s %s", resp.StatusCode, method, path)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
+
+// wledBrightness maps the given brightness in percent to the range of WLED.
+func wledBrightness(brightness int) int {
+	if brightness == -1 {
+		return -1
+	}
+	if brightness > 100 {
+		brightness = 100
+	} else if brightness < 0 {
+		brightness = 0
+	}
+	return int(math.Round(float64(brightness) / 100 * 255))
+}
+
+// wledCCT returns the relative value WLED reports for the given color
+// temperature.
+func wledCCT(colorTemperature int) int {
+	if colorTemperature == -1 {
+		return -1
+	}
+	cct := (colorTemperature - wledMinimumColorTemperature) / 32
+	if cct < 0 {
+		return 0
+	}
+	if cct > 255 {
+		return 255
+	}
+	return cct
+}
+
+func (light *WLEDLight) name() string {
+	return light.Name
+}
+
+func (light *WLEDLight) isReachable() bool {
+	return light.Current.Reachable
+}
+
+func (light *WLEDLight) isOn() bool {
+	return light.Current.Reachable && light.Current.On
+}
+
+func (light *WLEDLight) supportsColorTemperature() bool {
+	return true
+}
+
+func (light *WLEDLight) supportsBrightness() bool {
+	return true
+}
+
+func (light *WLEDLight) supportsColor() bool {
+	return !light.SupportsCCT
+}
+
+func (light *WLEDLight) colorTemperatureRange() (int, int) {
+	if light.SupportsCCT {
+		return wledMinimumColorTemperature, wledMaximumColorTemperature
+	}
+	return 1000, 6500
+}
+
+func (light *WLEDLight) stateDescription() string {
+	return fmt.Sprintf("TargetColorTemperature: %d, TargetCCT: %d, CurrentCCT: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d", light.TargetColorTemperature, light.TargetCCT, light.Current.CCT, light.TargetColor, light.Current.Color, light.TargetBrightness, light.Current.Brightness)
+}
+
+func (light *WLEDLight) observedState() DeviceState {
+	return light.Current
+}
+
+func (light *WLEDLight) updateCurrentLightState(state DeviceState) {
+	current, ok := state.(wledLightState)
+	if !ok {
+		log.Warningf("💡 Light %s - Ignoring unknown light state %+v", light.Name, state)
+		return
+	}
+	light.Current = current
+}
+
+// setLightState sets the color temperature of all segments. Controllers
+// without white channels receive the matching RGB color instead.
+func (light *WLEDLight) setLightState(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.sendLightState(colorTemperature, brightness, transitionTime, false)
+}
+
+// turnOn switches the controller on and sets the given light state at once.
+func (light *WLEDLight) turnOn(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.sendLightState(colorTemperature, brightness, transitionTime, true)
+}
+
+func (light *WLEDLight) sendLightState(colorTemperature int, brightness int, transitionTime time.Duration, turnOn bool) error {
+	colorTemperature = light.adjustColorTemperature(colorTemperature)
+	light.SetColorTemperature = colorTemperature
+	light.SetBrightness = brightness
+	light.TargetColorTemperature = colorTemperature
+	light.TargetCCT = wledCCT(colorTemperature)
+	light.TargetColor = colorTemperatureToRGB(colorTemperature)
+	light.TargetBrightness = wledBrightness(brightness)
+
+	// A segment object instead of an array applies to all selected segments
+	command := make(map[string]interface{})
+	command["tt"] = int(transitionTime / time.Millisecond / 100)
+	if colorTemperature != -1 {
+		if light.SupportsCCT {
+			command["seg"] = map[string]int{"cct": colorTemperature}
+		} else {
+			command["seg"] = map[string][][]int{"col": {light.TargetColor}}
+		}
+	}
+	if brightness == 0 {
+		// Target brightness zero should turn the light off.
+		command["on"] = false
+	} else if brightness != -1 {
+		command["bri"] = light.TargetBrightness
+	}
+	if turnOn && brightness != 0 {
+		command["on"] = true
+	}
+
+	log.Debugf("💡 Light %s - Setting light state to %dK and %d%% brightness (%s)", light.Name, colorTemperature, brightness, light.stateDescription())
+	err := light.controller.request("POST", "/json/state", command, nil)
+	if err != nil {
+		return err
+	}
+
+	if colorTemperature != -1 {
+		if light.SupportsCCT {
+			light.Current.CCT = light.TargetCCT
+		} else {
+			light.Current.Color = light.TargetColor
+		}
+	}
+	if brightness == 0 {
+		light.Current.On = false
+	} else if turnOn {
+		light.Current.On = true
+	}
+	if brightness > 0 {
+		light.Current.Brightness = light.TargetBrightness
+	}
+	return nil
+}
+
+func (light *WLEDLight) hasChanged() bool {
+	return light.hasColorTemperatureChanged() || light.hasBrightnessChanged()
+}
+
+func (light *WLEDLight) hasColorTemperatureChanged() bool {
+	if light.TargetColorTemperature != -1 {
+		if light.SupportsCCT && !equalsInt(light.TargetCCT, light.Current.CCT, 1) {
+			log.Debugf("💡 Light %s - Color temperature has changed! CurrentCCT: %d, TargetCCT: %d (%dK)", light.Name, light.Current.CCT, light.TargetCCT, light.SetColorTemperature)
+			return true
+		}
+		if !light.SupportsCCT && !equalsRGB(light.TargetColor, light.Current.Color) {
+			log.Debugf("💡 Light %s - Color has changed! CurrentColor: %v, TargetColor: %v (%dK)", light.Name, light.Current.Color, light.TargetColor, light.SetColorTemperature)
+			return true
+		}
+	}
+	return false
+}
+
+func (light *WLEDLight) hasBrightnessChanged() bool {
+	if light.TargetBrightness != -1 && !equalsInt(light.TargetBrightness, light.Current.Brightness, 2) {
+		log.Debugf("💡 Light %s - Brightness has changed! CurrentBrightness: %d, TargetBrightness: %d (%d%%)", light.Name, light.Current.Brightness, light.TargetBrightness, light.SetBrightness)
+		return true
+	}
+	return false
+}
+
+func (light *WLEDLight) hasState(colorTemperature int, brightness int) bool {
+	if colorTemperature != -1 {
+		colorTemperature = light.adjustColorTemperature(colorTemperature)
+		if light.SupportsCCT && !equalsInt(light.Current.CCT, wledCCT(colorTemperature), 1) {
+			return false
+		}
+		if !light.SupportsCCT && !equalsRGB(light.Current.Color, colorTemperatureToRGB(colorTemperature)) {
+			return false
+		}
+	}
+	if brightness != -1 && !equalsInt(light.Current.Brightness, wledBrightness(brightness), 2) {
+		return false
+	}
+	return true
+}
+
+// adjustColorTemperature limits the given color temperature to the
+// range supported by this light.
+func (light *WLEDLight) adjustColorTemperature(colorTemperature int) int {
+	if colorTemperature == -1 {
+		return colorTemperature
+	}
+	minimum, maximum := light.colorTemperatureRange()
+	if colorTemperature < minimum {
+		return minimum
+	}
+	if colorTemperature > maximum {
+		return maximum
+	}
+	return colorTemperature
+}
+
+func equalsRGB(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for index := range a {
+		if !equalsInt(a[index], b[index], 2) {
+			return false
+		}
+	}
+	return true
+}
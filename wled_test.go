@@ -0,0 +1,162 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWLED emulates the JSON API of a WLED controller.
+type fakeWLED struct {
+	server       *httptest.Server
+	name         string
+	capabilities int
+	mutex        sync.Mutex
+	on           bool
+	brightness   int
+	cct          int
+	color        []int
+}
+
+func newFakeWLED(name string, capabilities int) *fakeWLED {
+	wled := &fakeWLED{name: name, capabilities: capabilities, on: true, brightness: 128, cct: 127, color: []int{255, 160, 0}}
+	wled.server = httptest.NewServer(http.HandlerFunc(wled.handle))
+	return wled
+}
+
+func (wled *fakeWLED) handle(w http.ResponseWriter, r *http.Request) {
+	wled.mutex.Lock()
+	defer wled.mutex.Unlock()
+
+	switch {
+	case r.URL.Path == "/json/info":
+		fmt.Fprintf(w, `{"ver":"0.14.0","name":"%s","mac":"c8c9a3a1b2c3","leds":{"count":60,"lc":%d}}`, wled.name, wled.capabilities)
+	case r.URL.Path == "/json/state" && r.Method == "GET":
+		fmt.Fprintf(w, `{"on":%v,"bri":%d,"transition":7,"seg":[{"id":0,"col":[[%d,%d,%d],[0,0,0],[0,0,0]],"cct":%d}]}`, wled.on, wled.brightness, wled.color[0], wled.color[1], wled.color[2], wled.cct)
+	case r.URL.Path == "/json/state" && r.Method == "POST":
+		var command struct {
+			On         *bool `json:"on"`
+			Brightness *int  `json:"bri"`
+			Segment    *struct {
+				CCT    *int    `json:"cct"`
+				Colors [][]int `json:"col"`
+			} `json:"seg"`
+		}
+		json.NewDecoder(r.Body).Decode(&command)
+		if command.On != nil {
+			wled.on = *command.On
+		}
+		if command.Brightness != nil {
+			wled.brightness = *command.Brightness
+		}
+		if command.Segment != nil && command.Segment.CCT != nil {
+			wled.cct = (*command.Segment.CCT - 1900) >> 5
+		}
+		if command.Segment != nil && len(command.Segment.Colors) > 0 {
+			wled.color = command.Segment.Colors[0]
+		}
+		fmt.Fprint(w, `{"success":true}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestWLEDBridge(t *testing.T) {
+	strip := newFakeWLED("Shelf", 7)
+	defer strip.server.Close()
+	rgbStrip := newFakeWLED("Stairs", 1)
+	defer rgbStrip.server.Close()
+
+	configuration := &Configuration{Bridges: []Bridge{{Type: backendWLED, IP: strip.server.URL + ", " + strings.TrimPrefix(rgbStrip.server.URL, "http://")}}}
+	bridge := &WLEDBridge{}
+	err := bridge.InitializeBridge(context.Background(), configuration, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lights, err := bridge.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lights) != 2 || lights[0].Name != "Shelf" || lights[1].ID != 2 || !lights[1].On {
+		t.Fatalf("Found lights %+v; want Shelf and Stairs", lights)
+	}
+
+	shelf := lights[0].Device.(*WLEDLight)
+	stairs := lights[1].Device.(*WLEDLight)
+	if !shelf.SupportsCCT || stairs.SupportsCCT {
+		t.Errorf("Only Shelf should support CCT")
+	}
+
+	for _, light := range lights {
+		err = light.Device.setLightState(2700, 80, 400*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strip.cct != 25 || strip.brightness != 204 {
+		t.Errorf("Shelf was set to CCT %d at brightness %d; want 25 at 204", strip.cct, strip.brightness)
+	}
+	if !equalsRGB(rgbStrip.color, colorTemperatureToRGB(2700)) {
+		t.Errorf("Stairs was set to color %v; want %v", rgbStrip.color, colorTemperatureToRGB(2700))
+	}
+
+	states, err := bridge.LightStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, light := range lights {
+		light.updateCurrentLightState(states[light.ID])
+		if !light.Device.hasState(2700, 80) || light.Device.hasChanged() {
+			t.Errorf("Light %s does not report the state it was set to: %s", light.Name, light.Device.stateDescription())
+		}
+	}
+
+	// Change the color like the WLED app would
+	rgbStrip.mutex.Lock()
+	rgbStrip.color = []int{0, 0, 255}
+	rgbStrip.mutex.Unlock()
+	states, _ = bridge.LightStates()
+	lights[1].updateCurrentLightState(states[2])
+	if !stairs.hasChanged() {
+		t.Errorf("Manual change was not detected: %s", stairs.stateDescription())
+	}
+}
+
+func TestColorTemperatureToRGB(t *testing.T) {
+	for _, rgb := range colorTemperatureToRGB(6500) {
+		if rgb < 240 {
+			t.Errorf("6500K should be white; got %v", colorTemperatureToRGB(6500))
+		}
+	}
+	warm := colorTemperatureToRGB(2000)
+	if warm[0] != 255 || warm[1] >= warm[0] || warm[2] >= warm[1] {
+		t.Errorf("2000K should be orange; got %v", warm)
+	}
+}
@@ -0,0 +1,695 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const backendZigbee2MQTT = "zigbee2mqtt"
+const zigbee2MQTTDefaultTopic = "zigbee2mqtt"
+const zigbee2MQTTReconnectInterval = 10 * time.Second
+
+// Zigbee2MQTTBridge controls all lights paired with a Zigbee2MQTT
+// coordinator via an MQTT broker. Every state change published by
+// Zigbee2MQTT is reported as light event.
+type Zigbee2MQTTBridge struct {
+	ID        string
+	Broker    string
+	BaseTopic string
+	client    *mqttClient
+	connected int32
+	events    chan LightEvent
+	mutex     sync.Mutex
+	devices   []zigbee2mqttDevice
+	groups    []zigbee2mqttGroup
+	ids       map[string]int
+	states    map[int]zigbee2mqttLightState
+	received  chan struct{}
+}
+
+// zigbee2mqttDevice represents a device as published on bridge/devices.
+type zigbee2mqttDevice struct {
+	IEEEAddress  string `json:"ieee_address"`
+	FriendlyName string `json:"friendly_name"`
+	Type         string `json:"type"`
+	Definition   *struct {
+		Model   string              `json:"model"`
+		Vendor  string              `json:"vendor"`
+		Exposes []zigbee2mqttExpose `json:"exposes"`
+	} `json:"definition"`
+}
+
+// zigbee2mqttExpose describes a capability of a device.
+type zigbee2mqttExpose struct {
+	Type     string              `json:"type"`
+	Name     string              `json:"name"`
+	ValueMin *int                `json:"value_min"`
+	ValueMax *int                `json:"value_max"`
+	Features []zigbee2mqttExpose `json:"features"`
+}
+
+// zigbee2mqttGroup represents a group as published on bridge/groups.
+type zigbee2mqttGroup struct {
+	FriendlyName string `json:"friendly_name"`
+	Members      []struct {
+		IEEEAddress string `json:"ieee_address"`
+	} `json:"members"`
+}
+
+// zigbee2mqttMessage contains the fields of a state message. Fields which
+// are not part of the message are nil.
+type zigbee2mqttMessage struct {
+	State      *string `json:"state"`
+	Brightness *int    `json:"brightness"`
+	ColorTemp  *int    `json:"color_temp"`
+	ColorMode  *string `json:"color_mode"`
+	Color      *struct {
+		X *float32 `json:"x"`
+		Y *float32 `json:"y"`
+	} `json:"color"`
+}
+
+// zigbee2mqttLightState is the last known state of a light.
+type zigbee2mqttLightState struct {
+	Available        bool
+	On               bool
+	Brightness       int
+	ColorTemperature int
+	ColorMode        string
+	Color            []float32
+}
+
+// Zigbee2MQTTLight represents a light paired with Zigbee2MQTT. It implements
+// the Device interface.
+type Zigbee2MQTTLight struct {
+	Name                     string
+	topic                    string
+	client                   *mqttClient
+	Dimmable                 bool
+	SupportsColorTemperature bool
+	SupportsXYColor          bool
+	MinimumColorTemperature  int
+	MaximumColorTemperature  int
+	SetColorTemperature      int
+	SetBrightness            int
+	TargetColorTemperature   int
+	TargetColor              []float32
+	TargetBrightness         int
+	Current                  zigbee2mqttLightState
+}
+
+// InitializeBridge connects to the MQTT broker of the bridge configured at
+// the given index and waits for Zigbee2MQTT to publish its devices.
+func (bridge *Zigbee2MQTTBridge) InitializeBridge(ctx context.Context, configuration *Configuration, index int) error {
+	if index >= len(configuration.Bridges) {
+		return fmt.Errorf("Bridge %d is not configured", index)
+	}
+	bridgeConfiguration := &configuration.Bridges[index]
+	if bridgeConfiguration.IP == "" {
+		return errors.New("No MQTT broker configured for Zigbee2MQTT. Please enter its address as ip in config.json")
+	}
+
+	bridge.Broker = bridgeConfiguration.IP
+	bridge.BaseTopic = strings.TrimSuffix(bridgeConfiguration.Topic, "/")
+	if bridge.BaseTopic == "" {
+		bridge.BaseTopic = zigbee2MQTTDefaultTopic
+	}
+	if bridgeConfiguration.ID == "" {
+		bridgeConfiguration.ID = bridge.BaseTopic
+	}
+	bridge.ID = bridgeConfiguration.ID
+	bridge.events = make(chan LightEvent, 64)
+	bridge.ids = make(map[string]int)
+	for address, id := range bridgeConfiguration.Devices {
+		bridge.ids[address] = id
+	}
+	bridge.states = make(map[int]zigbee2mqttLightState)
+	bridge.received = make(chan struct{})
+
+	bridge.client = newMQTTClient(bridge.Broker, hueBridgeAppName+"-"+bridge.ID, bridgeConfiguration.Username, bridgeConfiguration.Password, bridge.handleMessage)
+	err := bridge.connect()
+	if err != nil {
+		return err
+	}
+
+	// The device list is a retained message and should arrive immediately
+	select {
+	case <-bridge.received:
+	case <-time.After(10 * time.Second):
+		bridge.client.close()
+		return fmt.Errorf("Zigbee2MQTT did not publish its devices on %s/bridge/devices", bridge.BaseTopic)
+	}
+	log.Printf("⌘ Connection to Zigbee2MQTT bridge %s established", bridge.ID)
+	bridgeConfiguration.Devices = bridge.deviceIDs()
+
+	// Ask all lights to report their current state
+	for _, device := range bridge.lightDevices() {
+		bridge.client.publish(bridge.BaseTopic+"/"+device.FriendlyName+"/get", []byte(`{"state":""}`))
+	}
+	return nil
+}
+
+func (bridge *Zigbee2MQTTBridge) connect() error {
+	err := bridge.client.connect()
+	if err != nil {
+		return err
+	}
+	err = bridge.client.subscribe(bridge.BaseTopic + "/#")
+	if err != nil {
+		bridge.client.close()
+		return err
+	}
+	atomic.StoreInt32(&bridge.connected, 1)
+	return nil
+}
+
+// Lights returns all devices exposing light functionality.
+func (bridge *Zigbee2MQTTBridge) Lights() ([]*Light, error) {
+	var lights []*Light
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	for _, device := range bridge.lightDevices() {
+		var light Light
+		light.ID = bridge.ids[device.IEEEAddress]
+		light.BridgeID = bridge.ID
+
+		z2mLight := &Zigbee2MQTTLight{Name: device.FriendlyName, topic: bridge.BaseTopic + "/" + device.FriendlyName, client: bridge.client}
+		z2mLight.initialize(device)
+		z2mLight.updateCurrentLightState(bridge.state(light.ID))
+		light.Device = z2mLight
+		light.Name = z2mLight.Name
+		light.Reachable = z2mLight.isReachable()
+		light.On = z2mLight.isOn()
+
+		lights = append(lights, &light)
+	}
+
+	sort.Slice(lights, func(i, j int) bool { return lights[i].ID < lights[j].ID })
+	return lights, nil
+}
+
+// LightStates returns the last state reported by every light.
+func (bridge *Zigbee2MQTTBridge) LightStates() (map[int]DeviceState, error) {
+	states := make(map[int]DeviceState)
+	if !bridge.IsConnected() {
+		return states, fmt.Errorf("Not connected to MQTT broker %s", bridge.Broker)
+	}
+
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	for _, device := range bridge.lightDevices() {
+		id := bridge.ids[device.IEEEAddress]
+		states[id] = bridge.state(id)
+	}
+	return states, nil
+}
+
+// LightEvents returns the bridge itself as it reports every state message.
+func (bridge *Zigbee2MQTTBridge) LightEvents() LightEventSource {
+	return bridge
+}
+
+// Start keeps reconnecting to the broker whenever the connection drops.
+// It returns when the given context is done and should be run in its own
+// goroutine.
+func (bridge *Zigbee2MQTTBridge) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-bridge.client.Done():
+		}
+		atomic.StoreInt32(&bridge.connected, 0)
+		for {
+			log.Warningf("⌘ Connection to MQTT broker %s lost - Reconnecting in %v...", bridge.Broker, zigbee2MQTTReconnectInterval)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(zigbee2MQTTReconnectInterval):
+			}
+			err := bridge.connect()
+			if err == nil {
+				log.Printf("⌘ Reconnected to MQTT broker %s", bridge.Broker)
+				break
+			}
+			log.Debugf("⌘ Could not connect to MQTT broker %s: %v", bridge.Broker, err)
+		}
+	}
+}
+
+// close disconnects from the MQTT broker.
+func (bridge *Zigbee2MQTTBridge) close() {
+	atomic.StoreInt32(&bridge.connected, 0)
+	bridge.client.close()
+}
+
+// IsConnected returns true if the bridge is connected to the MQTT broker.
+func (bridge *Zigbee2MQTTBridge) IsConnected() bool {
+	return atomic.LoadInt32(&bridge.connected) == 1
+}
+
+// Events returns the channel all light events are sent to.
+func (bridge *Zigbee2MQTTBridge) Events() <-chan LightEvent {
+	return bridge.events
+}
+
+func (bridge *Zigbee2MQTTBridge) bridgeID() string {
+	return bridge.ID
+}
+
+// deviceDirectory returns the names of all lights. Groups of
+// Zigbee2MQTT are reported as rooms.
+func (bridge *Zigbee2MQTTBridge) deviceDirectory() (DeviceDirectory, error) {
+	directory := DeviceDirectory{make(map[int]string), make(map[string][]int), make(map[string][]int)}
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	for _, device := range bridge.lightDevices() {
+		directory.Lights[bridge.ids[device.IEEEAddress]] = device.FriendlyName
+	}
+	for _, group := range bridge.groups {
+		var ids []int
+		for _, member := range group.Members {
+			if id, found := bridge.ids[member.IEEEAddress]; found {
+				ids = append(ids, id)
+			}
+		}
+		directory.Rooms[group.FriendlyName] = ids
+	}
+	return directory, nil
+}
+
+// handleMessage is called for every message published below the base topic.
+func (bridge *Zigbee2MQTTBridge) handleMessage(topic string, payload []byte) {
+	if !strings.HasPrefix(topic, bridge.BaseTopic+"/") {
+		return
+	}
+	name := strings.TrimPrefix(topic, bridge.BaseTopic+"/")
+
+	switch {
+	case name == "bridge/devices":
+		var devices []zigbee2mqttDevice
+		err := json.Unmarshal(payload, &devices)
+		if err != nil {
+			log.Warningf("⌘ Could not parse devices of Zigbee2MQTT bridge %s: %v", bridge.ID, err)
+			return
+		}
+		bridge.updateDevices(devices)
+	case name == "bridge/groups":
+		var groups []zigbee2mqttGroup
+		err := json.Unmarshal(payload, &groups)
+		if err != nil {
+			log.Debugf("⌘ Could not parse groups of Zigbee2MQTT bridge %s: %v", bridge.ID, err)
+			return
+		}
+		bridge.mutex.Lock()
+		bridge.groups = groups
+		bridge.mutex.Unlock()
+	case strings.HasPrefix(name, "bridge/"), strings.HasSuffix(name, "/set"), strings.HasSuffix(name, "/get"):
+		// not relevant for us
+	case strings.HasSuffix(name, "/availability"):
+		// Payload is either "online" or {"state":"online"}
+		available := strings.Contains(string(payload), "online")
+		bridge.updateState(strings.TrimSuffix(name, "/availability"), func(state *zigbee2mqttLightState) {
+			state.Available = available
+		})
+	default:
+		var message zigbee2mqttMessage
+		err := json.Unmarshal(payload, &message)
+		if err != nil {
+			log.Debugf("⌘ Ignoring message on %s: %v", topic, err)
+			return
+		}
+		bridge.updateState(name, message.apply)
+	}
+}
+
+func (bridge *Zigbee2MQTTBridge) updateDevices(devices []zigbee2mqttDevice) {
+	bridge.mutex.Lock()
+	bridge.devices = devices
+
+	// Devices keep their IDs, even if they are removed. New devices are
+	// numbered in the order they were paired.
+	next := 1
+	for _, id := range bridge.ids {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	for _, device := range devices {
+		if _, found := bridge.ids[device.IEEEAddress]; !found && device.Type != "Coordinator" {
+			bridge.ids[device.IEEEAddress] = next
+			next++
+		}
+	}
+	bridge.mutex.Unlock()
+
+	select {
+	case <-bridge.received:
+	default:
+		close(bridge.received)
+	}
+	log.Debugf("⌘ Zigbee2MQTT bridge %s reported %d devices", bridge.ID, len(devices))
+}
+
+// deviceIDs returns a copy of the IDs of all devices by their address.
+func (bridge *Zigbee2MQTTBridge) deviceIDs() map[string]int {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+	ids := make(map[string]int)
+	for address, id := range bridge.ids {
+		ids[address] = id
+	}
+	return ids
+}
+
+func (bridge *Zigbee2MQTTBridge) updateState(name string, update func(state *zigbee2mqttLightState)) {
+	bridge.mutex.Lock()
+	id := 0
+	for _, device := range bridge.devices {
+		if device.FriendlyName == name {
+			id = bridge.ids[device.IEEEAddress]
+		}
+	}
+	if id == 0 {
+		bridge.mutex.Unlock()
+		return
+	}
+	state := bridge.state(id)
+	update(&state)
+	bridge.states[id] = state
+	bridge.mutex.Unlock()
+
+	// Don't block the connection if nobody is listening yet.
+	// The current state will be read via Lights() in this case.
+	select {
+	case bridge.events <- LightEvent{id, state.copy()}:
+	default:
+		log.Debugf("⌘ Dropped event for light %s", name)
+	}
+}
+
+// state returns a copy of the last known state of the given light.
+// The caller must hold the mutex.
+func (bridge *Zigbee2MQTTBridge) state(id int) zigbee2mqttLightState {
+	state, found := bridge.states[id]
+	if !found {
+		// Lights are considered available until Zigbee2MQTT tells us otherwise
+		return zigbee2mqttLightState{Available: true}
+	}
+	return state.copy()
+}
+
+// lightDevices returns all devices exposing a light.
+// The caller must hold the mutex.
+func (bridge *Zigbee2MQTTBridge) lightDevices() []zigbee2mqttDevice {
+	var devices []zigbee2mqttDevice
+	for _, device := range bridge.devices {
+		if device.lightExpose() != nil {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// lightExpose returns the light capability of the device or nil.
+func (device *zigbee2mqttDevice) lightExpose() *zigbee2mqttExpose {
+	if device.Definition == nil {
+		return nil
+	}
+	for index := range device.Definition.Exposes {
+		if device.Definition.Exposes[index].Type == "light" {
+			return &device.Definition.Exposes[index]
+		}
+	}
+	return nil
+}
+
+func (expose *zigbee2mqttExpose) feature(name string) *zigbee2mqttExpose {
+	for index := range expose.Features {
+		if expose.Features[index].Name == name {
+			return &expose.Features[index]
+		}
+	}
+	return nil
+}
+
+func (message zigbee2mqttMessage) apply(state *zigbee2mqttLightState) {
+	if message.State != nil {
+		state.On = *message.State == "ON"
+	}
+	if message.Brightness != nil {
+		state.Brightness = *message.Brightness
+	}
+	if message.ColorTemp != nil {
+		state.ColorTemperature = *message.ColorTemp
+	}
+	if message.ColorMode != nil {
+		state.ColorMode = *message.ColorMode
+	}
+	if message.Color != nil && message.Color.X != nil && message.Color.Y != nil {
+		state.Color = []float32{roundFloat(*message.Color.X, 3), roundFloat(*message.Color.Y, 3)}
+	}
+}
+
+func (state zigbee2mqttLightState) copy() zigbee2mqttLightState {
+	state.Color = append([]float32(nil), state.Color...)
+	return state
+}
+
+func (light *Zigbee2MQTTLight) initialize(device zigbee2mqttDevice) {
+	expose := device.lightExpose()
+	light.Dimmable = expose.feature("brightness") != nil
+	light.SupportsXYColor = expose.feature("color_xy") != nil
+
+	// Color temperatures are exposed in mired
+	if ct := expose.feature("color_temp"); ct != nil {
+		light.SupportsColorTemperature = true
+		light.MinimumColorTemperature = 2000
+		light.MaximumColorTemperature = 6500
+		if ct.ValueMax != nil && *ct.ValueMax > 0 {
+			light.MinimumColorTemperature = 1000000 / *ct.ValueMax
+		}
+		if ct.ValueMin != nil && *ct.ValueMin > 0 {
+			light.MaximumColorTemperature = 1000000 / *ct.ValueMin
+		}
+	} else if light.SupportsXYColor {
+		light.MinimumColorTemperature = 1000
+		light.MaximumColorTemperature = 6500
+	}
+
+	model := ""
+	if device.Definition != nil {
+		model = device.Definition.Vendor + " " + device.Definition.Model
+	}
+	log.Debugf("💡 Light %s - Initialization complete. Identified as %s (IEEE address: %s, Temperature range: %dK - %dK)", light.Name, model, device.IEEEAddress, light.MinimumColorTemperature, light.MaximumColorTemperature)
+}
+
+func (light *Zigbee2MQTTLight) name() string {
+	return light.Name
+}
+
+func (light *Zigbee2MQTTLight) isReachable() bool {
+	return light.Current.Available
+}
+
+func (light *Zigbee2MQTTLight) isOn() bool {
+	return light.Current.Available && light.Current.On
+}
+
+func (light *Zigbee2MQTTLight) supportsColorTemperature() bool {
+	return light.SupportsColorTemperature || light.SupportsXYColor
+}
+
+func (light *Zigbee2MQTTLight) supportsBrightness() bool {
+	return light.Dimmable
+}
+
+func (light *Zigbee2MQTTLight) supportsColor() bool {
+	return light.SupportsXYColor
+}
+
+func (light *Zigbee2MQTTLight) colorTemperatureRange() (int, int) {
+	return light.MinimumColorTemperature, light.MaximumColorTemperature
+}
+
+func (light *Zigbee2MQTTLight) stateDescription() string {
+	return fmt.Sprintf("TargetColorTemperature: %d, CurrentColorTemperature: %d, TargetColor: %v, CurrentColor: %v, TargetBrightness: %d, CurrentBrightness: %d, ColorMode: %s", light.TargetColorTemperature, light.Current.ColorTemperature, light.TargetColor, light.Current.Color, light.TargetBrightness, light.Current.Brightness, light.Current.ColorMode)
+}
+
+func (light *Zigbee2MQTTLight) observedState() DeviceState {
+	return light.Current
+}
+
+func (light *Zigbee2MQTTLight) updateCurrentLightState(state DeviceState) {
+	current, ok := state.(zigbee2mqttLightState)
+	if !ok {
+		log.Warningf("💡 Light %s - Ignoring unknown light state %+v", light.Name, state)
+		return
+	}
+	light.Current = current
+}
+
+func (light *Zigbee2MQTTLight) setLightState(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.publishLightState(colorTemperature, brightness, transitionTime, false)
+}
+
+// turnOn switches the light on and sets the given light state at once.
+func (light *Zigbee2MQTTLight) turnOn(colorTemperature int, brightness int, transitionTime time.Duration) error {
+	return light.publishLightState(colorTemperature, brightness, transitionTime, true)
+}
+
+func (light *Zigbee2MQTTLight) publishLightState(colorTemperature int, brightness int, transitionTime time.Duration, turnOn bool) error {
+	colorTemperature = light.adjustColorTemperature(colorTemperature)
+	light.SetColorTemperature = colorTemperature
+	light.SetBrightness = brightness
+	light.TargetColorTemperature = mapColorTemperature(colorTemperature)
+	light.TargetColor = colorTemperatureToXYColor(colorTemperature)
+	light.TargetBrightness = mapBrightness(brightness)
+
+	command := make(map[string]interface{})
+	command["transition"] = transitionTime.Seconds()
+	if colorTemperature != -1 {
+		if light.SupportsColorTemperature {
+			command["color_temp"] = light.TargetColorTemperature
+		} else if light.SupportsXYColor {
+			command["color"] = map[string]float32{"x": light.TargetColor[0], "y": light.TargetColor[1]}
+		}
+	}
+	if brightness == 0 {
+		// Target brightness zero should turn the light off.
+		command["state"] = "OFF"
+	} else if brightness != -1 && light.Dimmable {
+		command["brightness"] = light.TargetBrightness
+	}
+	if turnOn && brightness != 0 {
+		command["state"] = "ON"
+	}
+
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	log.Debugf("💡 Light %s - Publishing %s to %s/set", light.Name, payload, light.topic)
+	err = light.client.publish(light.topic+"/set", payload)
+	if err != nil {
+		return err
+	}
+
+	// Zigbee2MQTT will confirm the new state shortly. Assume it has been
+	// applied until then to not mistake it for a manual change.
+	if colorTemperature != -1 {
+		if light.SupportsColorTemperature {
+			light.Current.ColorTemperature = light.TargetColorTemperature
+			light.Current.ColorMode = "color_temp"
+		} else if light.SupportsXYColor {
+			light.Current.Color = light.TargetColor
+			light.Current.ColorMode = "xy"
+		}
+	}
+	if brightness > 0 && light.Dimmable {
+		light.Current.Brightness = light.TargetBrightness
+	}
+	if turnOn && brightness != 0 {
+		light.Current.On = true
+	}
+	return nil
+}
+
+func (light *Zigbee2MQTTLight) hasChanged() bool {
+	return light.hasColorTemperatureChanged() || light.hasBrightnessChanged()
+}
+
+func (light *Zigbee2MQTTLight) hasColorTemperatureChanged() bool {
+	if light.SupportsColorTemperature && light.TargetColorTemperature != -1 {
+		if light.Current.ColorMode != "" && light.Current.ColorMode != "color_temp" {
+			log.Debugf("💡 Light %s - Color mode has changed to %s", light.Name, light.Current.ColorMode)
+			return true
+		}
+		if !equalsInt(light.TargetColorTemperature, light.Current.ColorTemperature, 2) {
+			log.Debugf("💡 Light %s - Color temperature has changed! CurrentColorTemperature: %d, TargetColorTemperature: %d (%dK)", light.Name, light.Current.ColorTemperature, light.TargetColorTemperature, light.SetColorTemperature)
+			return true
+		}
+	} else if light.SupportsXYColor && light.TargetColorTemperature != -1 && light.Current.ColorMode == "xy" {
+		if !equalsFloat(light.TargetColor, light.Current.Color, 0.001) {
+			log.Debugf("💡 Light %s - Color has changed! CurrentColor: %v, TargetColor: %v (%dK)", light.Name, light.Current.Color, light.TargetColor, light.SetColorTemperature)
+			return true
+		}
+	}
+	return false
+}
+
+func (light *Zigbee2MQTTLight) hasBrightnessChanged() bool {
+	if light.Dimmable && light.TargetBrightness != -1 && !equalsInt(light.TargetBrightness, light.Current.Brightness, 2) {
+		log.Debugf("💡 Light %s - Brightness has changed! CurrentBrightness: %d, TargetBrightness: %d (%d%%)", light.Name, light.Current.Brightness, light.TargetBrightness, light.SetBrightness)
+		return true
+	}
+	return false
+}
+
+func (light *Zigbee2MQTTLight) hasState(colorTemperature int, brightness int) bool {
+	if colorTemperature != -1 && light.TargetColorTemperature != -1 {
+		colorTemperature = light.adjustColorTemperature(colorTemperature)
+		if light.SupportsColorTemperature {
+			if light.Current.ColorMode != "color_temp" || !equalsInt(light.Current.ColorTemperature, mapColorTemperature(colorTemperature), 2) {
+				return false
+			}
+		} else if light.SupportsXYColor {
+			if light.Current.ColorMode != "xy" || !equalsFloat(light.Current.Color, colorTemperatureToXYColor(colorTemperature), 0.001) {
+				return false
+			}
+		}
+	}
+	if brightness != -1 && light.TargetBrightness != -1 && light.Dimmable {
+		if !equalsInt(light.Current.Brightness, mapBrightness(brightness), 2) {
+			return false
+		}
+	}
+	return true
+}
+
+// adjustColorTemperature limits the given color temperature to the
+// range supported by this light.
+func (light *Zigbee2MQTTLight) adjustColorTemperature(colorTemperature int) int {
+	if colorTemperature == -1 {
+		return colorTemperature
+	}
+	if colorTemperature < light.MinimumColorTemperature {
+		colorTemperature = light.MinimumColorTemperature
+	}
+	if light.MaximumColorTemperature != 0 && colorTemperature > light.MaximumColorTemperature {
+		colorTemperature = light.MaximumColorTemperature
+	}
+	return colorTemperature
+}
@@ -0,0 +1,134 @@
+// MIT License
+//
+// Copyright (c) 2019 Stefan Wichmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const zigbee2MQTTDevices = `[
+  {"ieee_address": "0x00124b0014d9b6c1", "friendly_name": "Coordinator", "type": "Coordinator"},
+  {"ieee_address": "0x0017880104e45517", "friendly_name": "Desk", "type": "Router", "definition": {"model": "9290012573A", "vendor": "Philips",
+    "exposes": [{"type": "light", "features": [{"name": "state"}, {"name": "brightness", "value_max": 254}, {"name": "color_temp", "value_min": 153, "value_max": 500}, {"name": "color_xy"}]}]}},
+  {"ieee_address": "0x00158d0002c4e7a3", "friendly_name": "Kitchen/Sensor", "type": "EndDevice", "definition": {"model": "WSDCGQ11LM", "vendor": "Xiaomi",
+    "exposes": [{"type": "numeric", "name": "temperature"}]}},
+  {"ieee_address": "0x000b57fffe8f5d12", "friendly_name": "Kitchen/Ceiling", "type": "Router", "definition": {"model": "LED1545G12", "vendor": "IKEA",
+    "exposes": [{"type": "light", "features": [{"name": "state"}, {"name": "brightness"}, {"name": "color_temp", "value_min": 250, "value_max": 454}]}]}}
+]`
+
+func newTestZigbee2MQTTBridge() *Zigbee2MQTTBridge {
+	bridge := &Zigbee2MQTTBridge{ID: "zigbee2mqtt", BaseTopic: "zigbee2mqtt", connected: 1}
+	bridge.events = make(chan LightEvent, 64)
+	bridge.ids = make(map[string]int)
+	bridge.states = make(map[int]zigbee2mqttLightState)
+	bridge.received = make(chan struct{})
+	bridge.handleMessage("zigbee2mqtt/bridge/devices", []byte(zigbee2MQTTDevices))
+	return bridge
+}
+
+func TestZigbee2MQTTDeviceIDs(t *testing.T) {
+	bridge := &Zigbee2MQTTBridge{ID: "zigbee2mqtt", BaseTopic: "zigbee2mqtt"}
+	bridge.ids = map[string]int{"0x000b57fffe8f5d12": 3, "0x0017880104e45518": 7}
+	bridge.received = make(chan struct{})
+	bridge.handleMessage("zigbee2mqtt/bridge/devices", []byte(zigbee2MQTTDevices))
+
+	ids := bridge.deviceIDs()
+	if ids["0x000b57fffe8f5d12"] != 3 || ids["0x0017880104e45517"] != 8 || ids["0x0017880104e45518"] != 7 {
+		t.Errorf("Devices have IDs %v; want saved IDs to be kept and new devices to be numbered after them", ids)
+	}
+}
+
+func TestZigbee2MQTTLights(t *testing.T) {
+	bridge := newTestZigbee2MQTTBridge()
+	lights, err := bridge.Lights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lights) != 2 {
+		t.Fatalf("Found %d lights; want 2", len(lights))
+	}
+
+	desk := lights[0].Device.(*Zigbee2MQTTLight)
+	if lights[0].ID != 1 || desk.Name != "Desk" || !desk.Dimmable || !desk.SupportsColorTemperature || !desk.SupportsXYColor {
+		t.Errorf("Desk was not initialized correctly: %+v", desk)
+	}
+	if desk.MinimumColorTemperature != 2000 || desk.MaximumColorTemperature != 6535 {
+		t.Errorf("Desk supports %dK - %dK; want 2000K - 6535K", desk.MinimumColorTemperature, desk.MaximumColorTemperature)
+	}
+	ceiling := lights[1].Device.(*Zigbee2MQTTLight)
+	if lights[1].ID != 3 || ceiling.topic != "zigbee2mqtt/Kitchen/Ceiling" || ceiling.SupportsXYColor {
+		t.Errorf("Ceiling was not initialized correctly: %+v", ceiling)
+	}
+	if ceiling.MinimumColorTemperature != 2202 || ceiling.MaximumColorTemperature != 4000 {
+		t.Errorf("Ceiling supports %dK - %dK; want 2202K - 4000K", ceiling.MinimumColorTemperature, ceiling.MaximumColorTemperature)
+	}
+}
+
+func TestZigbee2MQTTStateChanges(t *testing.T) {
+	bridge := newTestZigbee2MQTTBridge()
+	lights, _ := bridge.Lights()
+	light := lights[1]
+	device := light.Device.(*Zigbee2MQTTLight)
+
+	bridge.handleMessage("zigbee2mqtt/Kitchen/Ceiling", []byte(`{"state":"ON","brightness":254,"color_temp":370,"color_mode":"color_temp","linkquality":87}`))
+	event := <-bridge.Events()
+	if event.ID != light.ID {
+		t.Fatalf("Received event for light %d; want %d", event.ID, light.ID)
+	}
+	light.updateCurrentLightState(event.State)
+	if !light.On || !light.Reachable {
+		t.Errorf("Light should be on and reachable: %+v", device.Current)
+	}
+
+	// Kelvin set this state
+	device.SetColorTemperature, device.SetBrightness = 2700, 100
+	device.TargetColorTemperature, device.TargetBrightness = mapColorTemperature(2700), mapBrightness(100)
+	if device.hasChanged() || !device.hasState(2700, 100) {
+		t.Errorf("Light should have state 2700K at 100%%: %s", device.stateDescription())
+	}
+
+	// Someone dimmed the light
+	bridge.handleMessage("zigbee2mqtt/Kitchen/Ceiling", []byte(`{"brightness":100}`))
+	light.updateCurrentLightState((<-bridge.Events()).State)
+	if !device.hasChanged() || !device.hasBrightnessChanged() || device.hasColorTemperatureChanged() {
+		t.Errorf("Manual change of brightness was not detected: %s", device.stateDescription())
+	}
+
+	// Kelvin should keep adjusting the color temperature only
+	light.Scheduled, light.Tracking, light.Appearance = true, true, time.Now().Add(-time.Minute)
+	light.setAutomatic(true)
+	light.TargetLightState = LightState{2700, 100}
+	light.update(0)
+	if !light.Automatic || !light.AutomaticColorTemperature || light.AutomaticBrightness {
+		t.Errorf("Only brightness should be manual: %+v", light)
+	}
+	if colorTemperature, brightness := light.automaticLightState(); colorTemperature != 2700 || brightness != -1 {
+		t.Errorf("Automatic light state should be 2700K at -1%%, got %dK at %d%%", colorTemperature, brightness)
+	}
+
+	bridge.handleMessage("zigbee2mqtt/Kitchen/Ceiling/availability", []byte(`{"state":"offline"}`))
+	light.updateCurrentLightState((<-bridge.Events()).State)
+	if light.Reachable || light.On {
+		t.Errorf("Light should be unreachable")
+	}
+}